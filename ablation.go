@@ -0,0 +1,159 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+const (
+	// disabled exits never trigger, kept json safe and far from overflow
+	disabledTPSL         = math.MaxFloat64
+	disabledExpiryMillis = math.MaxInt64 / 2
+)
+
+// Ablation backtests an agent with each indicator removed in turn and
+// with each of its exits, take profit, stop loss and expiry, disabled
+type Ablation struct {
+	// Fitness defaults to ProfitPerDayFitness
+	Fitness   Fitness
+	Evaluator *Evaluator
+}
+
+type AblationMetrics struct {
+	Trades       int     `json:"trades"`
+	ProfitPerDay float64 `json:"profit_per_day"`
+	HitRatio     float64 `json:"hit_ratio"`
+	MaxDrawdown  float64 `json:"max_drawdown"`
+	Fitness      float64 `json:"fitness"`
+}
+
+// AblationEntry holds the metrics of the agent without a part. Index is
+// the indicator index, -1 for exits. NeverBinding parts leave trades
+// unchanged when removed, Redundant parts don't lower the fitness
+type AblationEntry struct {
+	Name         string          `json:"name"`
+	Index        int             `json:"index"`
+	Metrics      AblationMetrics `json:"metrics"`
+	NeverBinding bool            `json:"never_binding"`
+	Redundant    bool            `json:"redundant"`
+}
+
+type AblationReport struct {
+	Base    AblationMetrics  `json:"base"`
+	Entries []*AblationEntry `json:"entries"`
+}
+
+func (ab *Ablation) Run(ctx context.Context, ag *Agent, pc *PairColumns) (*AblationReport, error) {
+	fitness := ab.Fitness
+	if fitness == nil {
+		fitness = ProfitPerDayFitness
+	}
+	ev := ab.Evaluator
+	if ev == nil {
+		ev = &Evaluator{}
+	}
+
+	agents := []*Agent{ag}
+	entries := make([]*AblationEntry, 0)
+	for i, ind := range ag.Indicators {
+		agents = append(agents, ag.withoutIndicator(i))
+		entries = append(entries, &AblationEntry{Name: fmt.Sprintf("%d.%s", i, ind.Kind()), Index: i})
+	}
+	for _, name := range []string{"tpsl.tp", "tpsl.sl", "expiry"} {
+		c := ag.Clone()
+		switch name {
+		case "tpsl.tp":
+			c.Tpsl.TakeProfit = disabledTPSL
+		case "tpsl.sl":
+			c.Tpsl.StopLoss = disabledTPSL
+		case "expiry":
+			c.ExpiryMillis = disabledExpiryMillis
+		}
+		agents = append(agents, c)
+		entries = append(entries, &AblationEntry{Name: name, Index: -1})
+	}
+
+	buckets, err := ev.Evaluate(ctx, agents, pc)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &AblationReport{Base: ablationMetrics(buckets[0], fitness), Entries: entries}
+	for i, en := range entries {
+		bu := buckets[i+1]
+		en.Metrics = ablationMetrics(bu, fitness)
+		en.NeverBinding = tradesEqual(buckets[0].Trades, bu.Trades)
+		en.Redundant = en.Metrics.Fitness >= rep.Base.Fitness
+	}
+	return rep, nil
+}
+
+func ablationMetrics(bu *Bucket, fitness Fitness) AblationMetrics {
+	return AblationMetrics{
+		Trades:       len(bu.Trades),
+		ProfitPerDay: bu.ProfitPerDay(),
+		HitRatio:     bu.HitRatio(),
+		MaxDrawdown:  bu.MaxDrawdown(),
+		Fitness:      fitness(bu),
+	}
+}
+
+func tradesEqual(trs1 []*Trade, trs2 []*Trade) bool {
+	if len(trs1) != len(trs2) {
+		return false
+	}
+	for i := range trs1 {
+		if *trs1[i] != *trs2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutIndicator is a clone of the agent without indicator i
+func (ag *Agent) withoutIndicator(i int) *Agent {
+	c := ag.Clone()
+	c.removeIndicator(i)
+	return c
+}
+
+// removeIndicator drops indicator i in place. Rule agents also drop the
+// indicators the rule no longer refers to, an agent left without
+// indicators is always active
+func (ag *Agent) removeIndicator(i int) {
+	ag.Indicators = append(ag.Indicators[:i:i], ag.Indicators[i+1:]...)
+	ag.syncLegacyFields()
+	if ag.Rule == nil {
+		return
+	}
+	ag.Rule = ag.Rule.without(i)
+	ag.dropUnreferenced()
+}
+
+// dropUnreferenced keeps the indicators the rule refers to, a nil rule
+// here refers to none
+func (ag *Agent) dropUnreferenced() {
+	used := make([]bool, len(ag.Indicators))
+	if ag.Rule != nil {
+		for _, n := range ag.Rule.nodes() {
+			if n.Op == OpInd {
+				used[n.Index] = true
+			}
+		}
+	}
+
+	inds, perm := make(Indicators, 0, len(ag.Indicators)), make([]int, len(ag.Indicators))
+	for j, ind := range ag.Indicators {
+		if used[j] {
+			perm[j] = len(inds)
+			inds = append(inds, ind)
+		}
+	}
+	if ag.Rule != nil {
+		ag.Rule.remap(perm)
+		ag.Rule.sortByCost(inds)
+	}
+	ag.Indicators = inds
+	ag.syncLegacyFields()
+}
@@ -0,0 +1,78 @@
+package agent2
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func TestAgent_WithoutIndicator(t *testing.T) {
+	bb := &BB{Mon: CloseR, Period: 100, Multiplier: 1}
+	rsi := &RSI{Mon: CloseR, TargetVal: 50, Period: 60}
+	mfi := &MFI{TargetVal: 50, Period: 20}
+	ag := robustnessAgent()
+	ag.Indicators = Indicators{mfi, rsi, bb}
+
+	c := ag.withoutIndicator(1)
+	if len(c.Indicators) != 2 || c.Indicators[1].(*BB).Period != 100 || c.Rule != nil {
+		t.Errorf("expected mfi and bb left without a rule, returned %v", c.Indicators)
+	}
+	if len(ag.Indicators) != 3 || ag.Indicators[1] != rsi {
+		t.Errorf("expected source agent untouched")
+	}
+
+	// rsi is only referred next to bb, mfi is left unreferenced
+	ag.Rule = OrRule(AndRule(IndRule(1), IndRule(2)), NotRule(IndRule(0)))
+	c = ag.withoutIndicator(0)
+	if len(c.Indicators) != 2 || c.Rule.Validate(2) != nil || len(c.Rule.Children) != 1 {
+		t.Fatalf("expected rsi and bb under the rule, returned %v", c.Indicators)
+	}
+
+	ag.Rule = IndRule(1)
+	c = ag.withoutIndicator(1)
+	if len(c.Indicators) != 0 || c.Rule != nil {
+		t.Errorf("expected an agent without indicators, returned %v", c.Indicators)
+	}
+}
+
+func TestAblation_Run(t *testing.T) {
+	rand.Seed(89)
+	pc, _ := NewPairColumns(randomWalkKlines(3_000, 1), randomWalkKlines(3_000, 2))
+	ag := robustnessAgent()
+	// a duplicate never binds, neither does its twin
+	ag.Indicators = append(ag.Indicators, ag.Indicators[1].Clone())
+
+	rep, err := (&Ablation{}).Run(context.Background(), ag, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+
+	bu, _ := ag.Backtest(pc)
+	if rep.Base.Trades != len(bu.Trades) || rep.Base.Fitness != bu.ProfitPerDay() {
+		t.Errorf("unexpected base metrics %+v", rep.Base)
+	}
+	if rep.Base.Trades == 0 {
+		t.Fatalf("expected base trades")
+	}
+
+	names := []string{"0.rsi", "1.bb", "2.bb", "tpsl.tp", "tpsl.sl", "expiry"}
+	if len(rep.Entries) != len(names) {
+		t.Fatalf("expected %d entries, returned %d", len(names), len(rep.Entries))
+	}
+	for i, en := range rep.Entries {
+		if en.Name != names[i] {
+			t.Errorf("expected entry %s, returned %s", names[i], en.Name)
+		}
+	}
+	for _, en := range rep.Entries[1:3] {
+		if !en.NeverBinding || !en.Redundant || en.Metrics != rep.Base {
+			t.Errorf("expected %s never binding, returned %+v", en.Name, en)
+		}
+	}
+	if rsi := rep.Entries[0]; rsi.NeverBinding || rsi.Metrics.Trades == rep.Base.Trades {
+		t.Errorf("expected rsi to bind, returned %+v", rsi)
+	}
+	if sl := rep.Entries[4]; sl.NeverBinding {
+		t.Errorf("expected stop loss to bind")
+	}
+}
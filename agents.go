@@ -3,13 +3,14 @@ package agent2
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
+	"math"
+	"math/rand"
 
 	"github.com/varga-lp/data/klines"
 )
 
-// agent has rsi, bb indicators
-// indicators sorted by their period asc as an array
+// agent has registered indicators (bb, rsi, mfi, obv, vwap)
+// indicators sorted by their cost, then period asc as an array
 // both bb, rsi calc speed is similar, min period is
 // ~5x faster than max period
 // agent has tp, sl, pos. expiry millis, backoff millis
@@ -42,16 +43,118 @@ func (cr ClosingReason) String() string {
 }
 
 const (
-	maxBBCount  = 5
-	maxRSICount = 5
+	maxBBCount   = 5
+	maxRSICount  = 5
+	maxMFICount  = 3
+	maxOBVCount  = 3
+	maxVWAPCount = 3
 )
 
+// chances in percent that RandomAgent draws a kind at all, flow kinds
+// are optional so agents stay as loose as the bb and rsi baseline
+const (
+	bbProb   = 100
+	rsiProb  = 100
+	mfiProb  = 25
+	obvProb  = 25
+	vwapProb = 25
+)
+
+// Agent is safe for concurrent use as long as it isn't modified, OpenPos,
+// ClosePos, Signals and Backtest only read it. Mutate, MutateRule and
+// UnmarshalJSON need exclusive access, evolve a Clone instead
 type Agent struct {
-	Tpsl         *TPSL    `json:"tpsl"`
-	Backoff      *Backoff `json:"backoff"`
-	ExpiryMillis int64    `json:"expiry_mls"`
-	Bbs          []*BB    `json:"bbs"`
-	Rsis         []*RSI   `json:"rsis"`
+	Tpsl         *TPSL      `json:"tpsl"`
+	Backoff      *Backoff   `json:"backoff"`
+	ExpiryMillis int64      `json:"expiry_mls"`
+	Indicators   Indicators `json:"indicators"`
+	Rule         *Rule      `json:"rule,omitempty"`
+
+	// Deprecated: Bbs and Rsis hold the bb and rsi indicators of
+	// Indicators in their order, for callers from before the tagged
+	// union. They are filled by RandomAgent, UnmarshalJSON, Clone and
+	// the changes of this package and share the indicators, so params
+	// set through them apply. Adding or removing through them doesn't,
+	// set Indicators instead. They will be removed in the next version
+	Bbs  []*BB  `json:"-"`
+	Rsis []*RSI `json:"-"`
+}
+
+// syncLegacyFields fills the deprecated Bbs and Rsis from Indicators,
+// called after every change of the indicators in this package
+func (ag *Agent) syncLegacyFields() {
+	ag.Bbs, ag.Rsis = nil, nil
+	for _, ind := range ag.Indicators {
+		switch x := ind.(type) {
+		case *BB:
+			ag.Bbs = append(ag.Bbs, x)
+		case *RSI:
+			ag.Rsis = append(ag.Rsis, x)
+		}
+	}
+}
+
+// legacyIndicators are the typed indicator slices agents were
+// marshalled with before indicators became a tagged union
+type legacyIndicators struct {
+	Bbs   []*BB   `json:"bbs"`
+	Rsis  []*RSI  `json:"rsis"`
+	Mfis  []*MFI  `json:"mfis"`
+	Obvs  []*OBV  `json:"obvs"`
+	Vwaps []*VWAP `json:"vwaps"`
+}
+
+func (li *legacyIndicators) indicators() Indicators {
+	inds := make(Indicators, 0)
+	for _, bb := range li.Bbs {
+		inds = append(inds, bb)
+	}
+	for _, rsi := range li.Rsis {
+		inds = append(inds, rsi)
+	}
+	for _, mfi := range li.Mfis {
+		inds = append(inds, mfi)
+	}
+	for _, obv := range li.Obvs {
+		inds = append(inds, obv)
+	}
+	for _, vw := range li.Vwaps {
+		inds = append(inds, vw)
+	}
+	sortIndicators(inds)
+	return inds
+}
+
+func (ag *Agent) UnmarshalJSON(pload []byte) error {
+	type agentAlias Agent
+	aux := struct {
+		*agentAlias
+		legacyIndicators
+	}{
+		agentAlias: (*agentAlias)(ag),
+	}
+
+	if err := json.Unmarshal(pload, &aux); err != nil {
+		return err
+	}
+	if ag.Indicators == nil {
+		if inds := aux.legacyIndicators.indicators(); len(inds) > 0 {
+			for _, ind := range inds {
+				if err := checkTimeframe(ind); err != nil {
+					return err
+				}
+			}
+			ag.Indicators = inds
+		}
+	}
+	if ag.Rule != nil {
+		if err := ag.Rule.Validate(len(ag.Indicators)); err != nil {
+			return err
+		}
+		ag.Rule.sortByCost(ag.Indicators)
+	}
+	ag.syncLegacyFields()
+	return nil
 }
 
 func (ag *Agent) Marshal() ([]byte, error) {
@@ -71,42 +174,130 @@ func UnmarshalAgent(pload []byte) (*Agent, error) {
 	return &agent, nil
 }
 
-func RandomAgent() *Agent {
-	bbMons, rsiMons := make(map[Monitor]struct{}), make(map[Monitor]struct{})
+func (ag *Agent) Clone() *Agent {
+	c := &Agent{
+		ExpiryMillis: ag.ExpiryMillis,
+		Indicators:   ag.Indicators.Clone(),
+		Rule:         ag.Rule.Clone(),
+	}
+	if ag.Tpsl != nil {
+		tpsl := *ag.Tpsl
+		c.Tpsl = &tpsl
+	}
+	if ag.Backoff != nil {
+		backoff := *ag.Backoff
+		c.Backoff = &backoff
+	}
+	c.syncLegacyFields()
+	return c
+}
+
+// sortIndicators orders indicators by cost keeping the rule pointing
+// to the same indicators
+func (ag *Agent) sortIndicators() {
+	order := make([]Indicator, len(ag.Indicators))
+	copy(order, ag.Indicators)
+	sortIndicators(ag.Indicators)
+	ag.syncLegacyFields()
+
+	if ag.Rule == nil {
+		return
+	}
+	pos := make(map[Indicator]int, len(ag.Indicators))
+	for i, ind := range ag.Indicators {
+		pos[ind] = i
+	}
+	perm := make([]int, len(order))
+	for i, ind := range order {
+		perm[i] = pos[ind]
+	}
+	ag.Rule.remap(perm)
+	ag.Rule.sortByCost(ag.Indicators)
+}
+
+// MutateRule changes the structure of the entry rule, agents without
+// a rule get the explicit all indicators rule mutated
+func (ag *Agent) MutateRule() {
+	if len(ag.Indicators) == 0 {
+		return
+	}
+	if ag.Rule == nil {
+		ag.Rule = allRule(len(ag.Indicators))
+	}
+	ag.Rule.Mutate(len(ag.Indicators))
+	ag.Rule.sortByCost(ag.Indicators)
+}
+
+// Mutate changes the agent in place at a random spot, an indicator, the
+// entry rule or a numeric param moved by a step
+func (ag *Agent) Mutate() {
+	switch rand.Intn(3) {
+	case 0:
+		if len(ag.Indicators) > 0 {
+			ag.Indicators[rand.Intn(len(ag.Indicators))].Mutate()
+			ag.sortIndicators()
+			return
+		}
+	case 1:
+		if len(ag.Indicators) > 0 {
+			ag.MutateRule()
+			return
+		}
+	}
+	ag.mutateParam()
+}
 
+func (ag *Agent) mutateParam() {
+	params := ag.Params()
+	p := params[rand.Intn(len(params))]
+
+	v := p.Value + p.Step
+	if rand.Intn(2) == 0 {
+		v = p.Value - p.Step
+	}
+	p.Set(math.Max(p.Min, math.Min(p.Max, v)))
+	ag.sortIndicators()
+}
+
+const (
+	ruleAgentProb = 30
+)
+
+// RandomAgent includes each registered indicator kind with its Prob and
+// draws an included kind MaxCount times, keeping one indicator per
+// monitor for each kind. ruleAgentProb of agents get a random entry
+// rule instead of requiring all indicators
+func RandomAgent() *Agent {
 	ag := &Agent{
 		Tpsl:         RandomTPSL(),
 		Backoff:      RandomBackoff(),
 		ExpiryMillis: randExpiry(),
-		Bbs:          make([]*BB, 0, maxBBCount),
-		Rsis:         make([]*RSI, 0, maxRSICount),
+		Indicators:   make(Indicators, 0),
 	}
 
-	for i := 0; i < maxBBCount; i++ {
-		bb := RandomBB()
-
-		if _, ok := bbMons[bb.Mon]; !ok {
-			bbMons[bb.Mon] = struct{}{}
-
-			ag.Bbs = append(ag.Bbs, bb)
+	for _, spec := range registeredSpecs() {
+		if rand.Intn(100) >= spec.Prob {
+			continue
 		}
-	}
-	for i := 0; i < maxRSICount; i++ {
-		rsi := RandomRSI()
+		mons := make(map[Monitor]struct{})
 
-		if _, ok := rsiMons[rsi.Mon]; !ok {
-			rsiMons[rsi.Mon] = struct{}{}
+		for i := 0; i < spec.MaxCount; i++ {
+			ind := spec.Random()
 
-			ag.Rsis = append(ag.Rsis, rsi)
+			if _, ok := mons[ind.Monitor()]; !ok {
+				mons[ind.Monitor()] = struct{}{}
+
+				ag.Indicators = append(ag.Indicators, ind)
+			}
 		}
 	}
-	sort.Slice(ag.Bbs[:], func(i, j int) bool {
-		return ag.Bbs[i].Period < ag.Bbs[j].Period
-	})
-	sort.Slice(ag.Rsis[:], func(i, j int) bool {
-		return ag.Rsis[i].Period < ag.Rsis[j].Period
-	})
+	sortIndicators(ag.Indicators)
 
+	if rand.Intn(100) < ruleAgentProb {
+		ag.Rule = randomRule(len(ag.Indicators))
+		ag.Rule.sortByCost(ag.Indicators)
+	}
+	ag.syncLegacyFields()
 	return ag
 }
 
@@ -130,27 +321,79 @@ func (ag *Agent) OpenPos(klns1 []klines.Kline, klns2 []klines.Kline, lastTrade *
 		return false, ErrKlinesAreBelowMinActivationKlineLength
 	}
 
-	// check rsi indicators first as its faster than bb
-	for _, rsi := range ag.Rsis {
-		active, err := rsi.Active(klns1[klns1Len-rsi.Period:], klns2[klns2Len-rsi.Period:])
-		if err != nil {
-			return false, err
-		}
-		if !active {
-			return false, nil
+	return ag.entryActive(newPairFrames(klns1, klns2))
+}
+
+// lookback is the number of bars the signal of the agent depends on
+func (ag *Agent) lookback() int {
+	res := minActivationKlineLength
+	for _, ind := range ag.Indicators {
+		res = max(res, baseLookback(ind))
+	}
+	return res
+}
+
+// OpenPosCols is OpenPos on the rows of pc before end
+func (ag *Agent) OpenPosCols(pc *PairColumns, end int, lastTrade *Trade) (bool, error) {
+	if !ag.Backoff.TradeAllowed(lastTrade) {
+		return false, nil
+	}
+	if end < 0 || end > pc.Len() {
+		return false, ErrColumnsEndIsOutOfRange
+	}
+	if end < minActivationKlineLength {
+		return false, ErrKlinesAreBelowMinActivationKlineLength
+	}
+
+	return ag.entryActive(&colFrames{pc: pc, end: end})
+}
+
+// pairView evaluates indicators on a kline pair at one point in time
+type pairView interface {
+	active(ind Indicator) (bool, error)
+	score(ind Indicator) (float64, error)
+}
+
+func (ag *Agent) entryActive(frames pairView) (bool, error) {
+	if ag.Rule == nil {
+		// indicators are sorted by cost, cheapest first
+		for _, ind := range ag.Indicators {
+			active, err := frames.active(ind)
+			if err != nil {
+				return false, err
+			}
+			if !active {
+				return false, nil
+			}
 		}
+		return true, nil
 	}
-	// check bb indicators
-	for _, bb := range ag.Bbs {
-		active, err := bb.Active(klns1[klns1Len-bb.Period:], klns2[klns2Len-bb.Period:])
+
+	// an indicator can be referred by several leaves
+	const (
+		unknown int8 = iota
+		inactive
+		active
+	)
+	results := make([]int8, len(ag.Indicators))
+
+	return ag.Rule.eval(func(i int) (bool, error) {
+		if i < 0 || i >= len(ag.Indicators) {
+			return false, ErrRuleIndexIsOutOfRange
+		}
+		if results[i] != unknown {
+			return results[i] == active, nil
+		}
+		act, err := frames.active(ag.Indicators[i])
 		if err != nil {
 			return false, err
 		}
-		if !active {
-			return false, nil
+		results[i] = inactive
+		if act {
+			results[i] = active
 		}
-	}
-	return true, nil
+		return act, nil
+	})
 }
 
 func (ag *Agent) ClosePos(pos *Position, closeLong klines.Kline, closeShort klines.Kline) (bool, ClosingReason, error) {
@@ -1,7 +1,9 @@
 package agent2
 
 import (
+	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
@@ -20,20 +22,61 @@ func TestAgentMarshal_NoIndicators(t *testing.T) {
 		t.Errorf("expected no error but raised %v", err)
 	}
 
-	expected := `{"tpsl":{"tp":0.029,"sl":0.029},"backoff":{"mls":960000},"expiry_mls":18720000,"bbs":null,"rsis":null}`
+	expected := `{"tpsl":{"tp":0.029,"sl":0.029},"backoff":{"mls":960000},"expiry_mls":18720000,"indicators":null}`
 
 	if string(pload) != expected {
 		t.Errorf("expected payload %s, received %s", expected, string(pload))
 	}
 }
 
+func TestAgent_DeprecatedFields(t *testing.T) {
+	rand.Seed(1)
+	ag := RandomAgent()
+	bbs, rsis := kindCount(ag, bbKind), kindCount(ag, rsiKind)
+	if len(ag.Bbs) != bbs || len(ag.Rsis) != rsis {
+		t.Fatalf("expected %d bbs and %d rsis, returned %d and %d", bbs, rsis, len(ag.Bbs), len(ag.Rsis))
+	}
+
+	// the fields share the indicators, so params set through them apply
+	if indexOf(ag.Indicators, ag.Bbs[0]) < 0 || indexOf(ag.Indicators, ag.Rsis[0]) < 0 {
+		t.Errorf("expected the indicators of the agent in the deprecated fields")
+	}
+
+	pload, _ := ag.Marshal()
+	if strings.Contains(string(pload), `"bbs"`) || strings.Contains(string(pload), `"Bbs"`) {
+		t.Errorf("expected the deprecated fields left out of json, returned %s", pload)
+	}
+	for _, c := range []*Agent{ag.Clone(), mustUnmarshalAgent(t, pload)} {
+		if len(c.Bbs) != bbs || len(c.Rsis) != rsis || c.Bbs[0] == ag.Bbs[0] {
+			t.Errorf("expected own deprecated fields filled, returned %d bbs and %d rsis", len(c.Bbs), len(c.Rsis))
+		}
+	}
+}
+
+func indexOf(inds Indicators, ind Indicator) int {
+	for i, x := range inds {
+		if x == ind {
+			return i
+		}
+	}
+	return -1
+}
+
+func mustUnmarshalAgent(t *testing.T, pload []byte) *Agent {
+	ag, err := UnmarshalAgent(pload)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	return ag
+}
+
 func TestAgentMarshal_WithIndicators(t *testing.T) {
 	rand.Seed(1)
 
 	ag := RandomAgent()
 	pload, _ := ag.Marshal()
 
-	expected := `{"tpsl":{"tp":0.029,"sl":0.022},"backoff":{"mls":390000},"expiry_mls":16560000,"bbs":[{"mon":14,"val_pos":0,"line":2,"period":98,"multiplier":2.61},{"mon":6,"val_pos":1,"line":2,"period":105,"multiplier":2.1239},{"mon":11,"val_pos":1,"line":2,"period":146,"multiplier":1.8541}],"rsis":[{"mon":8,"val_pos":0,"target_val":69,"period":13},{"mon":2,"val_pos":1,"target_val":66,"period":55},{"mon":14,"val_pos":0,"target_val":69,"period":89},{"mon":9,"val_pos":0,"target_val":53,"period":113}]}`
+	expected := `{"tpsl":{"tp":0.029,"sl":0.022},"backoff":{"mls":390000},"expiry_mls":16560000,"indicators":[{"kind":"vwap","params":{"leg":0,"val_pos":1,"target_dev":0.0195,"period":51}},{"kind":"vwap","params":{"leg":1,"val_pos":0,"target_dev":0.002,"period":73}},{"kind":"vwap","params":{"leg":2,"val_pos":1,"target_dev":0.0075,"period":227}},{"kind":"rsi","params":{"mon":19,"val_pos":1,"target_val":93,"period":14,"smoothing":1,"length":2}},{"kind":"rsi","params":{"mon":2,"val_pos":1,"target_val":41,"period":23,"smoothing":1,"length":6}},{"kind":"rsi","params":{"mon":18,"val_pos":1,"target_val":76,"period":40,"smoothing":0}},{"kind":"bb","params":{"mon":20,"val_pos":1,"line":1,"period":84,"multiplier":1.7737}},{"kind":"bb","params":{"mon":8,"val_pos":1,"line":1,"period":98,"multiplier":4.394}},{"kind":"bb","params":{"mon":25,"val_pos":0,"line":1,"period":190,"multiplier":2.8185}},{"kind":"bb","params":{"mon":2,"val_pos":0,"line":2,"period":196,"multiplier":3.068}},{"kind":"bb","params":{"mon":12,"val_pos":0,"line":2,"period":201,"multiplier":4.8886}}]}`
 
 	if string(pload) != expected {
 		t.Errorf("expected payload %s, received %s", expected, string(pload))
@@ -49,6 +92,49 @@ func TestUnmarshalAgent_WithDummyPayload(t *testing.T) {
 	}
 }
 
+func TestUnmarshalAgent_LegacyPayload(t *testing.T) {
+	pload := []byte(`{"tpsl":{"tp":0.029,"sl":0.022},"backoff":{"mls":390000},"expiry_mls":16560000,"bbs":[{"mon":14,"val_pos":0,"line":2,"period":98,"multiplier":2.61},{"mon":6,"val_pos":1,"line":2,"period":105,"multiplier":2.1239}],"rsis":[{"mon":8,"val_pos":0,"target_val":69,"period":13},{"mon":2,"val_pos":1,"target_val":66,"period":55}]}`)
+
+	ag, err := UnmarshalAgent(pload)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if kindCount(ag, bbKind) != 2 || kindCount(ag, rsiKind) != 2 {
+		t.Errorf("legacy bbs, rsis are not loaded as indicators")
+	}
+	// rsis are cheaper so they come first
+	if rsi, ok := ag.Indicators[0].(*RSI); !ok || rsi.Period != 13 {
+		t.Errorf("legacy indicators are not sorted by cost")
+	}
+	if ag.Tpsl.TakeProfit != 0.029 || ag.ExpiryMillis != 16560000 {
+		t.Errorf("legacy agent params are not loaded")
+	}
+}
+
+func TestUnmarshalAgent_LegacyPayloadNoIndicators(t *testing.T) {
+	pload := []byte(`{"tpsl":{"tp":0.029,"sl":0.029},"backoff":{"mls":960000},"expiry_mls":18720000,"bbs":null,"rsis":null}`)
+
+	ag, err := UnmarshalAgent(pload)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if ag.Indicators != nil {
+		t.Errorf("expected nil indicators, received %v", ag.Indicators)
+	}
+}
+
+func TestUnmarshalAgent_RSIWithoutSmoothing(t *testing.T) {
+	pload := []byte(`{"rsis":[{"mon":8,"val_pos":0,"target_val":69,"period":13}]}`)
+
+	ag, err := UnmarshalAgent(pload)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if rsi := ag.Indicators[0].(*RSI); rsi.Smoothing != Simple {
+		t.Errorf("expected smoothing to default to simple, received %d", rsi.Smoothing)
+	}
+}
+
 func TestUnmarshalAgent_Tpsl(t *testing.T) {
 	ag := RandomAgent()
 	pload, _ := ag.Marshal()
@@ -87,11 +173,13 @@ func TestUnmarshalAgent_IndicatorLens(t *testing.T) {
 	pload, _ := ag.Marshal()
 
 	ag2, _ := UnmarshalAgent(pload)
-	if len(ag.Bbs) != len(ag2.Bbs) {
-		t.Errorf("bb lens does not match")
+	if len(ag.Indicators) != len(ag2.Indicators) {
+		t.Errorf("indicator lens does not match")
 	}
-	if len(ag.Rsis) != len(ag2.Rsis) {
-		t.Errorf("rsis lens does not match")
+	for _, kind := range []string{bbKind, rsiKind, mfiKind, obvKind, vwapKind} {
+		if kindCount(ag, kind) != kindCount(ag2, kind) {
+			t.Errorf("%s lens does not match", kind)
+		}
 	}
 }
 
@@ -100,20 +188,21 @@ func TestUnmarshalAgent_FirstBB(t *testing.T) {
 	pload, _ := ag.Marshal()
 
 	ag2, _ := UnmarshalAgent(pload)
+	bb, bb2 := firstOfKind(ag, bbKind).(*BB), firstOfKind(ag2, bbKind).(*BB)
 
-	if ag2.Bbs[0].Mon != ag.Bbs[0].Mon {
+	if bb2.Mon != bb.Mon {
 		t.Errorf("first bb mons does not match")
 	}
-	if ag2.Bbs[0].ValuePos != ag.Bbs[0].ValuePos {
+	if bb2.ValuePos != bb.ValuePos {
 		t.Errorf("first bb value posses does not match")
 	}
-	if ag2.Bbs[0].Line != ag.Bbs[0].Line {
+	if bb2.Line != bb.Line {
 		t.Errorf("first bb lines does not match")
 	}
-	if ag2.Bbs[0].Period != ag.Bbs[0].Period {
+	if bb2.Period != bb.Period {
 		t.Errorf("first bb periods does not match")
 	}
-	if ag2.Bbs[0].Multiplier != ag.Bbs[0].Multiplier {
+	if bb2.Multiplier != bb.Multiplier {
 		t.Errorf("first bb multipliers does not match")
 	}
 }
@@ -123,24 +212,75 @@ func TestUnmarshalAgent_FirstRSI(t *testing.T) {
 	pload, _ := ag.Marshal()
 
 	ag2, _ := UnmarshalAgent(pload)
+	rsi, rsi2 := firstOfKind(ag, rsiKind).(*RSI), firstOfKind(ag2, rsiKind).(*RSI)
 
-	if ag2.Rsis[0].Mon != ag.Rsis[0].Mon {
+	if rsi2.Mon != rsi.Mon {
 		t.Errorf("first rsi mons does not match")
 	}
-	if ag2.Rsis[0].ValuePos != ag.Rsis[0].ValuePos {
+	if rsi2.ValuePos != rsi.ValuePos {
 		t.Errorf("first rsi value posses does not match")
 	}
-	if ag2.Rsis[0].TargetVal != ag.Rsis[0].TargetVal {
+	if rsi2.TargetVal != rsi.TargetVal {
 		t.Errorf("first rsi target vals does not match")
 	}
-	if ag2.Rsis[0].Period != ag.Rsis[0].Period {
+	if rsi2.Period != rsi.Period {
 		t.Errorf("first rsi periods does not match")
 	}
+	if rsi2.Smoothing != rsi.Smoothing {
+		t.Errorf("first rsi smoothings does not match")
+	}
+	if rsi2.Length != rsi.Length {
+		t.Errorf("first rsi lengths does not match")
+	}
+}
+
+func TestAgentClone(t *testing.T) {
+	ag := RandomAgent()
+	before, _ := ag.Marshal()
+
+	c := ag.Clone()
+	c.Tpsl.TakeProfit = 1
+	c.Backoff.DurationMillis = 1
+	for _, ind := range c.Indicators {
+		for i := 0; i < 10; i++ {
+			ind.Mutate()
+		}
+	}
+	c.Indicators = c.Indicators[:1]
+
+	after, _ := ag.Marshal()
+	if string(before) != string(after) {
+		t.Errorf("clone shares state with the original agent")
+	}
+}
+
+func TestAgentMutate_StaysValid(t *testing.T) {
+	rand.Seed(103)
+	for i := 0; i < 200; i++ {
+		ag := RandomAgent()
+		orig, _ := ag.Marshal()
+		c := ag.Clone()
+		c.Mutate()
+
+		if after, _ := ag.Marshal(); string(after) != string(orig) {
+			t.Fatalf("expected source agent untouched")
+		}
+		if c.Rule != nil {
+			if err := c.Rule.Validate(len(c.Indicators)); err != nil {
+				t.Fatalf("expected a valid rule but raised %v", err)
+			}
+		}
+		for _, p := range c.Params() {
+			if p.Value < p.Min || p.Value > p.Max {
+				t.Fatalf("param %s %f is out of [%f, %f]", p.Name, p.Value, p.Min, p.Max)
+			}
+		}
+	}
 }
 
 func TestRandomAgent_BBLen(t *testing.T) {
 	for i := 0; i < 10_000; i++ {
-		bbLen := len(RandomAgent().Bbs)
+		bbLen := kindCount(RandomAgent(), bbKind)
 
 		if bbLen < 1 || bbLen > maxBBCount {
 			t.Errorf("bbLen is outside of boundries")
@@ -150,7 +290,7 @@ func TestRandomAgent_BBLen(t *testing.T) {
 
 func TestRandomAgent_RSILen(t *testing.T) {
 	for i := 0; i < 10_000; i++ {
-		rsiLen := len(RandomAgent().Rsis)
+		rsiLen := kindCount(RandomAgent(), rsiKind)
 
 		if rsiLen < 1 || rsiLen > maxRSICount {
 			t.Errorf("rsiLen is outside of boundries")
@@ -158,30 +298,74 @@ func TestRandomAgent_RSILen(t *testing.T) {
 	}
 }
 
-func TestRandomAgent_BBSorted(t *testing.T) {
+func TestRandomAgent_FlowLens(t *testing.T) {
 	for i := 0; i < 10_000; i++ {
 		ag := RandomAgent()
 
-		lastPeriod := 0
-		for _, bb := range ag.Bbs {
-			if bb.Period < lastPeriod {
-				t.Errorf("bbs are not sorted according to period")
+		if n := kindCount(ag, mfiKind); n > maxMFICount {
+			t.Errorf("mfiLen is outside of boundries")
+		}
+		if n := kindCount(ag, obvKind); n > maxOBVCount {
+			t.Errorf("obvLen is outside of boundries")
+		}
+		if n := kindCount(ag, vwapKind); n > maxVWAPCount {
+			t.Errorf("vwapLen is outside of boundries")
+		}
+	}
+}
+
+func TestRandomAgent_KindProbs(t *testing.T) {
+	rand.Seed(163)
+	const n = 10_000
+	with := make(map[string]int)
+	for i := 0; i < n; i++ {
+		ag := RandomAgent()
+		for _, kind := range []string{bbKind, rsiKind, mfiKind, obvKind, vwapKind, "test_last_up"} {
+			if kindCount(ag, kind) > 0 {
+				with[kind]++
 			}
-			lastPeriod = bb.Period
 		}
 	}
+
+	if with[bbKind] != n || with[rsiKind] != n {
+		t.Errorf("expected bb and rsi in every agent, returned %d %d", with[bbKind], with[rsiKind])
+	}
+	for kind, prob := range map[string]int{mfiKind: mfiProb, obvKind: obvProb, vwapKind: vwapProb} {
+		if share := with[kind] * 100 / n; share < prob-3 || share > prob+3 {
+			t.Errorf("expected %s in about %d%% of agents, returned %d%%", kind, prob, share)
+		}
+	}
+	// a kind registered without Prob is left out
+	if with["test_last_up"] != 0 {
+		t.Errorf("expected no custom kind drawn, returned %d", with["test_last_up"])
+	}
 }
 
-func TestRandomAgent_RSISorted(t *testing.T) {
+func TestRandomAgent_IndicatorsSorted(t *testing.T) {
 	for i := 0; i < 10_000; i++ {
 		ag := RandomAgent()
 
-		lastPeriod := 0
-		for _, rsi := range ag.Rsis {
-			if rsi.Period < lastPeriod {
-				t.Errorf("rsis are not sorted according to period")
+		lastCost, lastPeriod := 0, 0
+		for _, ind := range ag.Indicators {
+			cost := indicatorCost(ind)
+			if cost < lastCost || (cost == lastCost && ind.Lookback() < lastPeriod) {
+				t.Errorf("indicators are not sorted according to cost, period")
+			}
+			lastCost, lastPeriod = cost, ind.Lookback()
+		}
+	}
+}
+
+func TestRandomAgent_UniqueMonitorPerKind(t *testing.T) {
+	for i := 0; i < 1_000; i++ {
+		seen := make(map[string]struct{})
+
+		for _, ind := range RandomAgent().Indicators {
+			key := fmt.Sprintf("%s-%d", ind.Kind(), ind.Monitor())
+			if _, ok := seen[key]; ok {
+				t.Errorf("monitor %d is drawn twice for %s", ind.Monitor(), ind.Kind())
 			}
-			lastPeriod = rsi.Period
+			seen[key] = struct{}{}
 		}
 	}
 }
@@ -218,15 +402,18 @@ func TestOpenPos_RaiseNoError_WithValidArgs_Trade(t *testing.T) {
 
 func TestOpenPos_SingleIndicator_Active(t *testing.T) {
 	ag := RandomAgent()
-	ag.Bbs = make([]*BB, 0)
 
 	rsi := RandomRSI()
 	rsi.TargetVal = 10.0
 	rsi.ValuePos = Above
 	rsi.Period = 250
-	ag.Rsis = []*RSI{rsi}
+	ag.Indicators = Indicators{rsi}
+	ag.Rule = nil
 
-	open, _ := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	open, err := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	if err != nil {
+		t.Fatalf("expected no error but received %v", err)
+	}
 	if !open {
 		t.Errorf("expected openpos to return true, returned false")
 	}
@@ -234,15 +421,18 @@ func TestOpenPos_SingleIndicator_Active(t *testing.T) {
 
 func TestOpenPos_SingleIndicator_NonActive(t *testing.T) {
 	ag := RandomAgent()
-	ag.Bbs = make([]*BB, 0)
 
 	rsi := RandomRSI()
 	rsi.TargetVal = 10.0
 	rsi.ValuePos = Below
 	rsi.Period = 250
-	ag.Rsis = []*RSI{rsi}
+	ag.Indicators = Indicators{rsi}
+	ag.Rule = nil
 
-	open, _ := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	open, err := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	if err != nil {
+		t.Fatalf("expected no error but received %v", err)
+	}
 	if open {
 		t.Errorf("expected openpos to return false, returned true")
 	}
@@ -252,18 +442,41 @@ func TestOpenPos_SingleIndicator_OneActiveOneInactive(t *testing.T) {
 	ag := RandomAgent()
 
 	bb := RandomBB()
+	bb.Mon = CloseR
 	bb.Period = 250
 	bb.Line = Upper
 	bb.ValuePos = Above
-	ag.Bbs = []*BB{bb}
 
 	rsi := RandomRSI()
 	rsi.TargetVal = 10.0
 	rsi.ValuePos = Above
 	rsi.Period = 250
-	ag.Rsis = []*RSI{rsi}
+	ag.Indicators = Indicators{rsi, bb}
+	ag.Rule = nil
+
+	open, err := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	if err != nil {
+		t.Fatalf("expected no error but received %v", err)
+	}
+	if open {
+		t.Errorf("expected openpos to return false, returned true")
+	}
+}
 
-	open, _ := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+func TestOpenPos_FlowIndicator_NonActive(t *testing.T) {
+	ag := RandomAgent()
+
+	mfi := RandomMFI()
+	mfi.Leg = Leg1
+	mfi.ValuePos = Below
+	mfi.Period = 250
+	ag.Indicators = Indicators{mfi}
+	ag.Rule = nil
+
+	open, err := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	if err != nil {
+		t.Fatalf("expected no error but received %v", err)
+	}
 	if open {
 		t.Errorf("expected openpos to return false, returned true")
 	}
@@ -346,3 +559,22 @@ func TestClosePos_Expiry(t *testing.T) {
 		t.Errorf("expected reason to be expiry")
 	}
 }
+
+func kindCount(ag *Agent, kind string) int {
+	count := 0
+	for _, ind := range ag.Indicators {
+		if ind.Kind() == kind {
+			count++
+		}
+	}
+	return count
+}
+
+func firstOfKind(ag *Agent, kind string) Indicator {
+	for _, ind := range ag.Indicators {
+		if ind.Kind() == kind {
+			return ind
+		}
+	}
+	return nil
+}
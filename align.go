@@ -0,0 +1,170 @@
+package agent2
+
+import (
+	"fmt"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// Gap is a run of Missing bars right after the kline opened at After
+type Gap struct {
+	After   int64 `json:"after"`
+	Missing int   `json:"missing"`
+}
+
+// Alignment reports what AlignPair changed on each leg, Dropped bars had
+// no counterpart on the other leg, Gaps are found before filling
+type Alignment struct {
+	Gaps1    []Gap `json:"gaps1"`
+	Gaps2    []Gap `json:"gaps2"`
+	Filled1  int   `json:"filled1"`
+	Filled2  int   `json:"filled2"`
+	Dropped1 int   `json:"dropped1"`
+	Dropped2 int   `json:"dropped2"`
+}
+
+var (
+	ErrIntervalShouldBePositive   = fmt.Errorf("kline interval should be positive")
+	ErrKlinesAreNotSorted         = fmt.Errorf("kline open times should be strictly increasing")
+	ErrKlinesAreNotOnIntervalGrid = fmt.Errorf("kline open times should be interval apart")
+)
+
+func checkSeries(klns []klines.Kline, interval int64) error {
+	if interval <= 0 {
+		return ErrIntervalShouldBePositive
+	}
+	for i := 1; i < len(klns); i++ {
+		diff := klns[i].OpenTime - klns[i-1].OpenTime
+		if diff <= 0 {
+			return ErrKlinesAreNotSorted
+		}
+		if diff%interval != 0 {
+			return ErrKlinesAreNotOnIntervalGrid
+		}
+	}
+	return nil
+}
+
+// FindGaps reports missing bars of a series with the given interval
+// in milliseconds
+func FindGaps(klns []klines.Kline, interval int64) ([]Gap, error) {
+	if err := checkSeries(klns, interval); err != nil {
+		return nil, err
+	}
+
+	var gaps []Gap
+	for i := 1; i < len(klns); i++ {
+		if missing := (klns[i].OpenTime-klns[i-1].OpenTime)/interval - 1; missing > 0 {
+			gaps = append(gaps, Gap{After: klns[i-1].OpenTime, Missing: int(missing)})
+		}
+	}
+	return gaps, nil
+}
+
+// filledKline is a flat bar at the previous close without volume
+func filledKline(prev klines.Kline, openTime int64, interval int64) klines.Kline {
+	return klines.Kline{
+		OpenTime:  openTime,
+		Open:      prev.Close,
+		High:      prev.Close,
+		Low:       prev.Close,
+		Close:     prev.Close,
+		CloseTime: openTime + interval - 1,
+		IsFinal:   true,
+	}
+}
+
+// ForwardFill inserts flat bars at the previous close into gaps
+func ForwardFill(klns []klines.Kline, interval int64) ([]klines.Kline, error) {
+	if err := checkSeries(klns, interval); err != nil {
+		return nil, err
+	}
+	if len(klns) == 0 {
+		return nil, nil
+	}
+
+	bars := (klns[len(klns)-1].OpenTime-klns[0].OpenTime)/interval + 1
+	res := make([]klines.Kline, 0, bars)
+	res = append(res, klns[0])
+	for _, kln := range klns[1:] {
+		prev := res[len(res)-1]
+		for t := prev.OpenTime + interval; t < kln.OpenTime; t += interval {
+			res = append(res, filledKline(prev, t, interval))
+		}
+		res = append(res, kln)
+	}
+	return res, nil
+}
+
+// AlignPair keeps bars whose OpenTime exists on both legs, so index i
+// of both results is the same bar. With fill gaps of each leg are
+// forward filled first, so only bars outside of the common range drop
+func AlignPair(klns1 []klines.Kline, klns2 []klines.Kline, interval int64, fill bool) ([]klines.Kline, []klines.Kline, *Alignment, error) {
+	gaps1, err := FindGaps(klns1, interval)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gaps2, err := FindGaps(klns2, interval)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	al := &Alignment{Gaps1: gaps1, Gaps2: gaps2}
+
+	if fill {
+		n1, n2 := len(klns1), len(klns2)
+		// errors are already checked by FindGaps
+		klns1, _ = ForwardFill(klns1, interval)
+		klns2, _ = ForwardFill(klns2, interval)
+		al.Filled1, al.Filled2 = len(klns1)-n1, len(klns2)-n2
+	}
+
+	res1 := make([]klines.Kline, 0, min(len(klns1), len(klns2)))
+	res2 := make([]klines.Kline, 0, cap(res1))
+	i, j := 0, 0
+	for i < len(klns1) && j < len(klns2) {
+		switch t1, t2 := klns1[i].OpenTime, klns2[j].OpenTime; {
+		case t1 < t2:
+			i++
+		case t1 > t2:
+			j++
+		default:
+			res1 = append(res1, klns1[i])
+			res2 = append(res2, klns2[j])
+			i++
+			j++
+		}
+	}
+	al.Dropped1 = len(klns1) - len(res1)
+	al.Dropped2 = len(klns2) - len(res2)
+	return res1, res2, al, nil
+}
+
+// Resample merges bars into tf times coarser bars aligned to the clock,
+// e.g. 1m into 15m bars opening at :00, :15, :30 and :45. The last bar
+// is not final when its period isn't complete yet
+func Resample(klns []klines.Kline, interval int64, tf int) ([]klines.Kline, error) {
+	if err := checkSeries(klns, interval); err != nil {
+		return nil, err
+	}
+	if tf < 1 || tf > maxTimeframeMult {
+		return nil, ErrTimeframeIsOutOfRange
+	}
+
+	period := interval * int64(tf)
+	var res []klines.Kline
+	for start := 0; start < len(klns); {
+		open := klns[start].OpenTime - klns[start].OpenTime%period
+		end := start + 1
+		for end < len(klns) && klns[end].OpenTime < open+period {
+			end++
+		}
+
+		bar := aggregateKlines(klns[start:end])
+		bar.OpenTime = open
+		bar.CloseTime = open + period - 1
+		bar.IsFinal = klns[end-1].IsFinal && klns[end-1].OpenTime+interval == open+period
+		res = append(res, bar)
+		start = end
+	}
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package agent2
+
+import (
+	"testing"
+
+	"github.com/varga-lp/data/klines"
+)
+
+const testInterval = 60_000
+
+// timedKlines are dummy klines opened at the given minutes
+func timedKlines(minutes ...int64) []klines.Kline {
+	klns := dummyKlines(len(minutes))
+	for i, m := range minutes {
+		klns[i].OpenTime = m * testInterval
+		klns[i].CloseTime = (m+1)*testInterval - 1
+		klns[i].IsFinal = true
+	}
+	return klns
+}
+
+func TestFindGaps(t *testing.T) {
+	gaps, err := FindGaps(timedKlines(0, 1, 4, 5, 7), testInterval)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := []Gap{{After: 1 * testInterval, Missing: 2}, {After: 5 * testInterval, Missing: 1}}
+	if len(gaps) != len(expected) {
+		t.Fatalf("expected %v, returned %v", expected, gaps)
+	}
+	for i := range expected {
+		if gaps[i] != expected[i] {
+			t.Errorf("expected %v, returned %v", expected[i], gaps[i])
+		}
+	}
+}
+
+func TestFindGaps_Errors(t *testing.T) {
+	if _, err := FindGaps(timedKlines(0, 2, 1), testInterval); err != ErrKlinesAreNotSorted {
+		t.Errorf("expected %v, returned %v", ErrKlinesAreNotSorted, err)
+	}
+	if _, err := FindGaps(timedKlines(0, 1), 7_000); err != ErrKlinesAreNotOnIntervalGrid {
+		t.Errorf("expected %v, returned %v", ErrKlinesAreNotOnIntervalGrid, err)
+	}
+	if _, err := FindGaps(nil, 0); err != ErrIntervalShouldBePositive {
+		t.Errorf("expected %v, returned %v", ErrIntervalShouldBePositive, err)
+	}
+}
+
+func TestForwardFill(t *testing.T) {
+	klns, err := ForwardFill(timedKlines(0, 1, 4), testInterval)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(klns) != 5 {
+		t.Fatalf("expected 5 klines, returned %d", len(klns))
+	}
+
+	for i, kln := range klns {
+		if kln.OpenTime != int64(i)*testInterval {
+			t.Errorf("expected open time %d, returned %d", i*testInterval, kln.OpenTime)
+		}
+	}
+	// filled with the close of the second kline
+	if f := klns[3]; f.Open != 2 || f.High != 2 || f.Low != 2 || f.Close != 2 || f.Volume != 0 {
+		t.Errorf("unexpected filled kline %+v", f)
+	}
+}
+
+func TestAlignPair_Intersects(t *testing.T) {
+	a1, a2, al, err := AlignPair(timedKlines(0, 1, 2, 4), timedKlines(1, 2, 3, 4, 5), testInterval, false)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(a1) != 3 || len(a2) != 3 {
+		t.Fatalf("expected 3 aligned klines, returned %d, %d", len(a1), len(a2))
+	}
+	for i := range a1 {
+		if a1[i].OpenTime != a2[i].OpenTime {
+			t.Errorf("open times at %d are not aligned", i)
+		}
+	}
+	if al.Dropped1 != 1 || al.Dropped2 != 2 || len(al.Gaps1) != 1 || len(al.Gaps2) != 0 {
+		t.Errorf("unexpected alignment %+v", al)
+	}
+}
+
+func TestAlignPair_Fill(t *testing.T) {
+	a1, a2, al, _ := AlignPair(timedKlines(0, 1, 4), timedKlines(1, 2, 3, 4, 5), testInterval, true)
+
+	if len(a1) != 4 || len(a2) != 4 {
+		t.Fatalf("expected 4 aligned klines, returned %d, %d", len(a1), len(a2))
+	}
+	if al.Filled1 != 2 || al.Filled2 != 0 || al.Dropped1 != 1 || al.Dropped2 != 1 {
+		t.Errorf("unexpected alignment %+v", al)
+	}
+}
+
+func TestResample_ClockAligned(t *testing.T) {
+	klns := timedKlines(3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16)
+
+	r, err := Resample(klns, testInterval, 5)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(r) != 4 {
+		t.Fatalf("expected 4 bars, returned %d", len(r))
+	}
+
+	if r[0].OpenTime != 0 || r[0].Open != 0 || r[0].Close != 2 || r[0].Volume != 1000 {
+		t.Errorf("unexpected first bar %+v", r[0])
+	}
+	if r[1].OpenTime != 5*testInterval || r[1].CloseTime != 10*testInterval-1 || r[1].Volume != 20_000 {
+		t.Errorf("unexpected second bar %+v", r[1])
+	}
+	if !r[2].IsFinal || r[3].IsFinal {
+		t.Errorf("expected only complete bars as final")
+	}
+}
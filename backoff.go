@@ -28,9 +28,14 @@ func RandomBackoff() *Backoff {
 }
 
 func (bo *Backoff) TradeAllowed(lastTrade *Trade) bool {
+	return bo.TradeAllowedAt(lastTrade, time.Now().UnixMilli())
+}
+
+// TradeAllowedAt is TradeAllowed at a given time, used by backtests
+func (bo *Backoff) TradeAllowedAt(lastTrade *Trade, now int64) bool {
 	if lastTrade == nil {
 		return true
 	}
 
-	return (time.Now().UnixMilli() - lastTrade.CloseTime) > bo.DurationMillis
+	return (now - lastTrade.CloseTime) > bo.DurationMillis
 }
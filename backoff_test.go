@@ -60,3 +60,15 @@ func TestBackoff_TradeAllowed_FarawayLastTrade(t *testing.T) {
 		t.Errorf("trade should be allowed when there is a far away trade")
 	}
 }
+
+func TestBackoff_TradeAllowedAt(t *testing.T) {
+	bo := &Backoff{DurationMillis: 1_000}
+	tr := &Trade{CloseTime: 10_000}
+
+	if bo.TradeAllowedAt(tr, 11_000) {
+		t.Errorf("trade should not be allowed at the end of backoff")
+	}
+	if !bo.TradeAllowedAt(tr, 11_001) {
+		t.Errorf("trade should be allowed after backoff")
+	}
+}
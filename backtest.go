@@ -0,0 +1,81 @@
+package agent2
+
+import "context"
+
+// ctxCheckBars is how often a backtest checks its context, in bars
+const ctxCheckBars = 1_024
+
+// Backtest replays the agent over pc holding one position at a time,
+// leg1 is the long and leg2 the short leg. Entry signals are taken at
+// the close of a bar and the position opens at that close, the
+// following bars are checked by ClosePos at their close. Backoff runs
+// from the close of the last trade, a position still open at the end
+// is left out of the bucket
+func (ag *Agent) Backtest(pc *PairColumns) (*Bucket, error) {
+	return ag.BacktestPruned(pc, nil)
+}
+
+// BacktestPruned is Backtest that stops once a prune rule holds, the
+// bucket is then marked pruned and ends at the close of that bar
+func (ag *Agent) BacktestPruned(pc *PairColumns, rules *PruneRules) (*Bucket, error) {
+	return ag.backtest(context.Background(), pc, 0, rules)
+}
+
+// backtest opens positions from bar start on, earlier bars only warm
+// up the indicators. A cancelled ctx stops it within ctxCheckBars bars
+func (ag *Agent) backtest(ctx context.Context, pc *PairColumns, start int, rules *PruneRules) (*Bucket, error) {
+	n := pc.Len()
+	if start < 0 || start >= n {
+		return nil, ErrBucketEndTimeIsNotGTStartTime
+	}
+	klns1, klns2 := pc.Klines(0, n)
+
+	bu, err := NewBucket(klns1[start].OpenTime, klns1[n-1].CloseTime)
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := ag.newSignalScanner(pc)
+	prune := newPruneState(rules)
+
+	var pos *Position
+	for i := start; i < n; i++ {
+		if (i-start)%ctxCheckBars == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		// rules are checked at the close of the previous bar
+		if i > start && prune.prune(bu, klns1[i-1].CloseTime) {
+			bu.EndTime, bu.Pruned = klns1[i-1].CloseTime, true
+			break
+		}
+
+		if pos != nil {
+			clos, reason, err := ag.ClosePos(pos, klns1[i], klns2[i])
+			if err != nil {
+				return nil, err
+			}
+			if clos {
+				if err := bu.AppendTrade(pos, reason, klns1[i], klns2[i]); err != nil {
+					return nil, err
+				}
+				pos = nil
+			}
+			continue
+		}
+
+		if !ag.Backoff.TradeAllowedAt(bu.LastTrade(), klns1[i].CloseTime) {
+			continue
+		}
+		if sig, err := sigs.at(i); err != nil {
+			return nil, err
+		} else if !sig {
+			continue
+		}
+		if pos, err = NewPosition(klns1[i], klns2[i]); err != nil {
+			return nil, err
+		}
+	}
+	return bu, nil
+}
@@ -0,0 +1,124 @@
+package agent2
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+// backtestBarByBar is Backtest calling OpenPos logic on every bar
+func backtestBarByBar(t *testing.T, ag *Agent, pc *PairColumns) *Bucket {
+	n := pc.Len()
+	klns1, klns2 := pc.Klines(0, n)
+	bu, _ := NewBucket(klns1[0].OpenTime, klns1[n-1].CloseTime)
+
+	var pos *Position
+	for i := 0; i < n; i++ {
+		if pos != nil {
+			if clos, reason, _ := ag.ClosePos(pos, klns1[i], klns2[i]); clos {
+				bu.AppendTrade(pos, reason, klns1[i], klns2[i])
+				pos = nil
+			}
+			continue
+		}
+		if i+1 < minActivationKlineLength || !ag.Backoff.TradeAllowedAt(bu.LastTrade(), klns1[i].CloseTime) {
+			continue
+		}
+		if act, _ := ag.entryActive(newPairFrames(klns1[:i+1], klns2[:i+1])); act {
+			pos, _ = NewPosition(klns1[i], klns2[i])
+		}
+	}
+	return bu
+}
+
+func TestBacktest_MatchesBarByBar(t *testing.T) {
+	rand.Seed(21)
+	pc, _ := NewPairColumns(randomWalkKlines(3_000, 1), randomWalkKlines(3_000, 2))
+
+	trades := 0
+	for i := 0; i < 20; i++ {
+		// random agents rarely enter, loosen them to get trades
+		ag := RandomAgent()
+		if i%2 == 0 {
+			ag.Indicators, ag.Rule = ag.Indicators[:min(2, len(ag.Indicators))], nil
+		} else {
+			ag.Rule = allRule(len(ag.Indicators))
+			ag.Rule.Op = OpOr
+		}
+
+		bu, err := ag.Backtest(pc)
+		if err != nil {
+			t.Fatalf("expected no error but raised %v", err)
+		}
+
+		expected := backtestBarByBar(t, ag, pc)
+		if len(bu.Trades) != len(expected.Trades) {
+			t.Fatalf("agent %d: expected %d trades, returned %d", i, len(expected.Trades), len(bu.Trades))
+		}
+		for j := range bu.Trades {
+			if *bu.Trades[j] != *expected.Trades[j] {
+				t.Errorf("agent %d: expected trade %v, returned %v", i, expected.Trades[j], bu.Trades[j])
+			}
+		}
+		trades += len(bu.Trades)
+	}
+	if trades == 0 {
+		t.Errorf("expected some trades over all agents")
+	}
+}
+
+func TestBacktest_RespectsBackoff(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(5_000, 3), randomWalkKlines(5_000, 4))
+	ag := RandomAgent()
+	ag.Indicators, ag.Rule = nil, nil
+	ag.Tpsl = &TPSL{TakeProfit: 0.002, StopLoss: 0.002}
+	ag.Backoff.DurationMillis = 10 * 60 * 1_000
+
+	bu, _ := ag.Backtest(pc)
+	if len(bu.Trades) < 2 {
+		t.Fatalf("expected trades of an always active agent")
+	}
+	for i := 1; i < len(bu.Trades); i++ {
+		// positions open at the close of a bar, trades keep its open time
+		openClose := bu.Trades[i].OpenTime + 60_000 - 1
+		if openClose-bu.Trades[i-1].CloseTime <= ag.Backoff.DurationMillis {
+			t.Errorf("trade %d opened within backoff of the previous", i)
+		}
+	}
+}
+
+func TestBacktest_Empty(t *testing.T) {
+	pc, _ := NewPairColumns(nil, nil)
+
+	if _, err := RandomAgent().Backtest(pc); err != ErrBucketEndTimeIsNotGTStartTime {
+		t.Errorf("expected %v, returned %v", ErrBucketEndTimeIsNotGTStartTime, err)
+	}
+}
+
+// countdownCtx is cancelled once Err was called checks times
+type countdownCtx struct {
+	context.Context
+	checks int
+}
+
+func (cc *countdownCtx) Err() error {
+	if cc.checks--; cc.checks < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestBacktest_CancelledMidway(t *testing.T) {
+	rand.Seed(41)
+	pc, _ := NewPairColumns(randomWalkKlines(5*ctxCheckBars, 1), randomWalkKlines(5*ctxCheckBars, 2))
+	ag := RandomAgent()
+
+	// checked at the first bar and every ctxCheckBars bars after
+	ctx := &countdownCtx{Context: context.Background(), checks: 3}
+	if _, err := ag.backtest(ctx, pc, 0, nil); err != context.Canceled {
+		t.Errorf("expected %v, returned %v", context.Canceled, err)
+	}
+	if ctx.checks != -1 {
+		t.Errorf("expected the backtest stopped at the 4th check, %d checks left", ctx.checks)
+	}
+}
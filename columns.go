@@ -0,0 +1,376 @@
+package agent2
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// LegColumns is one leg of a kline series as a struct of arrays
+type LegColumns struct {
+	OpenTime       []int64
+	CloseTime      []int64
+	Open           []float64
+	High           []float64
+	Low            []float64
+	Close          []float64
+	Volume         []float64
+	TakerBuyVolume []float64
+	NumberOfTrades []float64
+}
+
+// PairColumns is a columnar view of an aligned kline pair for large
+// backtests. Monitor columns are computed once for the whole series and
+// windows are slices of them, monitors depending on their window
+// (returns, spread) are fixed up or computed per window.
+// PairColumns is safe for concurrent use
+type PairColumns struct {
+	Leg1 LegColumns
+	Leg2 LegColumns
+
+	// source klines back windows of indicators without column support
+	klns1 []klines.Kline
+	klns2 []klines.Kline
+
+	mu   sync.RWMutex
+	mons map[Monitor][]float64
+}
+
+var (
+	ErrPairOpenTimesAreNotAligned = fmt.Errorf("pair open times are not aligned, see AlignPair")
+	ErrColumnsEndIsOutOfRange     = fmt.Errorf("columns end is out of range")
+)
+
+func newLegColumns(klns []klines.Kline) LegColumns {
+	n := len(klns)
+	lc := LegColumns{
+		OpenTime:       make([]int64, n),
+		CloseTime:      make([]int64, n),
+		Open:           make([]float64, n),
+		High:           make([]float64, n),
+		Low:            make([]float64, n),
+		Close:          make([]float64, n),
+		Volume:         make([]float64, n),
+		TakerBuyVolume: make([]float64, n),
+		NumberOfTrades: make([]float64, n),
+	}
+	for i := range klns {
+		lc.OpenTime[i] = klns[i].OpenTime
+		lc.CloseTime[i] = klns[i].CloseTime
+		lc.Open[i] = klns[i].Open
+		lc.High[i] = klns[i].High
+		lc.Low[i] = klns[i].Low
+		lc.Close[i] = klns[i].Close
+		lc.Volume[i] = klns[i].Volume
+		lc.TakerBuyVolume[i] = klns[i].TakerBuyVolume
+		lc.NumberOfTrades[i] = float64(klns[i].NumberOfTrades)
+	}
+	return lc
+}
+
+// NewPairColumns needs legs with equal open times at every index
+func NewPairColumns(klns1 []klines.Kline, klns2 []klines.Kline) (*PairColumns, error) {
+	if len(klns1) != len(klns2) {
+		return nil, ErrPairOpenTimesAreNotAligned
+	}
+	for i := range klns1 {
+		if klns1[i].OpenTime != klns2[i].OpenTime {
+			return nil, ErrPairOpenTimesAreNotAligned
+		}
+	}
+
+	return &PairColumns{
+		Leg1:  newLegColumns(klns1),
+		Leg2:  newLegColumns(klns2),
+		klns1: klns1,
+		klns2: klns2,
+		mons:  make(map[Monitor][]float64),
+	}, nil
+}
+
+func (pc *PairColumns) Len() int {
+	return len(pc.Leg1.Close)
+}
+
+// Slice returns rows [from, to) as pair columns of their own, sharing
+// the data but not the monitor cache as windows start over at from
+func (pc *PairColumns) Slice(from int, to int) *PairColumns {
+	return &PairColumns{
+		Leg1:  pc.Leg1.slice(from, to),
+		Leg2:  pc.Leg2.slice(from, to),
+		klns1: pc.klns1[from:to],
+		klns2: pc.klns2[from:to],
+		mons:  make(map[Monitor][]float64),
+	}
+}
+
+func (lc *LegColumns) slice(from int, to int) LegColumns {
+	return LegColumns{
+		OpenTime:       lc.OpenTime[from:to],
+		CloseTime:      lc.CloseTime[from:to],
+		Open:           lc.Open[from:to],
+		High:           lc.High[from:to],
+		Low:            lc.Low[from:to],
+		Close:          lc.Close[from:to],
+		Volume:         lc.Volume[from:to],
+		TakerBuyVolume: lc.TakerBuyVolume[from:to],
+		NumberOfTrades: lc.NumberOfTrades[from:to],
+	}
+}
+
+// Klines returns both legs of [from, to), shared with the source
+func (pc *PairColumns) Klines(from int, to int) ([]klines.Kline, []klines.Kline) {
+	return pc.klns1[from:to], pc.klns2[from:to]
+}
+
+// monColumn returns the monitor over the whole series, cached
+func (pc *PairColumns) monColumn(mon Monitor) ([]float64, error) {
+	pc.mu.RLock()
+	col, ok := pc.mons[mon]
+	pc.mu.RUnlock()
+	if ok {
+		return col, nil
+	}
+
+	col, err := calcMonColumn(mon, &pc.Leg1, &pc.Leg2)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.mu.Lock()
+	if pc.mons == nil {
+		pc.mons = make(map[Monitor][]float64)
+	}
+	pc.mons[mon] = col
+	pc.mu.Unlock()
+	return col, nil
+}
+
+// monWindow returns the monitor values of [from, to), the same values
+// klinesToMonValues returns for the klines of the window. The result
+// can be shared with the cache and must not be modified
+func (pc *PairColumns) monWindow(mon Monitor, from int, to int) ([]float64, error) {
+	if mon == Spread {
+		return spreadWindow(pc.Leg1.Close[from:to], pc.Leg2.Close[from:to]), nil
+	}
+
+	col, err := pc.monColumn(mon)
+	if err != nil {
+		return nil, err
+	}
+	vals := col[from:to]
+	if !mon.windowDependent() || len(vals) == 0 || from == 0 {
+		return vals, nil
+	}
+
+	res := append([]float64(nil), vals...)
+	res[0] = pc.windowFirst(mon, from)
+	return res, nil
+}
+
+// windowFirst is the value of a window dependent monitor at the first
+// row of a window starting at from, returns are taken from its open
+func (pc *PairColumns) windowFirst(mon Monitor, from int) float64 {
+	r1 := simpleReturn(pc.Leg1.Open[from], pc.Leg1.Close[from])
+	r2 := simpleReturn(pc.Leg2.Open[from], pc.Leg2.Close[from])
+	switch mon {
+	case Return1:
+		return r1
+	case Return2:
+		return r2
+	}
+	return r1 - r2
+}
+
+func returnColumn(lc *LegColumns) []float64 {
+	res := make([]float64, len(lc.Close))
+	for i := range res {
+		prev := lc.Open[i]
+		if i > 0 {
+			prev = lc.Close[i-1]
+		}
+		res[i] = simpleReturn(prev, lc.Close[i])
+	}
+	return res
+}
+
+func bodyColumn(lc *LegColumns) []float64 {
+	res := make([]float64, len(lc.Close))
+	for i := range res {
+		res[i] = math.Abs(lc.Close[i]-lc.Open[i]) / (lc.Open[i] + epsilon)
+	}
+	return res
+}
+
+func quoteVolColumn(lc *LegColumns) []float64 {
+	res := make([]float64, len(lc.Close))
+	for i := range res {
+		res[i] = lc.Volume[i] * ((lc.High[i] + lc.Low[i] + lc.Close[i]) / 3.0)
+	}
+	return res
+}
+
+func hmlColumn(lc *LegColumns) []float64 {
+	res := make([]float64, len(lc.Close))
+	for i := range res {
+		res[i] = lc.High[i] - lc.Low[i]
+	}
+	return res
+}
+
+func tbvColumn(lc *LegColumns) []float64 {
+	res := make([]float64, len(lc.Close))
+	for i := range res {
+		res[i] = lc.TakerBuyVolume[i] / (lc.Volume[i] + epsilon)
+	}
+	return res
+}
+
+func ratioColumn(a []float64, b []float64) []float64 {
+	res := make([]float64, len(a))
+	for i := range res {
+		res[i] = a[i] / (b[i] + epsilon)
+	}
+	return res
+}
+
+// zeroRatioColumn is a ratio that is 0 where the divisor is 0
+func zeroRatioColumn(a []float64, b []float64) []float64 {
+	res := make([]float64, len(a))
+	for i := range res {
+		if b[i] != 0 {
+			res[i] = a[i] / b[i]
+		}
+	}
+	return res
+}
+
+// calcMonColumn branches once per monitor instead of once per value
+func calcMonColumn(mon Monitor, l1 *LegColumns, l2 *LegColumns) ([]float64, error) {
+	switch mon {
+	case Close1:
+		return l1.Close, nil
+	case Close2:
+		return l2.Close, nil
+	case CloseR:
+		return ratioColumn(l1.Close, l2.Close), nil
+	case HighMLow1:
+		return hmlColumn(l1), nil
+	case HighMLow2:
+		return hmlColumn(l2), nil
+	case HighMLowR:
+		return zeroRatioColumn(hmlColumn(l1), hmlColumn(l2)), nil
+	case Volume1:
+		return l1.Volume, nil
+	case Volume2:
+		return l2.Volume, nil
+	case VolumeR:
+		return ratioColumn(l1.Volume, l2.Volume), nil
+	case TBVolOVol1:
+		return tbvColumn(l1), nil
+	case TBVolOVol2:
+		return tbvColumn(l2), nil
+	case TBVolOVolR:
+		return ratioColumn(tbvColumn(l1), tbvColumn(l2)), nil
+	case Not1:
+		return l1.NumberOfTrades, nil
+	case Not2:
+		return l2.NumberOfTrades, nil
+	case NotR:
+		return ratioColumn(l1.NumberOfTrades, l2.NumberOfTrades), nil
+	case Return1:
+		return returnColumn(l1), nil
+	case Return2:
+		return returnColumn(l2), nil
+	case ReturnR:
+		r1, r2 := returnColumn(l1), returnColumn(l2)
+		for i := range r1 {
+			r1[i] -= r2[i]
+		}
+		return r1, nil
+	case LogCloseR:
+		res := make([]float64, len(l1.Close))
+		for i := range res {
+			res[i] = math.Log((l1.Close[i] + epsilon) / (l2.Close[i] + epsilon))
+		}
+		return res, nil
+	case Body1:
+		return bodyColumn(l1), nil
+	case Body2:
+		return bodyColumn(l2), nil
+	case BodyR:
+		return zeroRatioColumn(bodyColumn(l1), bodyColumn(l2)), nil
+	case QuoteVol1:
+		return quoteVolColumn(l1), nil
+	case QuoteVol2:
+		return quoteVolColumn(l2), nil
+	case QuoteVolR:
+		return ratioColumn(quoteVolColumn(l1), quoteVolColumn(l2)), nil
+	}
+	return nil, fmt.Errorf("mon %d is not defined", mon)
+}
+
+// spreadWindow is close1 - beta * close2 with beta fitted on the window
+func spreadWindow(close1 []float64, close2 []float64) []float64 {
+	beta := hedgeRatioOf(close1, close2)
+
+	res := make([]float64, len(close1))
+	for i := range res {
+		res[i] = close1[i] - beta*close2[i]
+	}
+	return res
+}
+
+// columnEvaluator is implemented by indicators evaluating straight on
+// the last Lookback values of their monitor
+type columnEvaluator interface {
+	activeVals(vals []float64) (bool, error)
+	scoreVals(vals []float64) (float64, error)
+}
+
+// colFrames evaluates indicators on the rows before end, other
+// indicators fall back to the source klines
+type colFrames struct {
+	pc     *PairColumns
+	end    int
+	frames *pairFrames
+}
+
+func (cf *colFrames) vals(ind Indicator) ([]float64, error) {
+	lb := ind.Lookback()
+	if lb > cf.end {
+		return nil, ErrKlinesAreBelowIndicatorLookback
+	}
+	return cf.pc.monWindow(ind.Monitor(), cf.end-lb, cf.end)
+}
+
+func (cf *colFrames) klineFrames() *pairFrames {
+	if cf.frames == nil {
+		cf.frames = newPairFrames(cf.pc.Klines(0, cf.end))
+	}
+	return cf.frames
+}
+
+func (cf *colFrames) active(ind Indicator) (bool, error) {
+	if ce, ok := ind.(columnEvaluator); ok && indicatorTimeframe(ind) == 1 {
+		vals, err := cf.vals(ind)
+		if err != nil {
+			return false, err
+		}
+		return ce.activeVals(vals)
+	}
+	return cf.klineFrames().active(ind)
+}
+
+func (cf *colFrames) score(ind Indicator) (float64, error) {
+	if ce, ok := ind.(columnEvaluator); ok && indicatorTimeframe(ind) == 1 {
+		vals, err := cf.vals(ind)
+		if err != nil {
+			return 0, err
+		}
+		return ce.scoreVals(vals)
+	}
+	return cf.klineFrames().score(ind)
+}
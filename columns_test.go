@@ -0,0 +1,189 @@
+package agent2
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNewPairColumns_NotAligned(t *testing.T) {
+	klns1, klns2 := randomWalkKlines(10, 1), randomWalkKlines(10, 2)
+	klns2[5].OpenTime++
+
+	if _, err := NewPairColumns(klns1, klns2); err != ErrPairOpenTimesAreNotAligned {
+		t.Errorf("expected %v, returned %v", ErrPairOpenTimesAreNotAligned, err)
+	}
+	if _, err := NewPairColumns(klns1, klns2[:9]); err != ErrPairOpenTimesAreNotAligned {
+		t.Errorf("expected %v, returned %v", ErrPairOpenTimesAreNotAligned, err)
+	}
+}
+
+func TestPairColumns_Klines(t *testing.T) {
+	klns1, klns2 := randomWalkKlines(20, 1), randomWalkKlines(20, 2)
+	pc, _ := NewPairColumns(klns1, klns2)
+
+	k1, k2 := pc.Klines(5, 15)
+	for i := range k1 {
+		if k1[i] != klns1[5+i] || k2[i] != klns2[5+i] {
+			t.Errorf("expected klines at %d to round trip", 5+i)
+		}
+	}
+}
+
+func TestPairColumns_MonWindowMatchesKlines(t *testing.T) {
+	klns1, klns2 := randomWalkKlines(300, 1), randomWalkKlines(300, 2)
+	pc, _ := NewPairColumns(klns1, klns2)
+
+	for mon := Close1; mon <= QuoteVolR; mon++ {
+		for _, from := range []int{0, 1, 37} {
+			to := from + 250
+			expected, _ := klinesToMonValues(mon, 250, klns1[from:to], klns2[from:to])
+
+			vals, err := pc.monWindow(mon, from, to)
+			if err != nil {
+				t.Errorf("expected no error but raised %v", err)
+			}
+			for i := range expected {
+				if vals[i] != expected[i] {
+					t.Errorf("mon %d window %d: expected %f at %d, returned %f", mon, from, expected[i], i, vals[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestPairColumns_MonWindowDoesntModifyCache(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(20, 1), randomWalkKlines(20, 2))
+
+	col, _ := pc.monColumn(Return1)
+	before := col[5]
+	pc.monWindow(Return1, 5, 10)
+
+	if col[5] != before {
+		t.Errorf("expected cached column to be unchanged")
+	}
+}
+
+func TestPairColumns_UndefinedMonitor(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(20, 1), randomWalkKlines(20, 2))
+
+	if _, err := pc.monWindow(Monitor(99), 0, 10); err == nil {
+		t.Errorf("expected error but nothing raised")
+	}
+}
+
+func TestOpenPosCols_MatchesOpenPos(t *testing.T) {
+	rand.Seed(7)
+	klns1, klns2 := randomWalkKlines(600, 1), randomWalkKlines(600, 2)
+	pc, _ := NewPairColumns(klns1, klns2)
+
+	for i := 0; i < 200; i++ {
+		ag := RandomAgent()
+		if i%10 == 0 {
+			ag.Indicators = append(ag.Indicators, &BB{Mon: CloseR, ValuePos: Below, Line: Middle, Period: 30, Multiplier: 1, Timeframe: 5})
+			ag.Rule = nil
+		}
+
+		for _, end := range []int{250, 420, 600} {
+			expected, expErr := ag.OpenPos(klns1[:end], klns2[:end], nil)
+			open, err := ag.OpenPosCols(pc, end, nil)
+
+			if open != expected || (err == nil) != (expErr == nil) {
+				t.Errorf("agent %d at %d: expected %v, %v returned %v, %v", i, end, expected, expErr, open, err)
+			}
+		}
+	}
+}
+
+func TestColFrames_ScoresMatchKlines(t *testing.T) {
+	klns1, klns2 := randomWalkKlines(400, 1), randomWalkKlines(400, 2)
+	pc, _ := NewPairColumns(klns1, klns2)
+	ag := benchmarkColumnAgent()
+	ag.Indicators = append(ag.Indicators, RandomOBV())
+
+	expected, _ := ag.scores(newPairFrames(klns1[:333], klns2[:333]))
+	scores, err := ag.scores(&colFrames{pc: pc, end: 333})
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	for i := range expected {
+		if scores[i] != expected[i] {
+			t.Errorf("expected score %f at %d, returned %f", expected[i], i, scores[i])
+		}
+	}
+}
+
+func TestOpenPosCols_Errors(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(300, 1), randomWalkKlines(300, 2))
+	ag := RandomAgent()
+
+	if _, err := ag.OpenPosCols(pc, 301, nil); err != ErrColumnsEndIsOutOfRange {
+		t.Errorf("expected %v, returned %v", ErrColumnsEndIsOutOfRange, err)
+	}
+	if _, err := ag.OpenPosCols(pc, 249, nil); err != ErrKlinesAreBelowMinActivationKlineLength {
+		t.Errorf("expected %v, returned %v", ErrKlinesAreBelowMinActivationKlineLength, err)
+	}
+}
+
+// scores evaluate every indicator, unlike entryActive short circuiting
+func benchmarkColumnAgent() *Agent {
+	ag := RandomAgent()
+	ag.Rule = nil
+	ag.Indicators = Indicators{
+		&BB{Mon: CloseR, Line: Upper, Period: 200, Multiplier: 2},
+		&BB{Mon: Spread, Line: Lower, Period: 100, Multiplier: 1},
+		&BB{Mon: ReturnR, Line: Middle, Period: 150, Multiplier: 1},
+		&RSI{Mon: LogCloseR, TargetVal: 50, Period: 240, Smoothing: Wilder, Length: 14},
+		&RSI{Mon: TBVolOVolR, TargetVal: 50, Period: 50},
+	}
+	return ag
+}
+
+func Benchmark_Scores(b *testing.B) {
+	klns1, klns2 := randomWalkKlines(5_000, 1), randomWalkKlines(5_000, 2)
+	ag := benchmarkColumnAgent()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		end := 250 + i%4_750
+		ag.scores(newPairFrames(klns1[:end], klns2[:end]))
+	}
+}
+
+func Benchmark_ScoresCols(b *testing.B) {
+	pc, _ := NewPairColumns(randomWalkKlines(5_000, 1), randomWalkKlines(5_000, 2))
+	ag := benchmarkColumnAgent()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ag.scores(&colFrames{pc: pc, end: 250 + i%4_750})
+	}
+}
+
+func TestPairColumns_Slice(t *testing.T) {
+	rand.Seed(37)
+	klns1, klns2 := randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2)
+	pc, _ := NewPairColumns(klns1, klns2)
+	// fill the cache of the source, the slice must not reuse it
+	benchmarkColumnAgent().scores(&colFrames{pc: pc, end: pc.Len()})
+
+	sl := pc.Slice(300, 800)
+	fresh, _ := NewPairColumns(klns1[300:800], klns2[300:800])
+	if sl.Len() != 500 || sl.Leg1.OpenTime[0] != klns1[300].OpenTime {
+		t.Fatalf("unexpected slice of %d rows from %d", sl.Len(), sl.Leg1.OpenTime[0])
+	}
+
+	ag := benchmarkColumnAgent()
+	for end := 250; end <= 500; end += 50 {
+		got, err := ag.scores(&colFrames{pc: sl, end: end})
+		if err != nil {
+			t.Fatalf("expected no error but raised %v", err)
+		}
+		want, _ := ag.scores(&colFrames{pc: fresh, end: end})
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("expected score %f at %d, returned %f", want[i], end, got[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// PurgedKFold scores agents on k contiguous test folds of the history.
+// Train buckets come from one backtest over the whole history, trades
+// overlapping the test fold are purged and trades opening within the
+// embargo after it are dropped, as their indicators look back into the
+// fold. Test folds are backtested on their own, warming up on the bars
+// before them. Backtests ignore the Evaluator prune rules, a pruned
+// bucket would end early and shift the purge windows
+type PurgedKFold struct {
+	Folds int
+	// EmbargoBars defaults to the lookback of each agent
+	EmbargoBars int
+	// Fitness defaults to ProfitPerDayFitness
+	Fitness   Fitness
+	Evaluator *Evaluator
+}
+
+// CVFold holds a train and a test bucket per agent for the test bars
+// [TestStart, TestEnd). The train bucket is nil when the fold and its
+// embargo purge the whole history
+type CVFold struct {
+	TestStart int       `json:"test_start"`
+	TestEnd   int       `json:"test_end"`
+	Train     []*Bucket `json:"train"`
+	Test      []*Bucket `json:"test"`
+}
+
+// CVScore aggregates the fitness of an agent over folds, a large gap
+// between MeanTrain and MeanTest points to overfitting. MeanTrain is
+// taken over the folds with a train bucket, 0 without any
+type CVScore struct {
+	MeanTrain  float64 `json:"mean_train"`
+	MeanTest   float64 `json:"mean_test"`
+	StdTest    float64 `json:"std_test"`
+	TestTrades int     `json:"test_trades"`
+}
+
+// CVResult has a score per agent in agent order
+type CVResult struct {
+	Folds  []*CVFold  `json:"folds"`
+	Scores []*CVScore `json:"scores"`
+}
+
+var (
+	ErrFoldsAreBelowTwo    = fmt.Errorf("purged k fold needs at least 2 folds")
+	ErrHistoryIsBelowFolds = fmt.Errorf("history is shorter than the number of folds")
+)
+
+func (kf *PurgedKFold) Run(ctx context.Context, agents []*Agent, pc *PairColumns) (*CVResult, error) {
+	if kf.Folds < 2 {
+		return nil, ErrFoldsAreBelowTwo
+	}
+	n := pc.Len()
+	if n < kf.Folds {
+		return nil, ErrHistoryIsBelowFolds
+	}
+	ev := &Evaluator{}
+	if kf.Evaluator != nil {
+		ev = kf.Evaluator.unpruned()
+	}
+
+	full, err := ev.Evaluate(ctx, agents, pc)
+	if err != nil {
+		return nil, err
+	}
+	klns1, _ := pc.Klines(0, n)
+	warmup := warmupBars(agents)
+
+	res := &CVResult{}
+	for f := 0; f < kf.Folds; f++ {
+		fold := &CVFold{TestStart: f * n / kf.Folds, TestEnd: (f + 1) * n / kf.Folds}
+
+		from := max(0, fold.TestStart-warmup)
+		fold.Test, err = ev.evaluate(ctx, agents, pc.Slice(from, fold.TestEnd), fold.TestStart-from)
+		if err != nil {
+			return nil, err
+		}
+
+		fold.Train = make([]*Bucket, len(agents))
+		for i, ag := range agents {
+			embargo := kf.EmbargoBars
+			if embargo <= 0 {
+				embargo = ag.lookback()
+			}
+			train := purgeBucket(full[i], klns1, fold.TestStart, min(n, fold.TestEnd+embargo))
+			if train.PurgedMillis < train.EndTime-train.StartTime {
+				fold.Train[i] = train
+			}
+		}
+		res.Folds = append(res.Folds, fold)
+	}
+
+	fitness := kf.Fitness
+	if fitness == nil {
+		fitness = ProfitPerDayFitness
+	}
+	for i := range agents {
+		res.Scores = append(res.Scores, res.score(i, fitness))
+	}
+	return res, nil
+}
+
+func (res *CVResult) score(agent int, fitness Fitness) *CVScore {
+	sc := &CVScore{}
+	trains, tests := make([]float64, 0, len(res.Folds)), make([]float64, len(res.Folds))
+	for f, fold := range res.Folds {
+		if fold.Train[agent] != nil {
+			trains = append(trains, fitness(fold.Train[agent]))
+		}
+		tests[f] = fitness(fold.Test[agent])
+		sc.TestTrades += len(fold.Test[agent].Trades)
+	}
+
+	// folds are at least 2, mean and stddev of tests can't fail, trains
+	// can be empty and then have a mean of 0
+	sc.MeanTrain, _ = mean(trains)
+	sc.MeanTest, _ = mean(tests)
+	sc.StdTest, _ = stddev(tests, sc.MeanTest)
+	return sc
+}
+
+// purgeBucket copies bu without the trades overlapping bars [from, to)
+// of klns, the span of those bars is left out of the bucket days
+func purgeBucket(bu *Bucket, klns []klines.Kline, from int, to int) *Bucket {
+	start, end := klns[from].OpenTime, klns[to-1].CloseTime
+
+	res := &Bucket{
+		StartTime:    bu.StartTime,
+		EndTime:      bu.EndTime,
+		Trades:       make([]*Trade, 0, len(bu.Trades)),
+		Pruned:       bu.Pruned,
+		PurgedMillis: bu.PurgedMillis + max(0, min(end, bu.EndTime)-max(start, bu.StartTime)),
+	}
+	for _, tr := range bu.Trades {
+		if tr.CloseTime < start || tr.OpenTime > end {
+			res.Trades = append(res.Trades, tr)
+		}
+	}
+	return res
+}
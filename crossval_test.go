@@ -0,0 +1,130 @@
+package agent2
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+func TestPurgedKFold_Run(t *testing.T) {
+	rand.Seed(53)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	agents := evaluatorAgents(8)
+
+	kf := &PurgedKFold{Folds: 4, Evaluator: &Evaluator{Workers: 2}}
+	res, err := kf.Run(context.Background(), agents, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Folds) != 4 || len(res.Scores) != len(agents) {
+		t.Fatalf("expected 4 folds and %d scores, returned %d and %d", len(agents), len(res.Folds), len(res.Scores))
+	}
+
+	trades := 0
+	for f, fold := range res.Folds {
+		if fold.TestStart != f*500 || fold.TestEnd != (f+1)*500 {
+			t.Errorf("unexpected fold %d bars [%d, %d)", f, fold.TestStart, fold.TestEnd)
+		}
+		start := pc.Leg1.OpenTime[fold.TestStart]
+		for i, ag := range agents {
+			test := fold.Test[i]
+			if test.StartTime != start || test.EndTime != pc.Leg1.CloseTime[fold.TestEnd-1] {
+				t.Errorf("unexpected test bucket [%d, %d]", test.StartTime, test.EndTime)
+			}
+			trades += len(test.Trades)
+
+			end := pc.Leg1.CloseTime[min(pc.Len(), fold.TestEnd+ag.lookback())-1]
+			train := fold.Train[i]
+			if train.PurgedMillis != end-start {
+				t.Errorf("expected %d purged millis, returned %d", end-start, train.PurgedMillis)
+			}
+			for _, tr := range train.Trades {
+				if tr.CloseTime >= start && tr.OpenTime <= end {
+					t.Errorf("train trade %v overlaps purged [%d, %d]", tr, start, end)
+				}
+			}
+		}
+	}
+	if trades == 0 {
+		t.Errorf("expected some test trades over all agents")
+	}
+}
+
+func TestPurgedKFold_NotPruned(t *testing.T) {
+	rand.Seed(53)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	agents := evaluatorAgents(4)
+
+	plain, _ := (&PurgedKFold{Folds: 4}).Run(context.Background(), agents, pc)
+	prune := &PruneRules{MinProfitPerDay: 1e9, MinProfitPerDayAfterMillis: 1}
+	res, err := (&PurgedKFold{Folds: 4, Evaluator: &Evaluator{Prune: prune}}).Run(context.Background(), agents, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for f, fold := range res.Folds {
+		for i := range agents {
+			test, train := fold.Test[i], fold.Train[i]
+			if test.Pruned || train.Pruned {
+				t.Fatalf("expected fold %d buckets not pruned", f)
+			}
+			if !tradesEqual(test.Trades, plain.Folds[f].Test[i].Trades) || train.EndTime != plain.Folds[f].Train[i].EndTime {
+				t.Errorf("expected fold %d buckets of an unpruned run", f)
+			}
+		}
+	}
+}
+
+func TestPurgedKFold_FullyPurgedTrain(t *testing.T) {
+	rand.Seed(53)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	agents := evaluatorAgents(2)
+
+	// the embargo of the first fold reaches the end of the history
+	res, err := (&PurgedKFold{Folds: 2, EmbargoBars: 1_200}).Run(context.Background(), agents, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for i := range agents {
+		if res.Folds[0].Train[i] != nil || res.Folds[1].Train[i] == nil {
+			t.Fatalf("expected only the first fold train bucket left out")
+		}
+		if exp := res.Folds[1].Train[i].ProfitPerDay(); res.Scores[i].MeanTrain != exp {
+			t.Errorf("expected mean train of the second fold %f, returned %f", exp, res.Scores[i].MeanTrain)
+		}
+	}
+	if _, err := json.Marshal(res); err != nil {
+		t.Errorf("expected a json result but raised %v", err)
+	}
+}
+
+func TestPurgedKFold_Errors(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(3, 1), randomWalkKlines(3, 2))
+
+	if _, err := (&PurgedKFold{Folds: 1}).Run(context.Background(), nil, pc); err != ErrFoldsAreBelowTwo {
+		t.Errorf("expected %v, returned %v", ErrFoldsAreBelowTwo, err)
+	}
+	if _, err := (&PurgedKFold{Folds: 4}).Run(context.Background(), nil, pc); err != ErrHistoryIsBelowFolds {
+		t.Errorf("expected %v, returned %v", ErrHistoryIsBelowFolds, err)
+	}
+}
+
+func TestPurgeBucket(t *testing.T) {
+	klns := timedKlines(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	bu, _ := NewBucket(klns[0].OpenTime, klns[9].CloseTime)
+	for _, bars := range [][2]int{{0, 1}, {1, 3}, {4, 5}, {6, 8}, {8, 9}} {
+		bu.Trades = append(bu.Trades, &Trade{OpenTime: klns[bars[0]].OpenTime, CloseTime: klns[bars[1]].CloseTime})
+	}
+
+	// bars 3 to 7 are purged
+	res := purgeBucket(bu, klns, 3, 8)
+	if len(res.Trades) != 2 || res.Trades[0] != bu.Trades[0] || res.Trades[1] != bu.Trades[4] {
+		t.Errorf("expected first and last trades kept, returned %v", res.Trades)
+	}
+	if expected := klns[7].CloseTime - klns[3].OpenTime; res.PurgedMillis != expected {
+		t.Errorf("expected %d purged millis, returned %d", expected, res.PurgedMillis)
+	}
+	if len(bu.Trades) != 5 {
+		t.Errorf("expected source bucket untouched")
+	}
+}
@@ -0,0 +1,105 @@
+package agent2
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Evaluator backtests a population concurrently on shared pair
+// columns. Agents are only read, so the same agent can be evaluated
+// by several evaluators at once
+type Evaluator struct {
+	// Workers defaults to GOMAXPROCS
+	Workers int
+	// Progress is called after every evaluated agent with the number of
+	// agents done so far, calls are not concurrent
+	Progress func(done int, total int)
+	// Prune stops backtests of hopeless agents early, optional
+	Prune *PruneRules
+}
+
+type evalResult struct {
+	index  int
+	bucket *Bucket
+	err    error
+}
+
+// Evaluate returns the bucket of every agent in agent order. The first
+// failing agent or a cancelled context stops the evaluation, running
+// backtests included
+func (ev *Evaluator) Evaluate(ctx context.Context, agents []*Agent, pc *PairColumns) ([]*Bucket, error) {
+	return ev.evaluate(ctx, agents, pc, 0)
+}
+
+// unpruned is a copy of the evaluator without prune rules, out of
+// sample backtests have to run to their end
+func (ev *Evaluator) unpruned() *Evaluator {
+	return &Evaluator{Workers: ev.Workers, Progress: ev.Progress}
+}
+
+// evaluate backtests from bar start on, see Agent.backtest
+func (ev *Evaluator) evaluate(ctx context.Context, agents []*Agent, pc *PairColumns, start int) ([]*Bucket, error) {
+	workers := ev.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(agents))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	results := make(chan evalResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				bu, err := agents[i].backtest(ctx, pc, start, ev.Prune)
+				select {
+				case results <- evalResult{index: i, bucket: bu, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range agents {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	buckets := make([]*Bucket, len(agents))
+	done := 0
+	for res := range results {
+		if res.err != nil {
+			cancel()
+			return nil, res.err
+		}
+		buckets[res.index] = res.bucket
+		done++
+		if ev.Progress != nil {
+			ev.Progress(done, len(agents))
+		}
+	}
+
+	if done < len(agents) {
+		return nil, ctx.Err()
+	}
+	return buckets, nil
+}
@@ -0,0 +1,143 @@
+package agent2
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func evaluatorAgents(n int) []*Agent {
+	agents := make([]*Agent, n)
+	for i := range agents {
+		agents[i] = RandomAgent()
+		agents[i].Indicators, agents[i].Rule = agents[i].Indicators[:min(2, len(agents[i].Indicators))], nil
+	}
+	return agents
+}
+
+func TestEvaluator_MatchesSequentialOrder(t *testing.T) {
+	rand.Seed(31)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	agents := evaluatorAgents(40)
+
+	calls, last := 0, 0
+	ev := &Evaluator{Workers: 4, Progress: func(done int, total int) {
+		calls++
+		if done != last+1 || total != len(agents) {
+			t.Errorf("unexpected progress %d of %d after %d", done, total, last)
+		}
+		last = done
+	}}
+
+	buckets, err := ev.Evaluate(context.Background(), agents, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if calls != len(agents) {
+		t.Errorf("expected %d progress calls, returned %d", len(agents), calls)
+	}
+
+	for i, ag := range agents {
+		expected, _ := ag.Backtest(pc)
+		if len(buckets[i].Trades) != len(expected.Trades) || buckets[i].ProfitPerDay() != expected.ProfitPerDay() {
+			t.Errorf("bucket %d doesn't match its agent", i)
+		}
+	}
+}
+
+func TestEvaluator_Cancelled(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := (&Evaluator{}).Evaluate(ctx, evaluatorAgents(50), pc); err != context.Canceled {
+		t.Errorf("expected %v, returned %v", context.Canceled, err)
+	}
+}
+
+func TestEvaluator_CancelFromProgress(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ev := &Evaluator{Workers: 2, Progress: func(done int, total int) {
+		if done == 3 {
+			cancel()
+		}
+	}}
+	if _, err := ev.Evaluate(ctx, evaluatorAgents(100), pc); err != context.Canceled {
+		t.Errorf("expected %v, returned %v", context.Canceled, err)
+	}
+}
+
+func TestEvaluator_AgentError(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	agents := evaluatorAgents(10)
+	agents[7].Rule = IndRule(len(agents[7].Indicators))
+
+	if _, err := (&Evaluator{Workers: 3}).Evaluate(context.Background(), agents, pc); err != ErrRuleIndexIsOutOfRange {
+		t.Errorf("expected %v, returned %v", ErrRuleIndexIsOutOfRange, err)
+	}
+}
+
+func TestEvaluator_Empty(t *testing.T) {
+	pc, _ := NewPairColumns(nil, nil)
+
+	buckets, err := (&Evaluator{}).Evaluate(context.Background(), nil, pc)
+	if err != nil || len(buckets) != 0 {
+		t.Errorf("expected no buckets, returned %v, %v", buckets, err)
+	}
+}
+
+// run with -race to check concurrent read only use
+func TestAgent_ConcurrentReadOnlyUse(t *testing.T) {
+	rand.Seed(32)
+	klns1, klns2 := randomWalkKlines(600, 1), randomWalkKlines(600, 2)
+	pc, _ := NewPairColumns(klns1, klns2)
+
+	ag := RandomAgent()
+	bb := &BB{Mon: CloseR, ValuePos: Above, Line: Upper, Period: 50, Multiplier: 1}
+	rsi := &RSI{Mon: LogCloseR, ValuePos: Below, TargetVal: 40, Period: 30, Smoothing: EMA, Length: 10}
+
+	expOpen, _ := ag.OpenPos(klns1, klns2, nil)
+	expBB, _ := bb.Score(klns1[550:], klns2[550:])
+	expRSI, _ := rsi.Score(klns1[570:], klns2[570:])
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if open, _ := ag.OpenPos(klns1, klns2, nil); open != expOpen {
+					t.Errorf("concurrent OpenPos returned %v, expected %v", open, expOpen)
+				}
+				if open, _ := ag.OpenPosCols(pc, 600, nil); open != expOpen {
+					t.Errorf("concurrent OpenPosCols returned %v, expected %v", open, expOpen)
+				}
+				if sc, _ := bb.Score(klns1[550:], klns2[550:]); sc != expBB {
+					t.Errorf("concurrent bb score returned %f, expected %f", sc, expBB)
+				}
+				if sc, _ := rsi.Score(klns1[570:], klns2[570:]); sc != expRSI {
+					t.Errorf("concurrent rsi score returned %f, expected %f", sc, expRSI)
+				}
+				ag.Signals(pc)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Benchmark_Evaluator(b *testing.B) {
+	rand.Seed(1)
+	pc, _ := NewPairColumns(randomWalkKlines(20_000, 1), randomWalkKlines(20_000, 2))
+	agents := make([]*Agent, 200)
+	for i := range agents {
+		agents[i] = RandomAgent()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		(&Evaluator{}).Evaluate(context.Background(), agents, pc)
+	}
+}
@@ -23,19 +23,28 @@ const (
 	Upper
 )
 
+// BB evaluation only reads its fields, a BB can be shared by
+// concurrent evaluations unless it is being mutated
 type BB struct {
 	Mon        Monitor  `json:"mon"`
 	ValuePos   ValuePos `json:"val_pos"`
 	Line       BBLine   `json:"line"`
 	Period     int      `json:"period"`
 	Multiplier float64  `json:"multiplier"`
+	Timeframe  int      `json:"tf,omitempty"`
 }
 
 const (
-	minPeriod     = 10
-	maxPeriod     = 250
-	minMultiplier = float64(0.5)
-	maxMultiplier = float64(5.0)
+	bbKind  = "bb"
+	rsiKind = "rsi"
+)
+
+const (
+	minPeriod      = 10
+	maxPeriod      = 250
+	minMultiplier  = float64(0.5)
+	maxMultiplier  = float64(5.0)
+	multiplierStep = float64(0.1)
 )
 
 func randPeriod() int {
@@ -55,6 +64,54 @@ func RandomBB() *BB {
 		Line:       BBLine(rand.Intn(3)),
 		Period:     randPeriod(),
 		Multiplier: randMultiplier(),
+		Timeframe:  randTimeframe(),
+	}
+}
+
+func (bb *BB) Kind() string {
+	return bbKind
+}
+
+func (bb *BB) Lookback() int {
+	return bb.Period
+}
+
+func (bb *BB) Monitor() Monitor {
+	return bb.Mon
+}
+
+func (bb *BB) TimeframeMult() int {
+	return bb.Timeframe
+}
+
+func (bb *BB) Params() []Param {
+	return append([]Param{
+		periodParam(&bb.Period),
+		floatParam("multiplier", &bb.Multiplier, multiplierStep, multiplierStep, minMultiplier, maxMultiplier),
+	}, timeframeParams(&bb.Timeframe)...)
+}
+
+func (bb *BB) Clone() Indicator {
+	c := *bb
+	return &c
+}
+
+func (bb *BB) Mutate() {
+	if mutatesTimeframe(6) {
+		bb.Timeframe = mutateTimeframe(bb.Timeframe)
+		return
+	}
+	switch rand.Intn(5) {
+	case 0:
+		bb.Mon = randMon()
+	case 1:
+		bb.ValuePos = 1 - bb.ValuePos
+	case 2:
+		bb.Line = BBLine(rand.Intn(3))
+	case 3:
+		bb.Period = mutatePeriod(bb.Period)
+	case 4:
+		bb.Multiplier = mutateStepped(bb.Multiplier, multiplierStep, minMultiplier, maxMultiplier)
 	}
 }
 
@@ -62,13 +119,25 @@ const (
 	epsilon = float64(0.0000000001)
 )
 
-func klinesToMonValues(mon Monitor, period int, klns1 []klines.Kline,
-	klns2 []klines.Kline) ([]float64, error) {
+func checkKlinesLen(period int, klns1 []klines.Kline, klns2 []klines.Kline) error {
 	if len(klns1) != period {
-		return nil, fmt.Errorf("klns1 length %d should be %d", len(klns1), period)
+		return fmt.Errorf("klns1 length %d should be %d", len(klns1), period)
 	}
 	if len(klns2) != period {
-		return nil, fmt.Errorf("klns2 length %d should be %d", len(klns2), period)
+		return fmt.Errorf("klns2 length %d should be %d", len(klns2), period)
+	}
+	return nil
+}
+
+func klinesToMonValues(mon Monitor, period int, klns1 []klines.Kline,
+	klns2 []klines.Kline) ([]float64, error) {
+	if err := checkKlinesLen(period, klns1, klns2); err != nil {
+		return nil, err
+	}
+
+	var beta float64
+	if mon == Spread {
+		beta = hedgeRatio(klns1, klns2)
 	}
 
 	res := make([]float64, len(klns1))
@@ -111,6 +180,33 @@ func klinesToMonValues(mon Monitor, period int, klns1 []klines.Kline,
 			res[i] = float64(klns2[i].NumberOfTrades)
 		case NotR:
 			res[i] = float64(klns1[i].NumberOfTrades) / (float64(klns2[i].NumberOfTrades) + epsilon)
+		case Return1:
+			res[i] = barReturn(klns1, i)
+		case Return2:
+			res[i] = barReturn(klns2, i)
+		case ReturnR:
+			res[i] = barReturn(klns1, i) - barReturn(klns2, i)
+		case LogCloseR:
+			res[i] = math.Log((klns1[i].Close + epsilon) / (klns2[i].Close + epsilon))
+		case Spread:
+			res[i] = klns1[i].Close - beta*klns2[i].Close
+		case Body1:
+			res[i] = barBody(klns1[i])
+		case Body2:
+			res[i] = barBody(klns2[i])
+		case BodyR:
+			b2 := barBody(klns2[i])
+			if b2 == 0 {
+				res[i] = 0
+			} else {
+				res[i] = barBody(klns1[i]) / b2
+			}
+		case QuoteVol1:
+			res[i] = klns1[i].Volume * typicalPrice(klns1[i])
+		case QuoteVol2:
+			res[i] = klns2[i].Volume * typicalPrice(klns2[i])
+		case QuoteVolR:
+			res[i] = (klns1[i].Volume * typicalPrice(klns1[i])) / (klns2[i].Volume*typicalPrice(klns2[i]) + epsilon)
 		default:
 			return nil, fmt.Errorf("mon %d is not defined", mon)
 		}
@@ -118,6 +214,61 @@ func klinesToMonValues(mon Monitor, period int, klns1 []klines.Kline,
 	return res, nil
 }
 
+// barReturn uses the open of the first bar of a window as its previous close
+func barReturn(klns []klines.Kline, i int) float64 {
+	prev := klns[i].Open
+	if i > 0 {
+		prev = klns[i-1].Close
+	}
+	return simpleReturn(prev, klns[i].Close)
+}
+
+func simpleReturn(prev float64, close float64) float64 {
+	if prev == 0 {
+		return 0
+	}
+	return close/prev - 1
+}
+
+func barBody(kln klines.Kline) float64 {
+	return math.Abs(kln.Close-kln.Open) / (kln.Open + epsilon)
+}
+
+// hedgeRatio is the ols beta of close1 on close2, falling back to the
+// ratio of means when close2 does not move
+func hedgeRatio(klns1 []klines.Kline, klns2 []klines.Kline) float64 {
+	close1, close2 := make([]float64, len(klns1)), make([]float64, len(klns2))
+	for i := range klns1 {
+		close1[i], close2[i] = klns1[i].Close, klns2[i].Close
+	}
+	return hedgeRatioOf(close1, close2)
+}
+
+func hedgeRatioOf(close1 []float64, close2 []float64) float64 {
+	n := float64(len(close1))
+	if n == 0 {
+		return 0
+	}
+
+	var m1, m2 float64
+	for i := range close1 {
+		m1 += close1[i]
+		m2 += close2[i]
+	}
+	m1, m2 = m1/n, m2/n
+
+	var cov, var2 float64
+	for i := range close1 {
+		d2 := close2[i] - m2
+		cov += (close1[i] - m1) * d2
+		var2 += d2 * d2
+	}
+	if var2 == 0 {
+		return m1 / (m2 + epsilon)
+	}
+	return cov / var2
+}
+
 func mean(vals []float64) (float64, error) {
 	vlen := len(vals)
 	if vlen == 0 {
@@ -145,57 +296,113 @@ func stddev(vals []float64, mean float64) (float64, error) {
 	return math.Sqrt(variance), nil
 }
 
-func (bb *BB) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+// levels returns the last monitor value, the bb line it is compared
+// to and the stddev of the window
+func (bb *BB) levels(klns1 []klines.Kline, klns2 []klines.Kline) (float64, float64, float64, error) {
 	vals, err := klinesToMonValues(bb.Mon, bb.Period, klns1, klns2)
 	if err != nil {
-		return false, err
+		return 0, 0, 0, err
 	}
+	return bb.levelsOf(vals)
+}
 
+func (bb *BB) levelsOf(vals []float64) (float64, float64, float64, error) {
 	mn, err := mean(vals)
 	if err != nil {
-		return false, err
+		return 0, 0, 0, err
 	}
 	std, err := stddev(vals, mn)
 	if err != nil {
-		return false, err
+		return 0, 0, 0, err
 	}
 
 	lastVal := vals[len(vals)-1]
 	switch bb.Line {
 	case Lower:
-		if bb.ValuePos == Above {
-			return lastVal > (mn - bb.Multiplier*std), nil
-		} else if bb.ValuePos == Below {
-			return lastVal < (mn - bb.Multiplier*std), nil
-		}
+		return lastVal, mn - bb.Multiplier*std, std, nil
 	case Middle:
-		if bb.ValuePos == Above {
-			return lastVal > mn, nil
-		} else if bb.ValuePos == Below {
-			return lastVal < mn, nil
-		}
+		return lastVal, mn, std, nil
 	case Upper:
-		if bb.ValuePos == Above {
-			return lastVal > (mn + bb.Multiplier*std), nil
-		} else if bb.ValuePos == Below {
-			return lastVal < (mn + bb.Multiplier*std), nil
-		}
-	default:
-		return false, fmt.Errorf("bbline %d is not defined", bb.Line)
+		return lastVal, mn + bb.Multiplier*std, std, nil
+	}
+	return 0, 0, 0, fmt.Errorf("bbline %d is not defined", bb.Line)
+}
+
+func (bb *BB) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	return bb.active(bb.levels(klns1, klns2))
+}
+
+func (bb *BB) activeVals(vals []float64) (bool, error) {
+	return bb.active(bb.levelsOf(vals))
+}
+
+func (bb *BB) active(lastVal float64, line float64, _ float64, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+
+	switch bb.ValuePos {
+	case Above:
+		return lastVal > line, nil
+	case Below:
+		return lastVal < line, nil
 	}
 	return false, nil
 }
 
+// Score is the distance of the last value to the bb line in stddevs
+func (bb *BB) Score(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	return bb.score(bb.levels(klns1, klns2))
+}
+
+func (bb *BB) scoreVals(vals []float64) (float64, error) {
+	return bb.score(bb.levelsOf(vals))
+}
+
+func (bb *BB) score(lastVal float64, line float64, std float64, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := signedDistance(bb.ValuePos, lastVal, line)
+	if err != nil {
+		return 0, err
+	}
+	return d / (std + epsilon), nil
+}
+
 const (
 	minTVal = 5
 	maxTVal = 95
 )
 
+// Simple sums gains, losses over the whole period (cutler's rsi).
+// Wilder and EMA smooth them with a Length long average, the rest
+// of the period is used as warm up so values converge to what
+// charting tools show
+type RSISmoothing uint8
+
+const (
+	Simple RSISmoothing = iota
+	Wilder
+	EMA
+)
+
+const (
+	minRSILength     = 2
+	maxRSILength     = 50
+	rsiWarmupDivisor = 3
+)
+
+// RSI is safe for concurrent read only use like BB
 type RSI struct {
-	Mon       Monitor  `json:"mon"`
-	ValuePos  ValuePos `json:"val_pos"`
-	TargetVal float64  `json:"target_val"`
-	Period    int      `json:"period"`
+	Mon       Monitor      `json:"mon"`
+	ValuePos  ValuePos     `json:"val_pos"`
+	TargetVal float64      `json:"target_val"`
+	Period    int          `json:"period"`
+	Smoothing RSISmoothing `json:"smoothing"`
+	Length    int          `json:"length,omitempty"`
+	Timeframe int          `json:"tf,omitempty"`
 }
 
 func randTargetVal() float64 {
@@ -204,12 +411,88 @@ func randTargetVal() float64 {
 	return float64(tval)
 }
 
+func randRSILength(period int) int {
+	maxLen := period / rsiWarmupDivisor
+	if maxLen > maxRSILength {
+		maxLen = maxRSILength
+	}
+	if maxLen <= minRSILength {
+		return minRSILength
+	}
+	return rand.Intn(maxLen-minRSILength+1) + minRSILength
+}
+
 func RandomRSI() *RSI {
-	return &RSI{
+	rsi := &RSI{
 		Mon:       randMon(),
 		ValuePos:  ValuePos(rand.Intn(2)),
 		TargetVal: randTargetVal(),
 		Period:    randPeriod(),
+		Smoothing: RSISmoothing(rand.Intn(3)),
+		Timeframe: randTimeframe(),
+	}
+	if rsi.Smoothing != Simple {
+		rsi.Length = randRSILength(rsi.Period)
+	}
+	return rsi
+}
+
+func (rsi *RSI) Kind() string {
+	return rsiKind
+}
+
+func (rsi *RSI) Lookback() int {
+	return rsi.Period
+}
+
+func (rsi *RSI) Monitor() Monitor {
+	return rsi.Mon
+}
+
+func (rsi *RSI) TimeframeMult() int {
+	return rsi.Timeframe
+}
+
+// Params keeps the period long enough for the smoothing length
+func (rsi *RSI) Params() []Param {
+	period := periodParam(&rsi.Period)
+	if rsi.Smoothing != Simple {
+		period.Min = math.Max(period.Min, float64(rsi.Length*rsiWarmupDivisor))
+	}
+	return append([]Param{
+		period,
+		floatParam("target_val", &rsi.TargetVal, targetValParamStep, 1, minTVal, maxTVal),
+	}, timeframeParams(&rsi.Timeframe)...)
+}
+
+func (rsi *RSI) Clone() Indicator {
+	c := *rsi
+	return &c
+}
+
+func (rsi *RSI) Mutate() {
+	if mutatesTimeframe(6) {
+		rsi.Timeframe = mutateTimeframe(rsi.Timeframe)
+		return
+	}
+	switch rand.Intn(5) {
+	case 0:
+		rsi.Mon = randMon()
+	case 1:
+		rsi.ValuePos = 1 - rsi.ValuePos
+	case 2:
+		rsi.TargetVal = mutateStepped(rsi.TargetVal, 1, minTVal, maxTVal)
+	case 3:
+		rsi.Period = mutatePeriod(rsi.Period)
+	case 4:
+		rsi.Smoothing = RSISmoothing(rand.Intn(3))
+	}
+
+	// keep length valid for the (possibly) new period and smoothing
+	if rsi.Smoothing == Simple {
+		rsi.Length = 0
+	} else if rsi.Length < minRSILength || rsi.Length > rsi.Period/rsiWarmupDivisor {
+		rsi.Length = randRSILength(rsi.Period)
 	}
 }
 
@@ -233,23 +516,88 @@ func calcRsi(values []float64) (float64, error) {
 	return 100 - (100 / (1 + (gains / losses))), nil
 }
 
-func (rsi *RSI) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+func calcSmoothedRsi(values []float64, length int, alpha float64) (float64, error) {
+	if length < 1 || length > len(values)-1 {
+		return 0, fmt.Errorf("rsi length %d should be in [1, %d]", length, len(values)-1)
+	}
+
+	// seed averages with the simple mean of the first length diffs
+	var avgGain, avgLoss float64
+	for i := 1; i <= length; i++ {
+		diff := values[i] - values[i-1]
+		if diff > 0 {
+			avgGain += diff
+		} else {
+			avgLoss -= diff
+		}
+	}
+	avgGain /= float64(length)
+	avgLoss /= float64(length)
+
+	for i := length + 1; i < len(values); i++ {
+		gain, loss := 0.0, 0.0
+		diff := values[i] - values[i-1]
+		if diff > 0 {
+			gain = diff
+		} else {
+			loss = -diff
+		}
+		avgGain += alpha * (gain - avgGain)
+		avgLoss += alpha * (loss - avgLoss)
+	}
+	if avgLoss == 0 {
+		return 100, nil
+	}
+	return 100 - (100 / (1 + (avgGain / avgLoss))), nil
+}
+
+func (rsi *RSI) calc(values []float64) (float64, error) {
+	switch rsi.Smoothing {
+	case Simple:
+		return calcRsi(values)
+	case Wilder:
+		return calcSmoothedRsi(values, rsi.Length, 1.0/float64(rsi.Length))
+	case EMA:
+		return calcSmoothedRsi(values, rsi.Length, 2.0/float64(rsi.Length+1))
+	}
+	return 0, fmt.Errorf("rsi smoothing %d is not defined", rsi.Smoothing)
+}
+
+func (rsi *RSI) value(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
 	vals, err := klinesToMonValues(rsi.Mon, rsi.Period, klns1, klns2)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
+	return rsi.calc(vals)
+}
+
+func (rsi *RSI) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	return rsi.active(rsi.value(klns1, klns2))
+}
+
+func (rsi *RSI) activeVals(vals []float64) (bool, error) {
+	return rsi.active(rsi.calc(vals))
+}
 
-	r, err := calcRsi(vals)
+func (rsi *RSI) active(r float64, err error) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	return compareTarget(rsi.ValuePos, r, rsi.TargetVal)
+}
 
-	switch rsi.ValuePos {
-	case Above:
-		return r > rsi.TargetVal, nil
-	case Below:
-		return r < rsi.TargetVal, nil
-	default:
-		return false, fmt.Errorf("valuePos %v is not defined", rsi.ValuePos)
+// Score is the distance of the rsi to the target value in rsi points
+func (rsi *RSI) Score(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	return rsi.score(rsi.value(klns1, klns2))
+}
+
+func (rsi *RSI) scoreVals(vals []float64) (float64, error) {
+	return rsi.score(rsi.calc(vals))
+}
+
+func (rsi *RSI) score(r float64, err error) (float64, error) {
+	if err != nil {
+		return 0, err
 	}
+	return signedDistance(rsi.ValuePos, r, rsi.TargetVal)
 }
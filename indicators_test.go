@@ -334,6 +334,151 @@ func TestKTMV_ReturnsExpectedNotRs(t *testing.T) {
 	}
 }
 
+func TestKTMV_ReturnsExpectedReturn1s(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+
+	res, _ := klinesToMonValues(Return1, 4, klns1, klns2)
+	expected := []float64{0, 1, 0.5, 0.33}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_Return1UsesOpenOfFirstBar(t *testing.T) {
+	klns1, klns2 := dummyKlines(5)[1:], dummyKlines(5)[1:]
+
+	res, _ := klinesToMonValues(Return1, 4, klns1, klns2)
+	if res[0] != 1 {
+		t.Errorf("i 0 is %.2f but expected %.2f", res[0], 1.0)
+	}
+}
+
+func TestKTMV_ReturnsExpectedReturnRs(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+	klns2[3].Close = 3
+
+	res, _ := klinesToMonValues(ReturnR, 4, klns1, klns2)
+	expected := []float64{0, 0, 0, 0.33}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_ReturnsExpectedLogCloseRs(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+	for i := range klns2 {
+		klns2[i].Close *= 2
+	}
+
+	res, _ := klinesToMonValues(LogCloseR, 4, klns1, klns2)
+	expected := []float64{-0.69, -0.69, -0.69, -0.69}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_ReturnsExpectedSpreads(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+	for i := range klns1 {
+		klns1[i].Close = 2*klns2[i].Close + 1
+	}
+
+	res, _ := klinesToMonValues(Spread, 4, klns1, klns2)
+	expected := []float64{1, 1, 1, 1}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestHedgeRatio_FlatClose2(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+	for i := range klns2 {
+		klns1[i].Close, klns2[i].Close = 6, 2
+	}
+
+	if b := hedgeRatio(klns1, klns2); math.Round(b*100.0)/100.0 != 3 {
+		t.Errorf("expected hedge ratio %.2f, returned %.2f", 3.0, b)
+	}
+}
+
+func TestKTMV_ReturnsExpectedBody1s(t *testing.T) {
+	klns1, klns2 := dummyKlines(5)[1:], dummyKlines(5)[1:]
+
+	res, _ := klinesToMonValues(Body1, 4, klns1, klns2)
+	expected := []float64{1, 0.5, 0.33, 0.25}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_ReturnsExpectedBodyRs(t *testing.T) {
+	klns1, klns2 := dummyKlines(5)[1:], dummyKlines(5)[1:]
+	klns2[2].Close = klns2[2].Open
+	klns2[3].Close = 7
+
+	res, _ := klinesToMonValues(BodyR, 4, klns1, klns2)
+	expected := []float64{1, 1, 0, 0.333}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*1000.0)/1000.0 != exp {
+			t.Errorf("i %d is %.3f but expected %.3f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_ReturnsExpectedQuoteVol1s(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+
+	res, _ := klinesToMonValues(QuoteVol1, 4, klns1, klns2)
+	expected := []float64{0, 2000, 6000, 12000}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_ReturnsExpectedQuoteVolRs(t *testing.T) {
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+	klns2[3].Volume = 1500
+
+	res, _ := klinesToMonValues(QuoteVolR, 4, klns1, klns2)
+	expected := []float64{0, 1, 1, 2}
+
+	for i, exp := range expected {
+		if math.Round(res[i]*100.0)/100.0 != exp {
+			t.Errorf("i %d is %.2f but expected %.2f", i, res[i], exp)
+		}
+	}
+}
+
+func TestKTMV_AllMonitorsDefined(t *testing.T) {
+	for mon := Close1; mon <= QuoteVolR; mon++ {
+		if _, err := klinesToMonValues(mon, 4, dummyKlines(4), dummyKlines(4)); err != nil {
+			t.Errorf("mon %d raised %v", mon, err)
+		}
+	}
+	if _, err := klinesToMonValues(QuoteVolR+1, 4, dummyKlines(4), dummyKlines(4)); err == nil {
+		t.Errorf("expected error for undefined mon, nothing raised")
+	}
+}
+
 func TestMean_RaiseOn0LenVals(t *testing.T) {
 	vals := make([]float64, 0)
 
@@ -642,6 +787,97 @@ func TestCalcRSI_Expecting25(t *testing.T) {
 	}
 }
 
+func TestRandRSI_AssignsAllSmoothings(t *testing.T) {
+	count := 0
+	for _, sm := range []RSISmoothing{Simple, Wilder, EMA} {
+		for {
+			rsi := RandomRSI()
+			if rsi.Smoothing == sm {
+				count++
+				break
+			}
+		}
+	}
+	if count != 3 {
+		t.Errorf("not all smoothings assinged randomly")
+	}
+}
+
+func TestRandRSI_Length(t *testing.T) {
+	for i := 0; i < 10_000; i++ {
+		rsi := RandomRSI()
+
+		if rsi.Smoothing == Simple && rsi.Length != 0 {
+			t.Errorf("simple rsi should not have a length")
+		}
+		if rsi.Smoothing != Simple && (rsi.Length < minRSILength || rsi.Length > maxRSILength ||
+			rsi.Length > rsi.Period-1) {
+			t.Errorf("rsi length %d is outside of boundries for period %d", rsi.Length, rsi.Period)
+		}
+	}
+}
+
+func TestCalcSmoothedRSI_InvalidLength(t *testing.T) {
+	for _, length := range []int{0, 5} {
+		if _, err := calcSmoothedRsi([]float64{1, 2, 3, 2, 1}, length, 0.5); err == nil {
+			t.Errorf("expected error for length %d but nothing raised", length)
+		}
+	}
+}
+
+func TestCalcSmoothedRSI_Wilder(t *testing.T) {
+	rsi := &RSI{Smoothing: Wilder, Length: 2}
+
+	r, err := rsi.calc([]float64{1, 2, 3, 2, 1})
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := 25.0
+	if r != expected {
+		t.Errorf("expected %.2f but returned %.2f", expected, r)
+	}
+}
+
+func TestCalcSmoothedRSI_EMA(t *testing.T) {
+	rsi := &RSI{Smoothing: EMA, Length: 2}
+
+	r, err := rsi.calc([]float64{1, 2, 3, 2, 1})
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := 11.11
+	if math.Round(r*100.0)/100.0 != expected {
+		t.Errorf("expected %.2f but returned %.2f", expected, r)
+	}
+}
+
+func TestCalcSmoothedRSI_FullLengthEqualsSimple(t *testing.T) {
+	vals := []float64{5, 4, 3, 2, 3, 6, 1}
+	rsi := &RSI{Smoothing: Wilder, Length: len(vals) - 1}
+
+	r, _ := rsi.calc(vals)
+	expected, _ := calcRsi(vals)
+	if math.Abs(r-expected) > epsilon {
+		t.Errorf("expected %.4f but returned %.4f", expected, r)
+	}
+}
+
+func TestRSI_Active_UndefinedSmoothing(t *testing.T) {
+	rsi := &RSI{
+		Mon:       Close1,
+		ValuePos:  Above,
+		TargetVal: 49,
+		Period:    6,
+		Smoothing: RSISmoothing(9),
+	}
+
+	if _, err := rsi.Active(dummyKlines(6), dummyKlines(6)); err == nil {
+		t.Errorf("expected error nothing raised")
+	}
+}
+
 func TestRSI_Active_Above(t *testing.T) {
 	rsi := &RSI{
 		Mon:       Close1,
@@ -743,6 +979,17 @@ func Benchmark_RSI(b *testing.B) {
 	}
 }
 
+func Benchmark_RSI_Wilder(b *testing.B) {
+	rsi := RandomRSI()
+	rsi.Period = 250
+	rsi.Smoothing = Wilder
+	rsi.Length = 14
+
+	for i := 0; i < b.N; i++ {
+		rsi.Active(benchmarkKlns, benchmarkKlns)
+	}
+}
+
 func Benchmark_BB_Period10(b *testing.B) {
 	bb := RandomBB()
 	bb.Period = 10
@@ -0,0 +1,358 @@
+package agent2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	defaultMigrationInterval = 5
+	migrationPollMillis      = 20
+)
+
+// Topology tells which islands an island sends its migrants to
+type Topology uint8
+
+const (
+	// Ring sends to the next island, the last one to the first
+	Ring Topology = iota
+	// FullyConnected sends to every other island
+	FullyConnected
+)
+
+func (tp Topology) String() string {
+	switch tp {
+	case Ring:
+		return "ring"
+	case FullyConnected:
+		return "fullyConnected"
+	}
+	return ""
+}
+
+// Targets are the islands id sends to out of n
+func (tp Topology) Targets(id int, n int) []int {
+	res := make([]int, 0)
+	switch tp {
+	case Ring:
+		if n > 1 {
+			res = append(res, (id+1)%n)
+		}
+	case FullyConnected:
+		for to := 0; to < n; to++ {
+			if to != id {
+				res = append(res, to)
+			}
+		}
+	}
+	return res
+}
+
+// Sources are the islands id receives from out of n
+func (tp Topology) Sources(id int, n int) []int {
+	res := make([]int, 0)
+	for from := 0; from < n; from++ {
+		for _, to := range tp.Targets(from, n) {
+			if to == id {
+				res = append(res, from)
+			}
+		}
+	}
+	return res
+}
+
+// Migration is a batch of agents sent by island From after generation
+// Gen
+type Migration struct {
+	From   int
+	Gen    int
+	Agents []*Agent
+}
+
+// migrationJSON keeps agents as Agent.Marshal payloads
+type migrationJSON struct {
+	From   int               `json:"from"`
+	Gen    int               `json:"gen"`
+	Agents []json.RawMessage `json:"agents"`
+}
+
+// Marshal is a single line of json, agents are encoded by Agent.Marshal
+func (mg *Migration) Marshal() ([]byte, error) {
+	mj := migrationJSON{From: mg.From, Gen: mg.Gen, Agents: make([]json.RawMessage, len(mg.Agents))}
+	for i, ag := range mg.Agents {
+		pload, err := ag.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		mj.Agents[i] = pload
+	}
+	return json.Marshal(mj)
+}
+
+func UnmarshalMigration(pload []byte) (*Migration, error) {
+	var mj migrationJSON
+
+	if err := json.Unmarshal(pload, &mj); err != nil {
+		return nil, err
+	}
+	mg := &Migration{From: mj.From, Gen: mj.Gen, Agents: make([]*Agent, len(mj.Agents))}
+	for i, raw := range mj.Agents {
+		ag, err := UnmarshalAgent(raw)
+		if err != nil {
+			return nil, err
+		}
+		mg.Agents[i] = ag
+	}
+	return mg, nil
+}
+
+// Migrator carries migrations between islands, which can live in other
+// processes or on other hosts. Receive doesn't block, it returns the
+// migrations arrived since the last call. Malformed migrations are
+// dropped rather than returned as errors, as a receive error stops the
+// island
+type Migrator interface {
+	Send(ctx context.Context, to int, mg *Migration) error
+	Receive(ctx context.Context) ([]*Migration, error)
+}
+
+// Island evolves one of Islands populations. Offspring are mutated
+// clones of tournament winners, the best of parents and offspring
+// survive. Every MigrationInterval generations (defaults to 5) the
+// Migrants best agents are sent to the Topology targets and received
+// migrants replace the worst agents when better, migrants equal to an
+// agent of the population are skipped. With WaitMillis the
+// island waits up to that long for every source to send its migration
+// of the generation, without it migrants are taken as they arrive
+type Island struct {
+	ID                int
+	Islands           int
+	Population        int
+	Generations       int
+	MigrationInterval int
+	Migrants          int
+	Topology          Topology
+	WaitMillis        int64
+	Migrator          Migrator
+	// Fitness defaults to ProfitPerDayFitness
+	Fitness Fitness
+	// Init draws the first population, defaults to RandomAgent
+	Init      func() *Agent
+	Evaluator *Evaluator
+	// Progress is called after every generation with the best fitness,
+	// optional
+	Progress func(gen int, best float64)
+}
+
+// IslandResult holds the final population best first, pruned agents
+// left out. Sent and Received count migrant agents
+type IslandResult struct {
+	Agents    []*Agent  `json:"agents"`
+	Buckets   []*Bucket `json:"buckets"`
+	Fitnesses []float64 `json:"fitnesses"`
+	Sent      int       `json:"sent"`
+	Received  int       `json:"received"`
+}
+
+var (
+	ErrIslandIsNotComplete = fmt.Errorf("island needs a population of 2, a migrator and an id below islands")
+)
+
+// island is the population of an island sorted best first
+type island struct {
+	agents  []*Agent
+	buckets []*Bucket
+	fits    []float64
+	// early holds migrations of later generations than the current one
+	early []*Migration
+}
+
+func (is *Island) Run(ctx context.Context, pc *PairColumns) (*IslandResult, error) {
+	if is.Population < 2 || is.Migrator == nil || is.ID < 0 || is.ID >= is.Islands {
+		return nil, ErrIslandIsNotComplete
+	}
+	interval := is.MigrationInterval
+	if interval <= 0 {
+		interval = defaultMigrationInterval
+	}
+	fitness := is.Fitness
+	if fitness == nil {
+		fitness = ProfitPerDayFitness
+	}
+	init := is.Init
+	if init == nil {
+		init = RandomAgent
+	}
+	ev := is.Evaluator
+	if ev == nil {
+		ev = &Evaluator{}
+	}
+
+	pop := &island{}
+	agents := make([]*Agent, is.Population)
+	for i := range agents {
+		agents[i] = init()
+	}
+	if err := pop.merge(ctx, agents, pc, ev, fitness, is.Population); err != nil {
+		return nil, err
+	}
+
+	res := &IslandResult{}
+	for gen := 1; gen <= is.Generations; gen++ {
+		offspring := make([]*Agent, is.Population)
+		for i := range offspring {
+			a, b := rand.Intn(len(pop.agents)), rand.Intn(len(pop.agents))
+			// the population is sorted, the lower index wins
+			offspring[i] = pop.agents[min(a, b)].Clone()
+			offspring[i].Mutate()
+		}
+		if err := pop.merge(ctx, offspring, pc, ev, fitness, is.Population); err != nil {
+			return nil, err
+		}
+
+		if gen%interval == 0 {
+			sent, migrants, err := is.migrate(ctx, pop, gen)
+			if err != nil {
+				return nil, err
+			}
+			res.Sent += sent
+			res.Received += len(migrants)
+			fresh, err := pop.unseen(migrants)
+			if err != nil {
+				return nil, err
+			}
+			if err := pop.merge(ctx, fresh, pc, ev, fitness, is.Population); err != nil {
+				return nil, err
+			}
+		}
+
+		if is.Progress != nil {
+			is.Progress(gen, pop.fits[0])
+		}
+	}
+
+	for i, bu := range pop.buckets {
+		if !bu.Pruned {
+			res.Agents = append(res.Agents, pop.agents[i])
+			res.Buckets = append(res.Buckets, bu)
+			res.Fitnesses = append(res.Fitnesses, pop.fits[i])
+		}
+	}
+	return res, nil
+}
+
+// merge evaluates agents into the population keeping the best size
+func (pop *island) merge(ctx context.Context, agents []*Agent, pc *PairColumns, ev *Evaluator, fitness Fitness, size int) error {
+	if len(agents) == 0 {
+		return nil
+	}
+	buckets, err := ev.Evaluate(ctx, agents, pc)
+	if err != nil {
+		return err
+	}
+	for i, bu := range buckets {
+		fit := math.Inf(-1)
+		if !bu.Pruned {
+			fit = fitness(bu)
+		}
+		pop.agents, pop.buckets, pop.fits = append(pop.agents, agents[i]), append(pop.buckets, bu), append(pop.fits, fit)
+	}
+
+	order := make([]int, len(pop.agents))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return pop.fits[order[a]] > pop.fits[order[b]] })
+	order = order[:min(size, len(order))]
+
+	agents, nbuckets, fits := make([]*Agent, len(order)), make([]*Bucket, len(order)), make([]float64, len(order))
+	for i, j := range order {
+		agents[i], nbuckets[i], fits[i] = pop.agents[j], pop.buckets[j], pop.fits[j]
+	}
+	pop.agents, pop.buckets, pop.fits = agents, nbuckets, fits
+	return nil
+}
+
+// unseen drops agents equal to one of the population or to an earlier
+// one, such as the own best agents coming back on a ring of 2
+func (pop *island) unseen(agents []*Agent) ([]*Agent, error) {
+	seen := make(map[string]bool, len(pop.agents)+len(agents))
+	for _, ag := range pop.agents {
+		pload, err := ag.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		seen[string(pload)] = true
+	}
+
+	res := make([]*Agent, 0, len(agents))
+	for _, ag := range agents {
+		pload, err := ag.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if !seen[string(pload)] {
+			seen[string(pload)] = true
+			res = append(res, ag)
+		}
+	}
+	return res, nil
+}
+
+// migrate sends the best agents of the population and returns the
+// number of agents sent and the migrants received
+func (is *Island) migrate(ctx context.Context, pop *island, gen int) (int, []*Agent, error) {
+	mg := &Migration{From: is.ID, Gen: gen, Agents: pop.agents[:min(max(1, is.Migrants), len(pop.agents))]}
+	sent := 0
+	for _, to := range is.Topology.Targets(is.ID, is.Islands) {
+		if err := is.Migrator.Send(ctx, to, mg); err != nil {
+			return 0, nil, err
+		}
+		sent += len(mg.Agents)
+	}
+
+	sources := is.Topology.Sources(is.ID, is.Islands)
+	arrived := make(map[int]bool, len(sources))
+	deadline := time.Now().Add(time.Duration(is.WaitMillis) * time.Millisecond)
+	ticker := time.NewTicker(migrationPollMillis * time.Millisecond)
+	defer ticker.Stop()
+
+	migrants := make([]*Agent, 0)
+	take := func(mgs []*Migration) {
+		for _, m := range mgs {
+			if is.WaitMillis > 0 && m.Gen > gen {
+				pop.early = append(pop.early, m)
+				continue
+			}
+			if m.Gen == gen {
+				arrived[m.From] = true
+			}
+			migrants = append(migrants, m.Agents...)
+		}
+	}
+
+	early := pop.early
+	pop.early = nil
+	take(early)
+	for {
+		mgs, err := is.Migrator.Receive(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		take(mgs)
+
+		if len(arrived) >= len(sources) || !time.Now().Before(deadline) {
+			return sent, migrants, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
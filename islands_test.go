@@ -0,0 +1,215 @@
+package agent2
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTopology(t *testing.T) {
+	cases := []struct {
+		tp      Topology
+		targets []int
+		sources []int
+	}{
+		{Ring, []int{2}, []int{0}},
+		{FullyConnected, []int{0, 2, 3}, []int{0, 2, 3}},
+	}
+	for _, cs := range cases {
+		if targets := cs.tp.Targets(1, 4); !reflect.DeepEqual(targets, cs.targets) {
+			t.Errorf("expected %s targets %v, returned %v", cs.tp, cs.targets, targets)
+		}
+		if sources := cs.tp.Sources(1, 4); !reflect.DeepEqual(sources, cs.sources) {
+			t.Errorf("expected %s sources %v, returned %v", cs.tp, cs.sources, sources)
+		}
+	}
+
+	// a single island has nowhere to send
+	if targets := Ring.Targets(0, 1); len(targets) != 0 {
+		t.Errorf("expected no targets, returned %v", targets)
+	}
+}
+
+func TestMigration_Marshal(t *testing.T) {
+	mg := &Migration{From: 2, Gen: 10, Agents: []*Agent{robustnessAgent(), RandomAgent()}}
+	pload, err := mg.Marshal()
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for _, b := range pload {
+		if b == '\n' {
+			t.Fatalf("expected a single line payload")
+		}
+	}
+
+	res, err := UnmarshalMigration(pload)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if res.From != 2 || res.Gen != 10 || len(res.Agents) != 2 {
+		t.Fatalf("expected migration 2 10 of 2 agents, returned %d %d of %d", res.From, res.Gen, len(res.Agents))
+	}
+	for i, ag := range mg.Agents {
+		want, _ := ag.Marshal()
+		if got, _ := res.Agents[i].Marshal(); string(got) != string(want) {
+			t.Errorf("expected agent %d %s, returned %s", i, want, got)
+		}
+	}
+}
+
+// memMigrator passes migrations between islands of a process
+type memMigrator struct {
+	id    int
+	mu    *sync.Mutex
+	boxes map[int][]*Migration
+}
+
+func memMigrators(n int) []*memMigrator {
+	mu, boxes := &sync.Mutex{}, make(map[int][]*Migration)
+	res := make([]*memMigrator, n)
+	for i := range res {
+		res[i] = &memMigrator{id: i, mu: mu, boxes: boxes}
+	}
+	return res
+}
+
+func (mm *memMigrator) Send(ctx context.Context, to int, mg *Migration) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.boxes[to] = append(mm.boxes[to], mg)
+	return nil
+}
+
+func (mm *memMigrator) Receive(ctx context.Context) ([]*Migration, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	res := mm.boxes[mm.id]
+	delete(mm.boxes, mm.id)
+	return res, nil
+}
+
+func TestIsland_Run(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	migrators := memMigrators(3)
+
+	results := make([]*IslandResult, 3)
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for id := range results {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			is := &Island{ID: id, Islands: 3, Population: 6, Generations: 6, MigrationInterval: 2,
+				Migrants: 2, WaitMillis: 10_000, Migrator: migrators[id]}
+			results[id], errs[id] = is.Run(context.Background(), pc)
+		}(id)
+	}
+	wg.Wait()
+
+	for id, res := range results {
+		if errs[id] != nil {
+			t.Fatalf("expected no error but raised %v", errs[id])
+		}
+		// 3 migrations of 2 agents on a ring
+		if res.Sent != 6 || res.Received != 6 {
+			t.Errorf("expected 6 agents sent and received, returned %d %d", res.Sent, res.Received)
+		}
+		if len(res.Agents) != 6 || len(res.Buckets) != 6 {
+			t.Errorf("expected a population of 6, returned %d", len(res.Agents))
+		}
+		if !sort.SliceIsSorted(res.Fitnesses, func(a, b int) bool { return res.Fitnesses[a] > res.Fitnesses[b] }) {
+			t.Errorf("expected fitnesses best first, returned %v", res.Fitnesses)
+		}
+	}
+}
+
+func TestIsland_PrunedAreLeftOut(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	ev := &Evaluator{Prune: &PruneRules{MinProfitPerDay: 1e9, MinProfitPerDayAfterMillis: 1}}
+
+	is := &Island{Islands: 1, Population: 4, Generations: 2, Migrator: memMigrators(1)[0], Evaluator: ev}
+	res, err := is.Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Agents) != 0 || len(res.Fitnesses) != 0 {
+		t.Errorf("expected pruned agents left out, returned %d", len(res.Agents))
+	}
+	if _, err := json.Marshal(res); err != nil {
+		t.Errorf("expected a json result but raised %v", err)
+	}
+}
+
+func TestIsland_Unseen(t *testing.T) {
+	pop := &island{agents: []*Agent{robustnessAgent()}}
+	other := robustnessAgent()
+	other.ExpiryMillis *= 2
+
+	res, err := pop.unseen([]*Agent{robustnessAgent(), other, other.Clone()})
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res) != 1 || res[0] != other {
+		t.Errorf("expected only the unseen agent kept, returned %d", len(res))
+	}
+}
+
+func TestIsland_NotComplete(t *testing.T) {
+	for _, is := range []*Island{
+		{Islands: 2, Population: 4},
+		{Islands: 2, Population: 1, Migrator: &FileDropMigrator{}},
+		{ID: 2, Islands: 2, Population: 4, Migrator: &FileDropMigrator{}},
+	} {
+		if _, err := is.Run(context.Background(), nil); err != ErrIslandIsNotComplete {
+			t.Errorf("expected %v, returned %v", ErrIslandIsNotComplete, err)
+		}
+	}
+}
+
+const islandDirEnv = "AGENT2_ISLAND_DIR"
+
+// runProcessIsland runs one of two islands migrating over dir
+func runProcessIsland(id int, dir string) (*IslandResult, error) {
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	is := &Island{ID: id, Islands: 2, Population: 4, Generations: 4, MigrationInterval: 2,
+		WaitMillis: 30_000, Migrator: &FileDropMigrator{Dir: dir, ID: id}}
+	return is.Run(context.Background(), pc)
+}
+
+func TestIsland_ProcessHelper(t *testing.T) {
+	dir := os.Getenv(islandDirEnv)
+	if dir == "" {
+		t.Skip("runs as the second process of TestIsland_Processes")
+	}
+	if _, err := runProcessIsland(1, dir); err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+}
+
+func TestIsland_Processes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a process")
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestIsland_ProcessHelper$")
+	cmd.Env = append(os.Environ(), islandDirEnv+"="+dir)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+
+	res, err := runProcessIsland(0, dir)
+	if werr := cmd.Wait(); werr != nil {
+		t.Fatalf("expected the helper process to pass, raised %v", werr)
+	}
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if res.Sent != 2 || res.Received != 2 {
+		t.Errorf("expected 2 agents sent and received, returned %d %d", res.Sent, res.Received)
+	}
+}
@@ -0,0 +1,271 @@
+package agent2
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// Binance bulk kline archives (data.binance.vision) are csv files named
+// SYMBOL-INTERVAL-YYYY-MM(-DD).csv, zipped one per file. Columns are
+// open time, open, high, low, close, volume, close time, quote volume,
+// number of trades, taker buy volume, taker buy quote volume, ignore.
+// Newer spot archives store times in microseconds
+
+const (
+	csvOpenTime = iota
+	csvOpen
+	csvHigh
+	csvLow
+	csvClose
+	csvVolume
+	csvCloseTime
+	csvQuoteVolume
+	csvNumberOfTrades
+	csvTakerBuyVolume
+	csvMinColumns
+)
+
+// timestamps above are in microseconds, 1e14 ms is year 5138
+const microsThreshold = 100_000_000_000_000
+
+// LoadOptions filters loaded klines, empty fields match everything.
+// Archives matched by an empty Symbol or Interval must still share them,
+// a single series is loaded. From and To are open times in milliseconds,
+// To is exclusive
+type LoadOptions struct {
+	Symbol   string
+	Interval string
+	From     int64
+	To       int64
+}
+
+var (
+	ErrCsvRowHasTooFewColumns = fmt.Errorf("kline csv row has too few columns")
+	ErrArchivesAreMixed       = fmt.Errorf("matched kline archives differ in symbol or interval")
+)
+
+func parseMillis(s string) (int64, error) {
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ts > microsThreshold {
+		ts /= 1_000
+	}
+	return ts, nil
+}
+
+func parseCsvKline(row []string) (klines.Kline, error) {
+	var kln klines.Kline
+	if len(row) < csvMinColumns {
+		return kln, ErrCsvRowHasTooFewColumns
+	}
+
+	var err error
+	if kln.OpenTime, err = parseMillis(row[csvOpenTime]); err != nil {
+		return kln, err
+	}
+	if kln.CloseTime, err = parseMillis(row[csvCloseTime]); err != nil {
+		return kln, err
+	}
+	if kln.NumberOfTrades, err = strconv.ParseInt(row[csvNumberOfTrades], 10, 64); err != nil {
+		return kln, err
+	}
+	for _, f := range []struct {
+		col int
+		dst *float64
+	}{
+		{csvOpen, &kln.Open},
+		{csvHigh, &kln.High},
+		{csvLow, &kln.Low},
+		{csvClose, &kln.Close},
+		{csvVolume, &kln.Volume},
+		{csvTakerBuyVolume, &kln.TakerBuyVolume},
+	} {
+		if *f.dst, err = strconv.ParseFloat(row[f.col], 64); err != nil {
+			return kln, err
+		}
+	}
+	kln.IsFinal = true
+	return kln, nil
+}
+
+// ReadKlinesCSV reads a binance kline csv, the header row is optional
+func ReadKlinesCSV(r io.Reader) ([]klines.Kline, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	var res []klines.Kline
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && len(row) > 0 {
+			if _, err := strconv.ParseInt(row[csvOpenTime], 10, 64); err != nil {
+				// header
+				continue
+			}
+		}
+
+		kln, err := parseCsvKline(row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		res = append(res, kln)
+	}
+}
+
+// ReadKlinesZip reads every csv file of a binance kline archive
+func ReadKlinesZip(path string) ([]klines.Kline, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var res []klines.Kline
+	for _, f := range zr.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".csv") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		klns, err := ReadKlinesCSV(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		res = append(res, klns...)
+	}
+	return res, nil
+}
+
+// archiveName is a parsed SYMBOL-INTERVAL-YYYY-MM(-DD) file name, from
+// and to are the covered open times in milliseconds
+type archiveName struct {
+	symbol   string
+	interval string
+	from     int64
+	to       int64
+}
+
+func parseArchiveName(name string) (archiveName, bool) {
+	var an archiveName
+
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	parts := strings.SplitN(base, "-", 3)
+	if len(parts) != 3 {
+		return an, false
+	}
+	an.symbol, an.interval = parts[0], parts[1]
+
+	if t, err := time.Parse("2006-01-02", parts[2]); err == nil {
+		an.from, an.to = t.UnixMilli(), t.AddDate(0, 0, 1).UnixMilli()
+		return an, true
+	}
+	if t, err := time.Parse("2006-01", parts[2]); err == nil {
+		an.from, an.to = t.UnixMilli(), t.AddDate(0, 1, 0).UnixMilli()
+		return an, true
+	}
+	return an, false
+}
+
+func (opts *LoadOptions) matchesArchive(an archiveName) bool {
+	if opts.Symbol != "" && !strings.EqualFold(opts.Symbol, an.symbol) {
+		return false
+	}
+	if opts.Interval != "" && opts.Interval != an.interval {
+		return false
+	}
+	if opts.To > 0 && an.from >= opts.To {
+		return false
+	}
+	return an.to > opts.From
+}
+
+func (opts *LoadOptions) matchesKline(kln *klines.Kline) bool {
+	return kln.OpenTime >= opts.From && (opts.To <= 0 || kln.OpenTime < opts.To)
+}
+
+// LoadKlines reads matching .zip and .csv archives under dir. Results are
+// sorted by open time, bars found in several files are kept once
+func LoadKlines(dir string, opts LoadOptions) ([]klines.Kline, error) {
+	var res []klines.Kline
+	var first *archiveName
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".zip" && ext != ".csv" {
+			return nil
+		}
+		an, ok := parseArchiveName(d.Name())
+		if !ok || !opts.matchesArchive(an) {
+			return nil
+		}
+		if first == nil {
+			first = &an
+		} else if !strings.EqualFold(first.symbol, an.symbol) || first.interval != an.interval {
+			return fmt.Errorf("%w: %s-%s and %s-%s", ErrArchivesAreMixed, first.symbol, first.interval, an.symbol, an.interval)
+		}
+
+		var klns []klines.Kline
+		if ext == ".zip" {
+			klns, err = ReadKlinesZip(path)
+		} else {
+			klns, err = readKlinesFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		for i := range klns {
+			if opts.matchesKline(&klns[i]) {
+				res = append(res, klns[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].OpenTime < res[j].OpenTime
+	})
+	uniq := res[:0]
+	for _, kln := range res {
+		if len(uniq) == 0 || uniq[len(uniq)-1].OpenTime != kln.OpenTime {
+			uniq = append(uniq, kln)
+		}
+	}
+	return uniq, nil
+}
+
+func readKlinesFile(path string) ([]klines.Kline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadKlinesCSV(f)
+}
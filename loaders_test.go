@@ -0,0 +1,128 @@
+package agent2
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	csvHeader = "open_time,open,high,low,close,volume,close_time,quote_volume,count,taker_buy_volume,taker_buy_quote_volume,ignore\n"
+	// 2024-01-31 23:59 and 2024-02-01 00:00
+	csvMillis = "1706745540000,1.5,2.5,1.0,2.0,100.0,1706745599999,150.0,12,60.0,90.0,0\n" +
+		"1706745600000,2.0,3.0,1.5,2.5,200.0,1706745659999,500.0,24,120.0,300.0,0\n"
+	csvMicros = "1706745600000000,2.0,3.0,1.5,2.5,200.0,1706745659999999,500.0,24,120.0,300.0,0\n" +
+		"1706745660000000,2.5,3.5,2.0,3.0,300.0,1706745719999999,900.0,36,180.0,540.0,0\n"
+)
+
+func writeZip(t *testing.T, path string, name string, content string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadKlinesCSV(t *testing.T) {
+	for _, content := range []string{csvMillis, csvHeader + csvMillis} {
+		klns, err := ReadKlinesCSV(strings.NewReader(content))
+		if err != nil {
+			t.Errorf("expected no error but raised %v", err)
+		}
+		if len(klns) != 2 {
+			t.Fatalf("expected 2 klines, returned %d", len(klns))
+		}
+
+		k := klns[1]
+		if k.OpenTime != 1706745600000 || k.CloseTime != 1706745659999 || k.Open != 2 || k.High != 3 ||
+			k.Low != 1.5 || k.Close != 2.5 || k.Volume != 200 || k.TakerBuyVolume != 120 || k.NumberOfTrades != 24 || !k.IsFinal {
+			t.Errorf("unexpected kline %+v", k)
+		}
+	}
+}
+
+func TestReadKlinesCSV_Micros(t *testing.T) {
+	klns, _ := ReadKlinesCSV(strings.NewReader(csvMicros))
+
+	if klns[0].OpenTime != 1706745600000 || klns[0].CloseTime != 1706745659999 {
+		t.Errorf("expected millisecond times, returned %d, %d", klns[0].OpenTime, klns[0].CloseTime)
+	}
+}
+
+func TestReadKlinesCSV_Invalid(t *testing.T) {
+	if _, err := ReadKlinesCSV(strings.NewReader("1,2,3\n")); err == nil {
+		t.Errorf("expected error but nothing raised")
+	}
+	if _, err := ReadKlinesCSV(strings.NewReader(csvMillis + "1,a,2,3,4,5,6,7,8,9,10,11\n")); err == nil {
+		t.Errorf("expected error but nothing raised")
+	}
+}
+
+func TestParseArchiveName(t *testing.T) {
+	an, ok := parseArchiveName("BTCUSDT-1m-2024-02.zip")
+	if !ok || an.symbol != "BTCUSDT" || an.interval != "1m" || an.from != 1706745600000 || an.to != 1709251200000 {
+		t.Errorf("unexpected archive name %+v", an)
+	}
+
+	an, ok = parseArchiveName("ETHUSDT-1h-2024-02-01.csv")
+	if !ok || an.to-an.from != 24*60*60*1000 {
+		t.Errorf("unexpected archive name %+v", an)
+	}
+
+	if _, ok := parseArchiveName("notes.csv"); ok {
+		t.Errorf("expected non archive name to be rejected")
+	}
+}
+
+func TestLoadKlines(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "BTCUSDT-1m-2024-01.zip"), "BTCUSDT-1m-2024-01.csv", csvMillis[:strings.Index(csvMillis, "\n")+1])
+	writeZip(t, filepath.Join(dir, "BTCUSDT-1m-2024-02.zip"), "BTCUSDT-1m-2024-02.csv", csvHeader+csvMicros)
+	os.WriteFile(filepath.Join(dir, "BTCUSDT-1m-2024-02-01.csv"), []byte(csvMillis), 0o644)
+	writeZip(t, filepath.Join(dir, "ETHUSDT-1m-2024-02.zip"), "ETHUSDT-1m-2024-02.csv", csvMillis)
+
+	klns, err := LoadKlines(dir, LoadOptions{Symbol: "BTCUSDT", Interval: "1m"})
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	// the daily csv repeats bars of both months
+	if len(klns) != 3 {
+		t.Fatalf("expected 3 klines, returned %d", len(klns))
+	}
+	for i := 1; i < len(klns); i++ {
+		if klns[i].OpenTime <= klns[i-1].OpenTime {
+			t.Errorf("expected sorted unique klines")
+		}
+	}
+
+	klns, _ = LoadKlines(dir, LoadOptions{Symbol: "BTCUSDT", From: 1706745600000, To: 1706745660000})
+	if len(klns) != 1 || klns[0].OpenTime != 1706745600000 {
+		t.Errorf("expected single kline in range, returned %v", klns)
+	}
+
+	if klns, _ := LoadKlines(dir, LoadOptions{Interval: "1h"}); len(klns) != 0 {
+		t.Errorf("expected no klines for other interval, returned %d", len(klns))
+	}
+
+	// both symbols match, they aren't merged into one series
+	if _, err := LoadKlines(dir, LoadOptions{Interval: "1m"}); !errors.Is(err, ErrArchivesAreMixed) {
+		t.Errorf("expected %v, returned %v", ErrArchivesAreMixed, err)
+	}
+	writeZip(t, filepath.Join(dir, "BTCUSDT-5m-2024-02.zip"), "BTCUSDT-5m-2024-02.csv", csvMillis)
+	if _, err := LoadKlines(dir, LoadOptions{Symbol: "BTCUSDT"}); !errors.Is(err, ErrArchivesAreMixed) {
+		t.Errorf("expected %v, returned %v", ErrArchivesAreMixed, err)
+	}
+}
@@ -0,0 +1,153 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Move returns a neighbor of an agent, leaving the agent untouched
+type Move func(ag *Agent) *Agent
+
+// StepMove moves a random param by 1 to maxSteps grid steps inside its
+// bounds, integer params stay integers
+func StepMove(maxSteps int) Move {
+	return func(ag *Agent) *Agent {
+		c := ag.Clone()
+		params := c.Params()
+		p := params[rand.Intn(len(params))]
+
+		shift := float64(rand.Intn(max(1, maxSteps))+1) * p.Grid
+		if rand.Intn(2) == 0 {
+			shift = -shift
+		}
+		v := roundTo4d(p.Value + shift)
+		if v < p.Min || v > p.Max {
+			// moves past a bound go the other way
+			v = roundTo4d(p.Value - shift)
+		}
+		p.Set(math.Max(p.Min, math.Min(p.Max, v)))
+		c.sortIndicators()
+		return c
+	}
+}
+
+// MutateMove changes an indicator, the rule or a param, see Agent.Mutate
+func MutateMove(ag *Agent) *Agent {
+	c := ag.Clone()
+	c.Mutate()
+	return c
+}
+
+// Schedule is the annealing temperature at iteration i of n
+type Schedule func(i int, n int) float64
+
+// ExponentialSchedule cools from t0 by alpha every iteration
+func ExponentialSchedule(t0 float64, alpha float64) Schedule {
+	return func(i int, _ int) float64 {
+		return t0 * math.Pow(alpha, float64(i))
+	}
+}
+
+// LinearSchedule cools from t0 to 0 over the iterations
+func LinearSchedule(t0 float64) Schedule {
+	return func(i int, n int) float64 {
+		return t0 * (1 - float64(i)/float64(n))
+	}
+}
+
+// LocalSearch polishes an agent by evaluating Neighbors (defaults to 1)
+// moves of the current agent per iteration and moving to the best of
+// them. Without a Schedule it hill climbs, taking strict improvements
+// only. With a Schedule it anneals, taking a worse neighbor with
+// probability exp(delta / temperature) in fitness units
+type LocalSearch struct {
+	Iterations int
+	Neighbors  int
+	// Move defaults to StepMove(1)
+	Move Move
+	// Fitness defaults to ProfitPerDayFitness
+	Fitness   Fitness
+	Schedule  Schedule
+	Evaluator *Evaluator
+}
+
+// LocalSearchResult holds the best agent seen, Accepted counts the moves
+// taken
+type LocalSearchResult struct {
+	Agent    *Agent  `json:"agent"`
+	Bucket   *Bucket `json:"bucket"`
+	Fitness  float64 `json:"fitness"`
+	Accepted int     `json:"accepted"`
+}
+
+var (
+	ErrIterationsAreBelowOne = fmt.Errorf("local search needs at least 1 iteration")
+)
+
+func (ls *LocalSearch) Run(ctx context.Context, ag *Agent, pc *PairColumns) (*LocalSearchResult, error) {
+	if ls.Iterations < 1 {
+		return nil, ErrIterationsAreBelowOne
+	}
+	neighbors := max(1, ls.Neighbors)
+	move := ls.Move
+	if move == nil {
+		move = StepMove(1)
+	}
+	fitness := ls.Fitness
+	if fitness == nil {
+		fitness = ProfitPerDayFitness
+	}
+	ev := ls.Evaluator
+	if ev == nil {
+		ev = &Evaluator{}
+	}
+
+	buckets, err := ev.Evaluate(ctx, []*Agent{ag}, pc)
+	if err != nil {
+		return nil, err
+	}
+	cur, curFit := ag, fitness(buckets[0])
+	res := &LocalSearchResult{Agent: ag.Clone(), Bucket: buckets[0], Fitness: curFit}
+
+	cands := make([]*Agent, neighbors)
+	for it := 0; it < ls.Iterations; it++ {
+		for i := range cands {
+			cands[i] = move(cur)
+		}
+		buckets, err := ev.Evaluate(ctx, cands, pc)
+		if err != nil {
+			return nil, err
+		}
+
+		best, bestFit := -1, math.Inf(-1)
+		for i, bu := range buckets {
+			if fit := fitness(bu); !bu.Pruned && fit > bestFit {
+				best, bestFit = i, fit
+			}
+		}
+		if best < 0 || !ls.accept(bestFit-curFit, it) {
+			continue
+		}
+
+		cur, curFit = cands[best], bestFit
+		res.Accepted++
+		if curFit > res.Fitness {
+			res.Agent, res.Bucket, res.Fitness = cur, buckets[best], curFit
+		}
+	}
+	return res, nil
+}
+
+func (ls *LocalSearch) accept(delta float64, it int) bool {
+	if delta > 0 {
+		return true
+	}
+	if ls.Schedule == nil {
+		return false
+	}
+
+	temp := ls.Schedule(it, ls.Iterations)
+	return temp > 0 && rand.Float64() < math.Exp(delta/temp)
+}
@@ -0,0 +1,97 @@
+package agent2
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestStepMove(t *testing.T) {
+	rand.Seed(107)
+	move := StepMove(3)
+
+	for i := 0; i < 200; i++ {
+		ag := robustnessAgent()
+		orig, _ := ag.Marshal()
+		c := move(ag)
+		if after, _ := ag.Marshal(); string(after) != string(orig) {
+			t.Fatalf("expected source agent untouched")
+		}
+
+		changed := 0
+		before := ag.Params()
+		for j, p := range c.Params() {
+			if p.Value < p.Min || p.Value > p.Max {
+				t.Errorf("param %s %f is out of [%f, %f]", p.Name, p.Value, p.Min, p.Max)
+			}
+			if p.Value == before[j].Value {
+				continue
+			}
+			changed++
+			steps := math.Abs(p.Value-before[j].Value) / p.Grid
+			if math.Abs(steps-math.Round(steps)) > 1e-6 || math.Round(steps) > 3 {
+				t.Errorf("param %s moved %f grid steps", p.Name, steps)
+			}
+		}
+		if changed != 1 {
+			t.Errorf("expected a single param moved, moved %d", changed)
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	if temp := ExponentialSchedule(2, 0.5)(3, 10); temp != 0.25 {
+		t.Errorf("expected 0.25, returned %f", temp)
+	}
+	if temp := LinearSchedule(2)(5, 10); temp != 1 {
+		t.Errorf("expected 1, returned %f", temp)
+	}
+}
+
+func TestLocalSearch_HillClimbing(t *testing.T) {
+	rand.Seed(109)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	ag := robustnessAgent()
+	bu, _ := ag.Backtest(pc)
+
+	res, err := (&LocalSearch{Iterations: 8, Neighbors: 3}).Run(context.Background(), ag, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if res.Fitness < bu.ProfitPerDay() {
+		t.Errorf("expected fitness not below %f, returned %f", bu.ProfitPerDay(), res.Fitness)
+	}
+	rbu, _ := res.Agent.Backtest(pc)
+	if rbu.ProfitPerDay() != res.Fitness || !tradesEqual(rbu.Trades, res.Bucket.Trades) {
+		t.Errorf("expected the bucket of the returned agent")
+	}
+
+	// nothing is a strict improvement on a flat fitness
+	flat := &LocalSearch{Iterations: 4, Fitness: func(bu *Bucket) float64 { return 0 }}
+	if res, _ := flat.Run(context.Background(), ag, pc); res.Accepted != 0 {
+		t.Errorf("expected no accepted moves, accepted %d", res.Accepted)
+	}
+}
+
+func TestLocalSearch_Annealing(t *testing.T) {
+	rand.Seed(113)
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+
+	// a hot schedule takes every move
+	ls := &LocalSearch{Iterations: 5, Move: MutateMove, Schedule: LinearSchedule(1e9),
+		Fitness: func(bu *Bucket) float64 { return -float64(len(bu.Trades)) }}
+	res, err := ls.Run(context.Background(), robustnessAgent(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if res.Accepted != 5 {
+		t.Errorf("expected 5 accepted moves, accepted %d", res.Accepted)
+	}
+}
+
+func TestLocalSearch_NoIterations(t *testing.T) {
+	if _, err := (&LocalSearch{}).Run(context.Background(), robustnessAgent(), nil); err != ErrIterationsAreBelowOne {
+		t.Errorf("expected %v, returned %v", ErrIterationsAreBelowOne, err)
+	}
+}
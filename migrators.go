@@ -0,0 +1,267 @@
+package agent2
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSendMillis   = 5_000
+	dialRetryMillis     = 50
+	maxMigrationPayload = 64 << 20
+)
+
+// FileDropMigrator drops a file per migration in the directory of the
+// target island under Dir, as Dir/2/000010-0-<nanos>.json. Files are
+// written aside and renamed in, so readers never see partial files, and
+// removed once received, malformed ones are removed and counted by
+// Dropped. Dir can be shared by processes on a host or mounted over the
+// network
+type FileDropMigrator struct {
+	Dir string
+	ID  int
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func (fd *FileDropMigrator) Send(ctx context.Context, to int, mg *Migration) error {
+	pload, err := mg.Marshal()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(fd.Dir, strconv.Itoa(to))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%06d-%d-%d.json", mg.Gen, mg.From, time.Now().UnixNano())
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, pload, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+func (fd *FileDropMigrator) Receive(ctx context.Context) ([]*Migration, error) {
+	dir := filepath.Join(fd.Dir, strconv.Itoa(fd.ID))
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, en := range entries {
+		if !en.IsDir() && !strings.HasPrefix(en.Name(), ".") && strings.HasSuffix(en.Name(), ".json") {
+			names = append(names, en.Name())
+		}
+	}
+	sort.Strings(names)
+
+	res := make([]*Migration, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		pload, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		mg, err := UnmarshalMigration(pload)
+		if err != nil {
+			fd.mu.Lock()
+			fd.dropped++
+			fd.mu.Unlock()
+			continue
+		}
+		res = append(res, mg)
+	}
+	return res, nil
+}
+
+// Dropped is the number of malformed migrations received
+func (fd *FileDropMigrator) Dropped() int {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	return fd.dropped
+}
+
+// TCPMigrator listens for migrations and sends them to Peers, the
+// address of every island by id. A migration is a line of json on its
+// own connection. A send has SendMillis (defaults to 5s) to dial and
+// write, dialing is retried so islands can start in any order, and a
+// peer that stops reading can't block the sender. Malformed migrations
+// are counted by Dropped
+type TCPMigrator struct {
+	Peers      []string
+	SendMillis int64
+
+	ln      net.Listener
+	mu      sync.Mutex
+	inbox   []*Migration
+	dropped int
+	conns   map[net.Conn]bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+var (
+	ErrPeerIsNotDefined = fmt.Errorf("tcp migrator has no address for island")
+)
+
+// NewTCPMigrator listens on addr, a port of 0 picks a free one, see Addr
+func NewTCPMigrator(addr string, peers []string) (*TCPMigrator, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tm := &TCPMigrator{Peers: peers, ln: ln, conns: make(map[net.Conn]bool)}
+
+	tm.wg.Add(1)
+	go tm.accept()
+	return tm, nil
+}
+
+func (tm *TCPMigrator) Addr() string {
+	return tm.ln.Addr().String()
+}
+
+// Close stops listening and closes open connections, so idle peers
+// can't hold it. Migrations already received stay receivable
+func (tm *TCPMigrator) Close() error {
+	err := tm.ln.Close()
+
+	tm.mu.Lock()
+	tm.closed = true
+	for conn := range tm.conns {
+		conn.Close()
+	}
+	tm.mu.Unlock()
+
+	tm.wg.Wait()
+	return err
+}
+
+func (tm *TCPMigrator) accept() {
+	defer tm.wg.Done()
+	for {
+		conn, err := tm.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		tm.mu.Lock()
+		if tm.closed {
+			tm.mu.Unlock()
+			conn.Close()
+			return
+		}
+		tm.conns[conn] = true
+		tm.wg.Add(1)
+		tm.mu.Unlock()
+		go tm.read(conn)
+	}
+}
+
+func (tm *TCPMigrator) read(conn net.Conn) {
+	defer tm.wg.Done()
+	defer func() {
+		tm.mu.Lock()
+		delete(tm.conns, conn)
+		tm.mu.Unlock()
+		conn.Close()
+	}()
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, 64<<10), maxMigrationPayload)
+	for sc.Scan() {
+		mg, err := UnmarshalMigration(sc.Bytes())
+		tm.mu.Lock()
+		if err != nil {
+			tm.dropped++
+		} else {
+			tm.inbox = append(tm.inbox, mg)
+		}
+		tm.mu.Unlock()
+	}
+	// a broken connection loses its partial line, only an oversized one
+	// is a malformed migration
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		tm.mu.Lock()
+		tm.dropped++
+		tm.mu.Unlock()
+	}
+}
+
+func (tm *TCPMigrator) Send(ctx context.Context, to int, mg *Migration) error {
+	if to < 0 || to >= len(tm.Peers) {
+		return ErrPeerIsNotDefined
+	}
+	pload, err := mg.Marshal()
+	if err != nil {
+		return err
+	}
+
+	sendMillis := tm.SendMillis
+	if sendMillis <= 0 {
+		sendMillis = defaultSendMillis
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(sendMillis)*time.Millisecond)
+	defer cancel()
+
+	var dialer net.Dialer
+	retry := time.NewTicker(dialRetryMillis * time.Millisecond)
+	defer retry.Stop()
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", tm.Peers[to])
+		if err == nil {
+			defer conn.Close()
+			if deadline, ok := ctx.Deadline(); ok {
+				if err := conn.SetDeadline(deadline); err != nil {
+					return err
+				}
+			}
+			_, err = conn.Write(append(pload, '\n'))
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-retry.C:
+		}
+	}
+}
+
+// Receive drains the migrations received so far
+func (tm *TCPMigrator) Receive(ctx context.Context) ([]*Migration, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	res := tm.inbox
+	tm.inbox = nil
+	return res, nil
+}
+
+// Dropped is the number of malformed migrations received
+func (tm *TCPMigrator) Dropped() int {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	return tm.dropped
+}
@@ -0,0 +1,198 @@
+package agent2
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDropMigrator(t *testing.T) {
+	dir := t.TempDir()
+	from, to := &FileDropMigrator{Dir: dir, ID: 0}, &FileDropMigrator{Dir: dir, ID: 1}
+
+	// nothing was dropped yet
+	if mgs, err := to.Receive(context.Background()); err != nil || len(mgs) != 0 {
+		t.Fatalf("expected no migrations, returned %d %v", len(mgs), err)
+	}
+
+	for gen := 2; gen <= 4; gen += 2 {
+		if err := from.Send(context.Background(), 1, &Migration{From: 0, Gen: gen, Agents: []*Agent{robustnessAgent()}}); err != nil {
+			t.Fatalf("expected no error but raised %v", err)
+		}
+	}
+	// files being written are skipped
+	os.WriteFile(filepath.Join(dir, "1", ".000006-0-1.json"), []byte("{"), 0o644)
+
+	mgs, err := to.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(mgs) != 2 || mgs[0].Gen != 2 || mgs[1].Gen != 4 || len(mgs[0].Agents) != 1 {
+		t.Fatalf("expected migrations of gen 2 and 4, returned %d", len(mgs))
+	}
+	if mgs, _ := to.Receive(context.Background()); len(mgs) != 0 {
+		t.Errorf("expected received migrations removed, returned %d", len(mgs))
+	}
+	if mgs, _ := from.Receive(context.Background()); len(mgs) != 0 {
+		t.Errorf("expected no migrations for the sender, returned %d", len(mgs))
+	}
+}
+
+func TestTCPMigrator(t *testing.T) {
+	tm0, err := NewTCPMigrator("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	defer tm0.Close()
+	tm1, err := NewTCPMigrator("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	defer tm1.Close()
+	peers := []string{tm0.Addr(), tm1.Addr()}
+	tm0.Peers, tm1.Peers = peers, peers
+
+	mg := &Migration{From: 0, Gen: 5, Agents: []*Agent{robustnessAgent(), robustnessAgent()}}
+	if err := tm0.Send(context.Background(), 1, mg); err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if err := tm0.Send(context.Background(), 2, mg); err != ErrPeerIsNotDefined {
+		t.Errorf("expected %v, returned %v", ErrPeerIsNotDefined, err)
+	}
+
+	// the payload is read after the sender closes, wait for it
+	is := &Island{ID: 1, Islands: 2, WaitMillis: 5_000, Migrator: tm1}
+	_, migrants, err := is.migrate(context.Background(), &island{agents: []*Agent{RandomAgent()}}, 5)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(migrants) != 2 {
+		t.Fatalf("expected 2 migrants, returned %d", len(migrants))
+	}
+	want, _ := robustnessAgent().Marshal()
+	if got, _ := migrants[0].Marshal(); string(got) != string(want) {
+		t.Errorf("expected agent %s, returned %s", want, got)
+	}
+
+	// the reply is read in the background, wait for it
+	var back []*Migration
+	for deadline := time.Now().Add(5 * time.Second); len(back) == 0 && time.Now().Before(deadline); {
+		back, _ = tm0.Receive(context.Background())
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(back) != 1 || back[0].From != 1 {
+		t.Errorf("expected the migration island 1 sent back")
+	}
+}
+
+func TestTCPMigrator_DialRetry(t *testing.T) {
+	ln, _ := NewTCPMigrator("127.0.0.1:0", nil)
+	addr := ln.Addr()
+	ln.Close()
+
+	tm := &TCPMigrator{Peers: []string{addr}, SendMillis: 100}
+	if err := tm.Send(context.Background(), 0, &Migration{}); err == nil {
+		t.Errorf("expected an error dialing a closed peer")
+	}
+}
+
+func TestTCPMigrator_WriteDeadline(t *testing.T) {
+	// a peer that accepts and never reads
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			<-done
+		}
+	}()
+
+	mg := &Migration{Agents: make([]*Agent, 50_000)}
+	for i := range mg.Agents {
+		mg.Agents[i] = robustnessAgent()
+	}
+	tm := &TCPMigrator{Peers: []string{ln.Addr().String()}, SendMillis: 200}
+	if err := tm.Send(context.Background(), 0, mg); err == nil {
+		t.Errorf("expected a timeout writing to a stalled peer")
+	}
+}
+
+func TestFileDropMigrator_Malformed(t *testing.T) {
+	dir := t.TempDir()
+	from, to := &FileDropMigrator{Dir: dir, ID: 0}, &FileDropMigrator{Dir: dir, ID: 1}
+
+	from.Send(context.Background(), 1, &Migration{From: 0, Gen: 2, Agents: []*Agent{robustnessAgent()}})
+	os.WriteFile(filepath.Join(dir, "1", "000001-0-1.json"), []byte("{"), 0o644)
+
+	mgs, err := to.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(mgs) != 1 || mgs[0].Gen != 2 || to.Dropped() != 1 {
+		t.Fatalf("expected the valid migration and 1 dropped, returned %d and %d", len(mgs), to.Dropped())
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "1")); len(entries) != 0 {
+		t.Errorf("expected the malformed file removed, returned %d files", len(entries))
+	}
+}
+
+func TestTCPMigrator_Malformed(t *testing.T) {
+	sink, _ := NewTCPMigrator("127.0.0.1:0", nil)
+	defer sink.Close()
+	tm, err := NewTCPMigrator("127.0.0.1:0", []string{sink.Addr()})
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	defer tm.Close()
+
+	conn, err := net.Dial("tcp", tm.Addr())
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	pload, _ := (&Migration{From: 0, Gen: 5, Agents: []*Agent{robustnessAgent()}}).Marshal()
+	conn.Write(append([]byte("{\n"), append(pload, '\n')...))
+	conn.Close()
+
+	// a malformed migration doesn't stop the island
+	is := &Island{ID: 1, Islands: 2, WaitMillis: 5_000, Migrator: tm}
+	_, migrants, err := is.migrate(context.Background(), &island{agents: []*Agent{RandomAgent()}}, 5)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(migrants) != 1 || tm.Dropped() != 1 {
+		t.Errorf("expected 1 migrant and 1 dropped, returned %d and %d", len(migrants), tm.Dropped())
+	}
+}
+
+func TestTCPMigrator_CloseWithIdlePeer(t *testing.T) {
+	tm, err := NewTCPMigrator("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	conn, err := net.Dial("tcp", tm.Addr())
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("{"))
+
+	closed := make(chan struct{})
+	go func() {
+		tm.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected close not to wait for an idle peer")
+	}
+}
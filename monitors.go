@@ -1,6 +1,10 @@
 package agent2
 
-import "math/rand"
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
 
 type Monitor uint8
 
@@ -20,16 +24,41 @@ const (
 	Not1
 	Not2
 	NotR
+	// values below are appended to keep marshalled agents stable
+	Return1 // close to close return, first bar of a window uses its open
+	Return2
+	ReturnR   // Return1 - Return2
+	LogCloseR // log(Close1 / Close2)
+	Spread    // Close1 - beta*Close2, beta is the ols hedge ratio of the window
+	Body1     // |Close - Open| / Open
+	Body2
+	BodyR
+	QuoteVol1 // Volume * typical price, klines do not carry quote volume
+	QuoteVol2
+	QuoteVolR
 )
 
+// windowDependent monitors can't be computed bar by bar, a bar's value
+// depends on the window it is in
+func (mon Monitor) windowDependent() bool {
+	switch mon {
+	case Return1, Return2, ReturnR, Spread:
+		return true
+	}
+	return false
+}
+
 var (
 	primaryMons = []Monitor{
 		CloseR, VolumeR, TBVolOVolR, NotR,
+		ReturnR, LogCloseR, Spread,
 	}
 	secondaryMons = []Monitor{
 		Close1, Close2, HighMLow1, HighMLow2,
 		HighMLowR, Volume1, Volume2, TBVolOVol1,
-		TBVolOVol2, Not1, Not2,
+		TBVolOVol2, Not1, Not2, Return1,
+		Return2, Body1, Body2, BodyR,
+		QuoteVol1, QuoteVol2, QuoteVolR,
 	}
 )
 
@@ -37,16 +66,104 @@ const (
 	secondaryMonProb = 25
 )
 
+// MonitorSelection configures how random indicators pick monitors.
+// SecondaryProb is the percentage of draws from secondary monitors,
+// Weights are relative inside a group, missing monitors weigh 1
+type MonitorSelection struct {
+	SecondaryProb int             `json:"secondary_prob"`
+	Weights       map[Monitor]int `json:"weights"`
+}
+
+var (
+	ErrSecondaryProbIsOutOfRange = fmt.Errorf("secondary prob should be in [0, 100]")
+	ErrMonitorWeightIsNegative   = fmt.Errorf("monitor weight can't be negative")
+	ErrMonitorGroupHasNoWeight   = fmt.Errorf("monitor group with non zero prob has no weight")
+)
+
+var (
+	monSelMu sync.RWMutex
+	monSel   = MonitorSelection{SecondaryProb: secondaryMonProb}
+)
+
+func groupWeight(mons []Monitor, weights map[Monitor]int) int {
+	total := 0
+	for _, mon := range mons {
+		w, ok := weights[mon]
+		if !ok {
+			w = 1
+		}
+		total += w
+	}
+	return total
+}
+
+func SetMonitorSelection(sel MonitorSelection) error {
+	if sel.SecondaryProb < 0 || sel.SecondaryProb > 100 {
+		return ErrSecondaryProbIsOutOfRange
+	}
+	weights := make(map[Monitor]int, len(sel.Weights))
+	for mon, w := range sel.Weights {
+		if w < 0 {
+			return ErrMonitorWeightIsNegative
+		}
+		weights[mon] = w
+	}
+	if sel.SecondaryProb < 100 && groupWeight(primaryMons, weights) == 0 {
+		return ErrMonitorGroupHasNoWeight
+	}
+	if sel.SecondaryProb > 0 && groupWeight(secondaryMons, weights) == 0 {
+		return ErrMonitorGroupHasNoWeight
+	}
+
+	monSelMu.Lock()
+	defer monSelMu.Unlock()
+
+	monSel = MonitorSelection{SecondaryProb: sel.SecondaryProb, Weights: weights}
+	return nil
+}
+
+func ResetMonitorSelection() {
+	monSelMu.Lock()
+	defer monSelMu.Unlock()
+
+	monSel = MonitorSelection{SecondaryProb: secondaryMonProb}
+}
+
+func randWeightedMon(mons []Monitor, weights map[Monitor]int) Monitor {
+	r := rand.Intn(groupWeight(mons, weights))
+	for _, mon := range mons {
+		w, ok := weights[mon]
+		if !ok {
+			w = 1
+		}
+		if r < w {
+			return mon
+		}
+		r -= w
+	}
+	return mons[len(mons)-1]
+}
+
 func randPrimaryMon() Monitor {
-	return primaryMons[rand.Intn(len(primaryMons))]
+	monSelMu.RLock()
+	defer monSelMu.RUnlock()
+
+	return randWeightedMon(primaryMons, monSel.Weights)
 }
 
 func randSecondaryMon() Monitor {
-	return secondaryMons[rand.Intn(len(secondaryMons))]
+	monSelMu.RLock()
+	defer monSelMu.RUnlock()
+
+	return randWeightedMon(secondaryMons, monSel.Weights)
 }
 
 func randMon() Monitor {
-	if rand.Intn(100) < secondaryMonProb {
+	monSelMu.RLock()
+	secondaryProb := monSel.SecondaryProb
+	monSelMu.RUnlock()
+
+	if rand.Intn(100) < secondaryProb {
 		return randSecondaryMon()
 	}
 	return randPrimaryMon()
@@ -71,3 +71,82 @@ func TestRandMonSingleCall(t *testing.T) {
 		t.Errorf("expected %v, received %v", expected, mon)
 	}
 }
+
+func TestMonitorValuesAreStable(t *testing.T) {
+	// monitors are marshalled as numbers, values must not move
+	expected := map[Monitor]uint8{
+		Close1: 0, CloseR: 2, VolumeR: 8, TBVolOVolR: 11, NotR: 14,
+		Return1: 15, LogCloseR: 18, Spread: 19, QuoteVolR: 25,
+	}
+	for mon, val := range expected {
+		if uint8(mon) != val {
+			t.Errorf("monitor %d is expected to be %d", mon, val)
+		}
+	}
+}
+
+func TestMonitorGroupsCoverAllMonitors(t *testing.T) {
+	seen := make(map[Monitor]int)
+	for _, mon := range append(append([]Monitor{}, primaryMons...), secondaryMons...) {
+		seen[mon]++
+	}
+	for mon := Close1; mon <= QuoteVolR; mon++ {
+		if seen[mon] != 1 {
+			t.Errorf("monitor %d is in %d groups", mon, seen[mon])
+		}
+	}
+}
+
+func TestSetMonitorSelection_Invalid(t *testing.T) {
+	defer ResetMonitorSelection()
+
+	if err := SetMonitorSelection(MonitorSelection{SecondaryProb: 101}); err != ErrSecondaryProbIsOutOfRange {
+		t.Errorf("expected %v, received %v", ErrSecondaryProbIsOutOfRange, err)
+	}
+	if err := SetMonitorSelection(MonitorSelection{Weights: map[Monitor]int{CloseR: -1}}); err != ErrMonitorWeightIsNegative {
+		t.Errorf("expected %v, received %v", ErrMonitorWeightIsNegative, err)
+	}
+
+	weights := make(map[Monitor]int)
+	for _, mon := range primaryMons {
+		weights[mon] = 0
+	}
+	if err := SetMonitorSelection(MonitorSelection{Weights: weights}); err != ErrMonitorGroupHasNoWeight {
+		t.Errorf("expected %v, received %v", ErrMonitorGroupHasNoWeight, err)
+	}
+}
+
+func TestSetMonitorSelection_OnlySpread(t *testing.T) {
+	defer ResetMonitorSelection()
+
+	weights := make(map[Monitor]int)
+	for _, mon := range primaryMons {
+		weights[mon] = 0
+	}
+	weights[Spread] = 1
+
+	if err := SetMonitorSelection(MonitorSelection{SecondaryProb: 0, Weights: weights}); err != nil {
+		t.Errorf("expected no error, received %v", err)
+	}
+	for i := 0; i < 1_000; i++ {
+		if mon := randMon(); mon != Spread {
+			t.Errorf("expected %v, received %v", Spread, mon)
+		}
+	}
+}
+
+func TestSetMonitorSelection_OnlySecondary(t *testing.T) {
+	defer ResetMonitorSelection()
+
+	if err := SetMonitorSelection(MonitorSelection{SecondaryProb: 100}); err != nil {
+		t.Errorf("expected no error, received %v", err)
+	}
+	for i := 0; i < 1_000; i++ {
+		mon := randMon()
+		for _, pm := range primaryMons {
+			if mon == pm {
+				t.Errorf("primary monitor %v drawn with secondary prob 100", mon)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package agent2
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Resampling draws a new trade sequence of the same length from the
+// trades of a bucket. Bootstrap draws trades with replacement,
+// Permutation shuffles them so the final pnl stays the same, and
+// BlockBootstrap is the stationary block bootstrap, drawing blocks of
+// consecutive trades with geometric lengths to keep autocorrelation
+type Resampling uint8
+
+const (
+	Bootstrap Resampling = iota
+	Permutation
+	BlockBootstrap
+)
+
+const (
+	defaultMonteCarloRuns = 1_000
+)
+
+// MonteCarlo resamples trades Runs times, defaulting to 1000 runs.
+// MeanBlockLen of the block bootstrap defaults to the cube root of the
+// trade count
+type MonteCarlo struct {
+	Runs         int
+	Resampling   Resampling
+	MeanBlockLen float64
+}
+
+// Distribution holds sorted values of a statistic over the runs
+type Distribution []float64
+
+// Percentile interpolates linearly between the closest values, p is
+// between 0 and 100
+func (d Distribution) Percentile(p float64) float64 {
+	if len(d) == 0 {
+		return math.NaN()
+	}
+
+	pos := math.Max(0, math.Min(1, p/100)) * float64(len(d)-1)
+	lo := int(pos)
+	if lo == len(d)-1 {
+		return d[lo]
+	}
+	return d[lo] + (pos-float64(lo))*(d[lo+1]-d[lo])
+}
+
+type MonteCarloResult struct {
+	FinalPnL     Distribution `json:"final_pnl"`
+	MaxDrawdown  Distribution `json:"max_drawdown"`
+	LosingStreak Distribution `json:"losing_streak"`
+}
+
+// MonteCarloPercentile is a row of a percentile report
+type MonteCarloPercentile struct {
+	Percentile   float64 `json:"percentile"`
+	FinalPnL     float64 `json:"final_pnl"`
+	MaxDrawdown  float64 `json:"max_drawdown"`
+	LosingStreak float64 `json:"losing_streak"`
+}
+
+func (res *MonteCarloResult) Report(percentiles ...float64) []MonteCarloPercentile {
+	rows := make([]MonteCarloPercentile, len(percentiles))
+	for i, p := range percentiles {
+		rows[i] = MonteCarloPercentile{
+			Percentile:   p,
+			FinalPnL:     res.FinalPnL.Percentile(p),
+			MaxDrawdown:  res.MaxDrawdown.Percentile(p),
+			LosingStreak: res.LosingStreak.Percentile(p),
+		}
+	}
+	return rows
+}
+
+var (
+	ErrBucketHasNoTrades      = fmt.Errorf("bucket has no trades to resample")
+	ErrBlockLenIsBelowOne     = fmt.Errorf("mean block len can't be below 1")
+	ErrResamplingIsNotDefined = fmt.Errorf("resampling is not defined")
+)
+
+func (mc *MonteCarlo) Run(bu *Bucket) (*MonteCarloResult, error) {
+	n := len(bu.Trades)
+	if n == 0 {
+		return nil, ErrBucketHasNoTrades
+	}
+	if mc.Resampling > BlockBootstrap {
+		return nil, ErrResamplingIsNotDefined
+	}
+	blockLen := mc.MeanBlockLen
+	if blockLen == 0 {
+		blockLen = math.Max(1, math.Cbrt(float64(n)))
+	}
+	if blockLen < 1 {
+		return nil, ErrBlockLenIsBelowOne
+	}
+	runs := mc.Runs
+	if runs <= 0 {
+		runs = defaultMonteCarloRuns
+	}
+
+	profits := make([]float64, n)
+	for i, tr := range bu.Trades {
+		profits[i] = tr.NetProfit
+	}
+
+	res := &MonteCarloResult{
+		FinalPnL:     make(Distribution, runs),
+		MaxDrawdown:  make(Distribution, runs),
+		LosingStreak: make(Distribution, runs),
+	}
+	sample := make([]float64, n)
+	for r := 0; r < runs; r++ {
+		mc.resample(profits, sample, blockLen)
+
+		for _, p := range sample {
+			res.FinalPnL[r] += p
+		}
+		res.FinalPnL[r] = roundTo4d(res.FinalPnL[r])
+		res.MaxDrawdown[r] = maxDrawdown(sample)
+		res.LosingStreak[r] = float64(losingStreak(sample))
+	}
+
+	sort.Float64s(res.FinalPnL)
+	sort.Float64s(res.MaxDrawdown)
+	sort.Float64s(res.LosingStreak)
+	return res, nil
+}
+
+func (mc *MonteCarlo) resample(profits []float64, sample []float64, blockLen float64) {
+	n := len(profits)
+
+	switch mc.Resampling {
+	case Bootstrap:
+		for i := range sample {
+			sample[i] = profits[rand.Intn(n)]
+		}
+	case Permutation:
+		for i, j := range rand.Perm(n) {
+			sample[i] = profits[j]
+		}
+	case BlockBootstrap:
+		// a new block starts with probability 1/blockLen, blocks wrap
+		// around the end of the trades
+		j := rand.Intn(n)
+		for i := range sample {
+			if i > 0 && rand.Float64() < 1/blockLen {
+				j = rand.Intn(n)
+			}
+			sample[i] = profits[j]
+			j = (j + 1) % n
+		}
+	}
+}
+
+// losingStreak is the longest run of losing trades
+func losingStreak(profits []float64) int {
+	res, cur := 0, 0
+	for _, p := range profits {
+		if p < 0 {
+			cur++
+			res = max(res, cur)
+		} else {
+			cur = 0
+		}
+	}
+	return res
+}
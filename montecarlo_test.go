@@ -0,0 +1,118 @@
+package agent2
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func profitBucket(profits ...float64) *Bucket {
+	bu, _ := NewBucket(0, 1)
+	for _, p := range profits {
+		bu.Trades = append(bu.Trades, &Trade{NetProfit: p})
+	}
+	return bu
+}
+
+func TestDistribution_Percentile(t *testing.T) {
+	d := Distribution{1, 2, 3, 4, 5}
+	for p, expected := range map[float64]float64{0: 1, 50: 3, 100: 5, 62.5: 3.5, -10: 1, 110: 5} {
+		if v := d.Percentile(p); v != expected {
+			t.Errorf("expected %f at %f, returned %f", expected, p, v)
+		}
+	}
+	if !math.IsNaN(Distribution{}.Percentile(50)) {
+		t.Errorf("expected NaN for an empty distribution")
+	}
+}
+
+func TestLosingStreak(t *testing.T) {
+	if s := losingStreak([]float64{-1, 2, -1, -1, 0, -3, -3, -3, 1}); s != 3 {
+		t.Errorf("expected 3, returned %d", s)
+	}
+	if s := losingStreak([]float64{1, 0}); s != 0 {
+		t.Errorf("expected 0, returned %d", s)
+	}
+}
+
+func TestMonteCarlo_Permutation(t *testing.T) {
+	rand.Seed(71)
+	bu := profitBucket(5, -2, 3, -4, -3, 10, -1)
+
+	res, err := (&MonteCarlo{Runs: 500, Resampling: Permutation}).Run(bu)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.FinalPnL) != 500 || res.FinalPnL[0] != 8 || res.FinalPnL[499] != 8 {
+		t.Errorf("expected final pnl of 8 for every permutation")
+	}
+	// all losses in a row is the worst order
+	if res.MaxDrawdown[499] != 10 || res.LosingStreak[499] != 4 {
+		t.Errorf("expected worst drawdown 10 and streak 4, returned %f and %f",
+			res.MaxDrawdown[499], res.LosingStreak[499])
+	}
+	if res.LosingStreak[0] != 1 {
+		t.Errorf("expected best streak 1, returned %f", res.LosingStreak[0])
+	}
+}
+
+func TestMonteCarlo_Bootstrap(t *testing.T) {
+	rand.Seed(73)
+	profits := make([]float64, 200)
+	total := 0.0
+	for i := range profits {
+		profits[i] = rand.NormFloat64() + 0.2
+		total += profits[i]
+	}
+
+	res, err := (&MonteCarlo{}).Run(profitBucket(profits...))
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.FinalPnL) != defaultMonteCarloRuns {
+		t.Errorf("expected %d runs, returned %d", defaultMonteCarloRuns, len(res.FinalPnL))
+	}
+	if med := res.FinalPnL.Percentile(50); math.Abs(med-total) > 15 {
+		t.Errorf("expected median final pnl around %f, returned %f", total, med)
+	}
+
+	rows := res.Report(5, 50, 95)
+	if len(rows) != 3 || rows[0].FinalPnL > rows[1].FinalPnL || rows[1].FinalPnL > rows[2].FinalPnL {
+		t.Errorf("expected ordered percentile rows, returned %v", rows)
+	}
+}
+
+func TestMonteCarlo_BlockBootstrapKeepsStreaks(t *testing.T) {
+	rand.Seed(79)
+	// losses cluster in runs of 10
+	profits := make([]float64, 200)
+	for i := range profits {
+		profits[i] = 1
+		if (i/10)%2 == 0 {
+			profits[i] = -1
+		}
+	}
+	bu := profitBucket(profits...)
+
+	iid, _ := (&MonteCarlo{Runs: 300, Resampling: Bootstrap}).Run(bu)
+	block, err := (&MonteCarlo{Runs: 300, Resampling: BlockBootstrap, MeanBlockLen: 20}).Run(bu)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if block.LosingStreak.Percentile(50) <= iid.LosingStreak.Percentile(50) {
+		t.Errorf("expected longer streaks with blocks, returned %f and %f",
+			block.LosingStreak.Percentile(50), iid.LosingStreak.Percentile(50))
+	}
+}
+
+func TestMonteCarlo_Errors(t *testing.T) {
+	if _, err := (&MonteCarlo{}).Run(profitBucket()); err != ErrBucketHasNoTrades {
+		t.Errorf("expected %v, returned %v", ErrBucketHasNoTrades, err)
+	}
+	if _, err := (&MonteCarlo{MeanBlockLen: 0.5}).Run(profitBucket(1)); err != ErrBlockLenIsBelowOne {
+		t.Errorf("expected %v, returned %v", ErrBlockLenIsBelowOne, err)
+	}
+	if _, err := (&MonteCarlo{Resampling: BlockBootstrap + 1}).Run(profitBucket(1)); err != ErrResamplingIsNotDefined {
+		t.Errorf("expected %v, returned %v", ErrResamplingIsNotDefined, err)
+	}
+}
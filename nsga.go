@@ -0,0 +1,243 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+func HitRatioFitness(bu *Bucket) float64 {
+	return bu.HitRatio()
+}
+
+// MaxDrawdownFitness is the negated drawdown, fitness is maximized
+func MaxDrawdownFitness(bu *Bucket) float64 {
+	return -bu.MaxDrawdown()
+}
+
+func TradeCountFitness(bu *Bucket) float64 {
+	return float64(len(bu.Trades))
+}
+
+// objectiveScores has a row of objective values per bucket, pruned
+// buckets are dominated by every other one
+func objectiveScores(buckets []*Bucket, objectives []Fitness) [][]float64 {
+	res := make([][]float64, len(buckets))
+	for i, bu := range buckets {
+		res[i] = make([]float64, len(objectives))
+		for k, obj := range objectives {
+			if bu.Pruned {
+				res[i][k] = math.Inf(-1)
+			} else {
+				res[i][k] = obj(bu)
+			}
+		}
+	}
+	return res
+}
+
+// dominates is true when a is no worse than b in every objective and
+// better in at least one
+func dominates(a []float64, b []float64) bool {
+	better := false
+	for k := range a {
+		if a[k] < b[k] {
+			return false
+		}
+		if a[k] > b[k] {
+			better = true
+		}
+	}
+	return better
+}
+
+// ParetoRanks sorts score rows into non dominated fronts, rank 0 is the
+// pareto front and rank r+1 is dominated by rank r only
+func ParetoRanks(scores [][]float64) []int {
+	n := len(scores)
+	ranks := make([]int, n)
+	dominated := make([][]int, n)
+	counts := make([]int, n)
+
+	front := make([]int, 0)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if dominates(scores[i], scores[j]) {
+				dominated[i] = append(dominated[i], j)
+				counts[j]++
+			} else if dominates(scores[j], scores[i]) {
+				dominated[j] = append(dominated[j], i)
+				counts[i]++
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		if counts[i] == 0 {
+			front = append(front, i)
+		}
+	}
+
+	for rank := 0; len(front) > 0; rank++ {
+		next := make([]int, 0)
+		for _, i := range front {
+			ranks[i] = rank
+			for _, j := range dominated[i] {
+				if counts[j]--; counts[j] == 0 {
+					next = append(next, j)
+				}
+			}
+		}
+		front = next
+	}
+	return ranks
+}
+
+// CrowdingDistances measure how isolated each row is inside its front,
+// summed over objectives normalized by their range in the front. The
+// extremes of every objective are infinitely far
+func CrowdingDistances(scores [][]float64, ranks []int) []float64 {
+	dists := make([]float64, len(scores))
+
+	fronts := make(map[int][]int)
+	for i, r := range ranks {
+		fronts[r] = append(fronts[r], i)
+	}
+	for _, front := range fronts {
+		for k := range scores[front[0]] {
+			sort.SliceStable(front, func(a, b int) bool {
+				return scores[front[a]][k] < scores[front[b]][k]
+			})
+
+			lo, hi := scores[front[0]][k], scores[front[len(front)-1]][k]
+			dists[front[0]], dists[front[len(front)-1]] = math.Inf(1), math.Inf(1)
+			if hi-lo <= 0 || math.IsInf(hi-lo, 0) || math.IsNaN(hi-lo) {
+				continue
+			}
+			for a := 1; a < len(front)-1; a++ {
+				dists[front[a]] += (scores[front[a+1]][k] - scores[front[a-1]][k]) / (hi - lo)
+			}
+		}
+	}
+	return dists
+}
+
+// crowdedLess orders by rank, then by crowding distance descending
+func crowdedLess(ranks []int, dists []float64, i int, j int) bool {
+	if ranks[i] != ranks[j] {
+		return ranks[i] < ranks[j]
+	}
+	return dists[i] > dists[j]
+}
+
+// NSGA2 evolves a population towards the pareto front of the objectives
+// (Deb et al. 2002). Offspring are mutated clones of parents won by
+// binary tournaments, the next population keeps the best of parents and
+// offspring by rank then crowding distance
+type NSGA2 struct {
+	Population  int
+	Generations int
+	// Objectives are maximized, at least one is needed
+	Objectives []Fitness
+	// Init draws the first population, defaults to RandomAgent
+	Init      func() *Agent
+	Evaluator *Evaluator
+	// Progress is called after every generation, optional
+	Progress func(gen int, front int)
+}
+
+// ParetoFront holds the non dominated agents of the final population
+// with their buckets and objective scores, pruned agents left out
+type ParetoFront struct {
+	Agents  []*Agent    `json:"agents"`
+	Buckets []*Bucket   `json:"buckets"`
+	Scores  [][]float64 `json:"scores"`
+}
+
+var (
+	ErrNSGA2IsNotComplete = fmt.Errorf("nsga2 needs a population of 2 and an objective")
+)
+
+func (ns *NSGA2) Run(ctx context.Context, pc *PairColumns) (*ParetoFront, error) {
+	if ns.Population < 2 || len(ns.Objectives) == 0 {
+		return nil, ErrNSGA2IsNotComplete
+	}
+	init := ns.Init
+	if init == nil {
+		init = RandomAgent
+	}
+	ev := ns.Evaluator
+	if ev == nil {
+		ev = &Evaluator{}
+	}
+
+	agents := make([]*Agent, ns.Population)
+	for i := range agents {
+		agents[i] = init()
+	}
+	buckets, err := ev.Evaluate(ctx, agents, pc)
+	if err != nil {
+		return nil, err
+	}
+	scores := objectiveScores(buckets, ns.Objectives)
+	ranks := ParetoRanks(scores)
+	dists := CrowdingDistances(scores, ranks)
+
+	for gen := 0; gen < ns.Generations; gen++ {
+		offspring := make([]*Agent, ns.Population)
+		for i := range offspring {
+			a, b := rand.Intn(len(agents)), rand.Intn(len(agents))
+			if crowdedLess(ranks, dists, b, a) {
+				a = b
+			}
+			offspring[i] = agents[a].Clone()
+			offspring[i].Mutate()
+		}
+		obs, err := ev.Evaluate(ctx, offspring, pc)
+		if err != nil {
+			return nil, err
+		}
+
+		agents, buckets = append(agents, offspring...), append(buckets, obs...)
+		scores = append(scores, objectiveScores(obs, ns.Objectives)...)
+		ranks = ParetoRanks(scores)
+		dists = CrowdingDistances(scores, ranks)
+
+		order := make([]int, len(agents))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return crowdedLess(ranks, dists, order[a], order[b])
+		})
+		order = order[:ns.Population]
+
+		nagents, nbuckets := make([]*Agent, len(order)), make([]*Bucket, len(order))
+		nscores, nranks, ndists := make([][]float64, len(order)), make([]int, len(order)), make([]float64, len(order))
+		for i, j := range order {
+			nagents[i], nbuckets[i], nscores[i], nranks[i], ndists[i] = agents[j], buckets[j], scores[j], ranks[j], dists[j]
+		}
+		agents, buckets, scores, ranks, dists = nagents, nbuckets, nscores, nranks, ndists
+
+		if ns.Progress != nil {
+			front := 0
+			for _, r := range ranks {
+				if r == 0 {
+					front++
+				}
+			}
+			ns.Progress(gen+1, front)
+		}
+	}
+
+	res := &ParetoFront{}
+	for i, r := range ranks {
+		if r == 0 && !buckets[i].Pruned {
+			res.Agents = append(res.Agents, agents[i])
+			res.Buckets = append(res.Buckets, buckets[i])
+			res.Scores = append(res.Scores, scores[i])
+		}
+	}
+	return res, nil
+}
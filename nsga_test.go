@@ -0,0 +1,115 @@
+package agent2
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDominates(t *testing.T) {
+	if !dominates([]float64{2, 1}, []float64{1, 1}) {
+		t.Errorf("expected 2, 1 to dominate 1, 1")
+	}
+	if dominates([]float64{1, 1}, []float64{1, 1}) {
+		t.Errorf("expected equal rows not to dominate")
+	}
+	if dominates([]float64{2, 0}, []float64{1, 1}) {
+		t.Errorf("expected trade off rows not to dominate")
+	}
+}
+
+func TestParetoRanks(t *testing.T) {
+	ranks := ParetoRanks([][]float64{{3, 1}, {1, 3}, {0, 0}, {2, 2}, {1, 1}, {2, 2}})
+
+	expected := []int{0, 0, 2, 0, 1, 0}
+	for i := range expected {
+		if ranks[i] != expected[i] {
+			t.Errorf("expected rank %d at %d, returned %d", expected[i], i, ranks[i])
+		}
+	}
+}
+
+func TestCrowdingDistances(t *testing.T) {
+	scores := [][]float64{{3, 0}, {0, 3}, {2, 1}, {1, 2}, {0, 0}}
+	dists := CrowdingDistances(scores, ParetoRanks(scores))
+
+	if !math.IsInf(dists[0], 1) || !math.IsInf(dists[1], 1) || !math.IsInf(dists[4], 1) {
+		t.Errorf("expected extremes and single fronts infinitely far, returned %v", dists)
+	}
+	for _, i := range []int{2, 3} {
+		if math.Abs(dists[i]-4.0/3) > 1e-12 {
+			t.Errorf("expected distance 4/3 at %d, returned %f", i, dists[i])
+		}
+	}
+}
+
+func TestNSGA2_Run(t *testing.T) {
+	rand.Seed(101)
+	pc, _ := NewPairColumns(randomWalkKlines(1_500, 1), randomWalkKlines(1_500, 2))
+
+	gens := 0
+	ns := &NSGA2{
+		Population:  12,
+		Generations: 3,
+		Objectives:  []Fitness{ProfitPerDayFitness, MaxDrawdownFitness, TradeCountFitness},
+		Init: func() *Agent {
+			return evaluatorAgents(1)[0]
+		},
+		Progress: func(gen int, front int) {
+			gens++
+			if gen != gens || front < 1 || front > 12 {
+				t.Errorf("unexpected progress of generation %d with front %d", gen, front)
+			}
+		},
+	}
+	res, err := ns.Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if gens != 3 {
+		t.Errorf("expected 3 generations, returned %d", gens)
+	}
+	if len(res.Agents) == 0 || len(res.Agents) != len(res.Buckets) || len(res.Agents) != len(res.Scores) {
+		t.Fatalf("unexpected front of %d agents", len(res.Agents))
+	}
+	for i := range res.Scores {
+		if res.Scores[i][2] != float64(len(res.Buckets[i].Trades)) {
+			t.Errorf("expected scores of the bucket")
+		}
+		for j := range res.Scores {
+			if dominates(res.Scores[j], res.Scores[i]) {
+				t.Errorf("front member %d is dominated by %d", i, j)
+			}
+		}
+	}
+}
+
+func TestNSGA2_PrunedAreLeftOut(t *testing.T) {
+	rand.Seed(157)
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	ev := &Evaluator{Prune: &PruneRules{MinProfitPerDay: 1e9, MinProfitPerDayAfterMillis: 1}}
+
+	ns := &NSGA2{Population: 4, Generations: 1, Objectives: []Fitness{ProfitPerDayFitness, HitRatioFitness}, Evaluator: ev}
+	res, err := ns.Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Agents) != 0 || len(res.Scores) != 0 {
+		t.Errorf("expected pruned agents left out, returned %d", len(res.Agents))
+	}
+	if _, err := json.Marshal(res); err != nil {
+		t.Errorf("expected a json result but raised %v", err)
+	}
+}
+
+func TestNSGA2_NotComplete(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(300, 1), randomWalkKlines(300, 2))
+
+	for _, ns := range []*NSGA2{{Population: 1, Objectives: []Fitness{ProfitPerDayFitness}}, {Population: 10}} {
+		if _, err := ns.Run(context.Background(), pc); err != ErrNSGA2IsNotComplete {
+			t.Errorf("expected %v, returned %v", ErrNSGA2IsNotComplete, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package agent2
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Returns sums net profits of trades by the period they close in,
+// periods start at StartTime and cover the bucket up to EndTime
+func (bu *Bucket) Returns(periodMillis int64) []float64 {
+	if periodMillis <= 0 || bu.EndTime < bu.StartTime {
+		return nil
+	}
+
+	res := make([]float64, (bu.EndTime-bu.StartTime)/periodMillis+1)
+	for _, tr := range bu.Trades {
+		if p := (tr.CloseTime - bu.StartTime) / periodMillis; p >= 0 && p < int64(len(res)) {
+			res[p] += tr.NetProfit
+		}
+	}
+	return res
+}
+
+// PopulationReturns are the per period returns of every bucket, buckets
+// of a population evaluated without pruning share their periods
+func PopulationReturns(buckets []*Bucket, periodMillis int64) [][]float64 {
+	res := make([][]float64, len(buckets))
+	for i, bu := range buckets {
+		res[i] = bu.Returns(periodMillis)
+	}
+	return res
+}
+
+// SharpeRatio is the per period sharpe ratio of returns, 0 when they
+// don't vary
+func SharpeRatio(returns []float64) float64 {
+	var sum, sumSq float64
+	for _, r := range returns {
+		sum, sumSq = sum+r, sumSq+r*r
+	}
+	return sharpeOfSums(float64(len(returns)), sum, sumSq)
+}
+
+func sharpeOfSums(n float64, sum float64, sumSq float64) float64 {
+	if n == 0 {
+		return 0
+	}
+	m := sum / n
+	std := math.Sqrt(math.Max(0, sumSq/n-m*m))
+	// variance of constant returns can be left over by cancellation
+	if std <= float64Eps*math.Abs(m) {
+		return 0
+	}
+	return m / std
+}
+
+const (
+	eulerMascheroni = 0.5772156649015329
+)
+
+var (
+	ErrReturnsAreBelowTwo          = fmt.Errorf("sharpe ratio needs at least 2 returns")
+	ErrReturnsDontVary             = fmt.Errorf("returns don't vary, sharpe ratio is not defined")
+	ErrTrialsAreBelowOne           = fmt.Errorf("deflated sharpe needs at least 1 trial")
+	ErrSharpeVarianceIsNotPositive = fmt.Errorf("sharpe ratio estimate variance is not positive")
+	ErrPopulationIsBelowTwo        = fmt.Errorf("population needs at least 2 members")
+	ErrReturnsAreNotAligned        = fmt.Errorf("population returns are not of the same length")
+	ErrPBOGroupsAreNotValid        = fmt.Errorf("pbo groups should be even, between 2 and 24 and at most the returns length")
+)
+
+// DeflatedSharpe is the probability that the true sharpe ratio of
+// returns is above the expected maximum of trials zero skill sharpe
+// ratios, srVariance is the variance of sharpe ratios over the trials
+// (Bailey, Lopez de Prado 2014). Skewness and kurtosis of returns
+// widen the sharpe ratio estimate
+func DeflatedSharpe(returns []float64, trials int, srVariance float64) (float64, error) {
+	if len(returns) < 2 {
+		return 0, ErrReturnsAreBelowTwo
+	}
+	if trials < 1 {
+		return 0, ErrTrialsAreBelowOne
+	}
+
+	m, _ := mean(returns)
+	std, _ := stddev(returns, m)
+	if std == 0 {
+		return 0, ErrReturnsDontVary
+	}
+	var m3, m4 float64
+	for _, r := range returns {
+		d := (r - m) / std
+		m3, m4 = m3+d*d*d, m4+d*d*d*d
+	}
+	t := float64(len(returns))
+	skew, kurt, sr := m3/t, m4/t, m/std
+
+	denom := 1 - skew*sr + (kurt-1)/4*sr*sr
+	if denom <= 0 {
+		return 0, ErrSharpeVarianceIsNotPositive
+	}
+	return normCdf((sr - expectedMaxSharpe(trials, srVariance)) * math.Sqrt(t-1) / math.Sqrt(denom)), nil
+}
+
+// expectedMaxSharpe is the expected max of trials sharpe ratios with
+// zero mean and srVariance variance
+func expectedMaxSharpe(trials int, srVariance float64) float64 {
+	if trials == 1 || srVariance <= 0 {
+		return 0
+	}
+	n := float64(trials)
+	return math.Sqrt(srVariance) * ((1-eulerMascheroni)*normInv(1-1/n) + eulerMascheroni*normInv(1-1/(n*math.E)))
+}
+
+// DeflatedSharpeOfBest selects the member of a population with the best
+// sharpe ratio and deflates it by the population size and the variance
+// of sharpe ratios in it
+func DeflatedSharpeOfBest(returns [][]float64) (int, float64, error) {
+	if len(returns) < 2 {
+		return 0, 0, ErrPopulationIsBelowTwo
+	}
+
+	srs := make([]float64, len(returns))
+	best := 0
+	for i, rets := range returns {
+		srs[i] = SharpeRatio(rets)
+		if srs[i] > srs[best] {
+			best = i
+		}
+	}
+	m, _ := mean(srs)
+	std, _ := stddev(srs, m)
+
+	dsr, err := DeflatedSharpe(returns[best], len(returns), std*std)
+	return best, dsr, err
+}
+
+func normCdf(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func normInv(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+const (
+	maxPBOGroups = 24
+)
+
+// PBOResult holds the logit of the out of sample relative rank of the
+// in sample best member for every combination of groups
+type PBOResult struct {
+	PBO    float64   `json:"pbo"`
+	Logits []float64 `json:"logits"`
+}
+
+// PBO is the probability of backtest overfitting by combinatorially
+// symmetric cross validation (Bailey et al. 2015). Periods are split
+// into groups, every half of the groups is taken as in sample once and
+// the rest as out of sample. PBO is the share of combinations where
+// the in sample best by sharpe ratio ranks below the out of sample
+// median. returns holds a member per row, all of the same length
+func PBO(returns [][]float64, groups int) (*PBOResult, error) {
+	if len(returns) < 2 {
+		return nil, ErrPopulationIsBelowTwo
+	}
+	t := len(returns[0])
+	for _, rets := range returns {
+		if len(rets) != t {
+			return nil, ErrReturnsAreNotAligned
+		}
+	}
+	if groups < 2 || groups%2 != 0 || groups > maxPBOGroups || groups > t {
+		return nil, ErrPBOGroupsAreNotValid
+	}
+
+	// per group sums of every member, sharpe ratios of a combination
+	// are taken from them
+	type groupSums struct {
+		n, sum, sumSq float64
+	}
+	sums := make([][]groupSums, len(returns))
+	for i, rets := range returns {
+		sums[i] = make([]groupSums, groups)
+		for g := 0; g < groups; g++ {
+			gs := &sums[i][g]
+			for _, r := range rets[g*t/groups : (g+1)*t/groups] {
+				gs.n, gs.sum, gs.sumSq = gs.n+1, gs.sum+r, gs.sumSq+r*r
+			}
+		}
+	}
+	// sharpe ratios of member i in and out of sample
+	sharpes := func(i int, mask uint32) (float64, float64) {
+		var is, oos groupSums
+		for g, gs := range sums[i] {
+			agg := &oos
+			if mask&(1<<g) != 0 {
+				agg = &is
+			}
+			agg.n, agg.sum, agg.sumSq = agg.n+gs.n, agg.sum+gs.sum, agg.sumSq+gs.sumSq
+		}
+		return sharpeOfSums(is.n, is.sum, is.sumSq), sharpeOfSums(oos.n, oos.sum, oos.sumSq)
+	}
+
+	res := &PBOResult{}
+	oosSRs := make([]float64, len(returns))
+	overfit := 0
+	for mask := uint32(0); mask < 1<<groups; mask++ {
+		if bits.OnesCount32(mask) != groups/2 {
+			continue
+		}
+
+		best, bestSR := 0, math.Inf(-1)
+		for i := range returns {
+			var sr float64
+			if sr, oosSRs[i] = sharpes(i, mask); sr > bestSR {
+				best, bestSR = i, sr
+			}
+		}
+
+		// relative rank out of sample, ties share the mean rank
+		below, ties := 0, 0
+		for _, sr := range oosSRs {
+			switch {
+			case sr < oosSRs[best]:
+				below++
+			case sr == oosSRs[best]:
+				ties++
+			}
+		}
+		rank := float64(below) + float64(ties+1)/2
+		w := rank / float64(len(returns)+1)
+		logit := math.Log(w / (1 - w))
+
+		res.Logits = append(res.Logits, logit)
+		if logit <= 0 {
+			overfit++
+		}
+	}
+	res.PBO = float64(overfit) / float64(len(res.Logits))
+	return res, nil
+}
@@ -0,0 +1,164 @@
+package agent2
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestBucket_Returns(t *testing.T) {
+	bu, _ := NewBucket(1_000, 1_000+3*60_000-1)
+	bu.Trades = []*Trade{
+		{CloseTime: 1_000 + 59_999, NetProfit: 1},
+		{CloseTime: 1_000 + 60_000, NetProfit: 2},
+		{CloseTime: 1_000 + 61_000, NetProfit: -0.5},
+		{CloseTime: 1_000 + 3*60_000 - 1, NetProfit: 4},
+	}
+
+	rets := bu.Returns(60_000)
+	expected := []float64{1, 1.5, 4}
+	if len(rets) != len(expected) {
+		t.Fatalf("expected %d returns, returned %d", len(expected), len(rets))
+	}
+	for i := range expected {
+		if rets[i] != expected[i] {
+			t.Errorf("expected return %f at %d, returned %f", expected[i], i, rets[i])
+		}
+	}
+	if bu.Returns(0) != nil {
+		t.Errorf("expected no returns for a zero period")
+	}
+}
+
+func TestSharpeRatio(t *testing.T) {
+	if sr := SharpeRatio([]float64{1, 3, 1, 3}); sr != 2 {
+		t.Errorf("expected 2, returned %f", sr)
+	}
+	if sr := SharpeRatio([]float64{0.1, 0.1, 0.1}); sr != 0 {
+		t.Errorf("expected 0 for constant returns, returned %f", sr)
+	}
+	if sr := SharpeRatio(nil); sr != 0 {
+		t.Errorf("expected 0 for no returns, returned %f", sr)
+	}
+}
+
+func TestExpectedMaxSharpe(t *testing.T) {
+	// ~3.26 standard deviations for 1000 trials
+	if sr := expectedMaxSharpe(1_000, 1); math.Abs(sr-3.2547) > 1e-3 {
+		t.Errorf("expected 3.2547, returned %f", sr)
+	}
+	if sr := expectedMaxSharpe(1, 1); sr != 0 {
+		t.Errorf("expected 0 for a single trial, returned %f", sr)
+	}
+}
+
+func TestDeflatedSharpe(t *testing.T) {
+	// no skew, kurtosis 1, a single trial is the probabilistic sharpe
+	dsr, err := DeflatedSharpe([]float64{1, 3, 1, 3}, 1, 0)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if expected := normCdf(2 * math.Sqrt(3)); math.Abs(dsr-expected) > 1e-12 {
+		t.Errorf("expected %f, returned %f", expected, dsr)
+	}
+
+	rand.Seed(59)
+	rets := make([]float64, 500)
+	for i := range rets {
+		rets[i] = rand.NormFloat64() + 0.1
+	}
+	prev := 1.0
+	for _, trials := range []int{1, 10, 100, 10_000} {
+		dsr, err := DeflatedSharpe(rets, trials, 0.01)
+		if err != nil {
+			t.Fatalf("expected no error but raised %v", err)
+		}
+		if dsr >= prev {
+			t.Errorf("expected deflated sharpe to drop with %d trials, returned %f after %f", trials, dsr, prev)
+		}
+		prev = dsr
+	}
+}
+
+func TestDeflatedSharpe_Errors(t *testing.T) {
+	if _, err := DeflatedSharpe([]float64{1}, 1, 0); err != ErrReturnsAreBelowTwo {
+		t.Errorf("expected %v, returned %v", ErrReturnsAreBelowTwo, err)
+	}
+	if _, err := DeflatedSharpe([]float64{1, 2}, 0, 0); err != ErrTrialsAreBelowOne {
+		t.Errorf("expected %v, returned %v", ErrTrialsAreBelowOne, err)
+	}
+	if _, err := DeflatedSharpe([]float64{1, 1}, 1, 0); err != ErrReturnsDontVary {
+		t.Errorf("expected %v, returned %v", ErrReturnsDontVary, err)
+	}
+}
+
+func TestDeflatedSharpeOfBest(t *testing.T) {
+	rand.Seed(61)
+	pop := noiseReturns(200, 250)
+
+	best, dsr, err := DeflatedSharpeOfBest(pop)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for i, rets := range pop {
+		if SharpeRatio(rets) > SharpeRatio(pop[best]) {
+			t.Errorf("member %d has a better sharpe ratio than %d", i, best)
+		}
+	}
+	// the best of noise is not significant once deflated
+	if dsr > 0.9 {
+		t.Errorf("expected a low deflated sharpe for noise, returned %f", dsr)
+	}
+}
+
+func noiseReturns(members int, periods int) [][]float64 {
+	res := make([][]float64, members)
+	for i := range res {
+		res[i] = make([]float64, periods)
+		for j := range res[i] {
+			res[i][j] = rand.NormFloat64()
+		}
+	}
+	return res
+}
+
+func TestPBO(t *testing.T) {
+	rand.Seed(67)
+	noise := noiseReturns(50, 160)
+
+	res, err := PBO(noise, 8)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Logits) != 70 {
+		t.Errorf("expected 70 combinations, returned %d", len(res.Logits))
+	}
+	if res.PBO < 0.2 || res.PBO > 0.8 {
+		t.Errorf("expected pbo around 0.5 for noise, returned %f", res.PBO)
+	}
+
+	// a member with skill is the best in and out of sample
+	for j := range noise[7] {
+		noise[7][j] += 1
+	}
+	if res, _ := PBO(noise, 8); res.PBO != 0 {
+		t.Errorf("expected pbo 0 with a dominant member, returned %f", res.PBO)
+	}
+}
+
+func TestPBO_Errors(t *testing.T) {
+	pop := noiseReturns(3, 10)
+
+	if _, err := PBO(pop[:1], 2); err != ErrPopulationIsBelowTwo {
+		t.Errorf("expected %v, returned %v", ErrPopulationIsBelowTwo, err)
+	}
+	for _, groups := range []int{0, 3, 12, 26} {
+		if _, err := PBO(pop, groups); err != ErrPBOGroupsAreNotValid {
+			t.Errorf("expected %v for %d groups, returned %v", ErrPBOGroupsAreNotValid, groups, err)
+		}
+	}
+	pop[1] = pop[1][:9]
+	if _, err := PBO(pop, 2); err != ErrReturnsAreNotAligned {
+		t.Errorf("expected %v, returned %v", ErrReturnsAreNotAligned, err)
+	}
+}
@@ -0,0 +1,58 @@
+package agent2
+
+// PruneRules stop a backtest early once an agent is hopeless, zero
+// fields are disabled. Durations are counted from the bucket start
+type PruneRules struct {
+	// MaxDrawdown of closed trades, in the units of NetProfit
+	MaxDrawdown float64
+	// MinTrades closed trades needed after MinTradesAfterMillis, enabled
+	// by the latter as a bucket starts without trades
+	MinTrades            int
+	MinTradesAfterMillis int64
+	// MinProfitPerDay of closed trades needed at every bar after
+	// MinProfitPerDayAfterMillis, enabled by the latter so 0 can be used
+	// to prune losing agents
+	MinProfitPerDay            float64
+	MinProfitPerDayAfterMillis int64
+}
+
+// pruneState keeps running totals of a bucket for PruneRules
+type pruneState struct {
+	rules   *PruneRules
+	profit  float64
+	peak    float64
+	checked int
+}
+
+func newPruneState(rules *PruneRules) *pruneState {
+	return &pruneState{rules: rules}
+}
+
+// prune tells if the bucket should be cut at the given time
+func (ps *pruneState) prune(bu *Bucket, now int64) bool {
+	if ps.rules == nil {
+		return false
+	}
+	pr := ps.rules
+
+	for ; ps.checked < len(bu.Trades); ps.checked++ {
+		ps.profit += bu.Trades[ps.checked].NetProfit
+		if ps.profit > ps.peak {
+			ps.peak = ps.profit
+		}
+		if pr.MaxDrawdown > 0 && ps.peak-ps.profit > pr.MaxDrawdown {
+			return true
+		}
+	}
+
+	elapsed := now - bu.StartTime
+	if pr.MinTradesAfterMillis > 0 && elapsed >= pr.MinTradesAfterMillis && len(bu.Trades) < pr.MinTrades {
+		return true
+	}
+	if pr.MinProfitPerDayAfterMillis > 0 && elapsed >= pr.MinProfitPerDayAfterMillis {
+		if ps.profit/(float64(elapsed)/dayLenMillis) < pr.MinProfitPerDay {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package agent2
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPruneState_Disabled(t *testing.T) {
+	bu, _ := NewBucket(0, 10)
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: -100})
+
+	if newPruneState(nil).prune(bu, 5) || newPruneState(&PruneRules{}).prune(bu, 5) {
+		t.Errorf("expected no pruning without rules")
+	}
+}
+
+func TestPruneState_MaxDrawdown(t *testing.T) {
+	ps := newPruneState(&PruneRules{MaxDrawdown: 5})
+	bu, _ := NewBucket(0, 10)
+
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: 10}, &Trade{NetProfit: -4})
+	if ps.prune(bu, 1) {
+		t.Errorf("expected no pruning within drawdown")
+	}
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: -2})
+	if !ps.prune(bu, 2) {
+		t.Errorf("expected pruning beyond drawdown")
+	}
+}
+
+func TestPruneState_MinTrades(t *testing.T) {
+	ps := newPruneState(&PruneRules{MinTrades: 2, MinTradesAfterMillis: 100})
+	bu, _ := NewBucket(1_000, 2_000)
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: 1})
+
+	if ps.prune(bu, 1_099) {
+		t.Errorf("expected no pruning before min trades time")
+	}
+	if !ps.prune(bu, 1_100) {
+		t.Errorf("expected pruning with too few trades")
+	}
+
+	// without a time min trades is disabled
+	ps = newPruneState(&PruneRules{MinTrades: 2})
+	if ps.prune(bu, 1_100) {
+		t.Errorf("expected no pruning without min trades time")
+	}
+}
+
+func TestPruneState_MinProfitPerDay(t *testing.T) {
+	day := int64(dayLenMillis)
+	ps := newPruneState(&PruneRules{MinProfitPerDay: 0, MinProfitPerDayAfterMillis: day})
+	bu, _ := NewBucket(0, 10*day)
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: 2}, &Trade{NetProfit: -1})
+
+	if ps.prune(bu, 2*day) {
+		t.Errorf("expected no pruning of a profitable agent")
+	}
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: -1.5})
+	if !ps.prune(bu, 2*day) {
+		t.Errorf("expected pruning of a losing agent")
+	}
+}
+
+func TestBacktestPruned(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(5_000, 3), randomWalkKlines(5_000, 4))
+	ag := RandomAgent()
+	ag.Indicators, ag.Rule = nil, nil
+
+	full, _ := ag.Backtest(pc)
+	if full.Pruned {
+		t.Errorf("expected unpruned bucket without rules")
+	}
+
+	bu, err := ag.BacktestPruned(pc, &PruneRules{MinTrades: len(full.Trades) + 1, MinTradesAfterMillis: 60 * 60 * 1_000})
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	// the first bar closing an hour after the start is the 61st
+	if !bu.Pruned || bu.EndTime != bu.StartTime+61*60_000-1 {
+		t.Errorf("expected bucket pruned after an hour, ends at %d", bu.EndTime-bu.StartTime)
+	}
+	for i, tr := range bu.Trades {
+		if *tr != *full.Trades[i] {
+			t.Errorf("expected pruned trades to be a prefix of the full backtest")
+		}
+	}
+}
+
+func TestEvaluator_Prune(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	ev := &Evaluator{Prune: &PruneRules{MinTrades: 1_000, MinTradesAfterMillis: 1}}
+
+	buckets, _ := ev.Evaluate(context.Background(), evaluatorAgents(5), pc)
+	for i, bu := range buckets {
+		if !bu.Pruned {
+			t.Errorf("expected bucket %d to be pruned", i)
+		}
+	}
+}
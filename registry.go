@@ -0,0 +1,230 @@
+package agent2
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// Indicator is an entry condition of an agent, evaluated on the last
+// Lookback klines of both legs. Monitor is used to avoid drawing two
+// indicators of the same kind on the same series in RandomAgent
+type Indicator interface {
+	Kind() string
+	Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error)
+	Lookback() int
+	Monitor() Monitor
+	Mutate()
+	Clone() Indicator
+}
+
+// IndicatorSpec registers an indicator kind. New returns the zero value
+// json is decoded into, Random is used by RandomAgent up to MaxCount
+// times in Prob percent of agents, 0 leaves the kind out of random
+// agents. Cheaper Cost kinds are evaluated first. ScoreScale is the
+// score of a Scorer taken as a strong signal, defaults to 1
+type IndicatorSpec struct {
+	Kind       string
+	New        func() Indicator
+	Random     func() Indicator
+	MaxCount   int
+	Prob       int
+	Cost       int
+	ScoreScale float64
+}
+
+var (
+	ErrIndicatorSpecIsNotComplete       = fmt.Errorf("indicator spec needs kind, new and random")
+	ErrIndicatorKindIsAlreadyRegistered = fmt.Errorf("indicator kind is already registered")
+	ErrKlinesAreBelowIndicatorLookback  = fmt.Errorf("kline length is below indicator lookback")
+	ErrIndicatorCantBeNilForMarshal     = fmt.Errorf("indicator can't be nil for marshal")
+)
+
+var (
+	registryMu sync.RWMutex
+	specs      []*IndicatorSpec
+	specByKind = make(map[string]*IndicatorSpec)
+)
+
+func RegisterIndicator(spec IndicatorSpec) error {
+	if spec.Kind == "" || spec.New == nil || spec.Random == nil {
+		return ErrIndicatorSpecIsNotComplete
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := specByKind[spec.Kind]; ok {
+		return ErrIndicatorKindIsAlreadyRegistered
+	}
+	specs = append(specs, &spec)
+	specByKind[spec.Kind] = &spec
+	return nil
+}
+
+func registeredSpecs() []*IndicatorSpec {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return append([]*IndicatorSpec(nil), specs...)
+}
+
+func lookupSpec(kind string) (*IndicatorSpec, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	spec, ok := specByKind[kind]
+	if !ok {
+		return nil, fmt.Errorf("indicator kind %s is not registered", kind)
+	}
+	return spec, nil
+}
+
+func indicatorCost(ind Indicator) int {
+	spec, err := lookupSpec(ind.Kind())
+	if err != nil {
+		return 0
+	}
+	return spec.Cost
+}
+
+func indicatorScoreScale(ind Indicator) float64 {
+	spec, err := lookupSpec(ind.Kind())
+	if err != nil || spec.ScoreScale <= 0 {
+		return 1
+	}
+	return spec.ScoreScale
+}
+
+func init() {
+	for _, spec := range []IndicatorSpec{
+		{Kind: bbKind, New: func() Indicator { return &BB{} }, Random: func() Indicator { return RandomBB() },
+			MaxCount: maxBBCount, Prob: bbProb, Cost: 5, ScoreScale: 1},
+		{Kind: rsiKind, New: func() Indicator { return &RSI{} }, Random: func() Indicator { return RandomRSI() },
+			MaxCount: maxRSICount, Prob: rsiProb, Cost: 4, ScoreScale: 10},
+		{Kind: mfiKind, New: func() Indicator { return &MFI{} }, Random: func() Indicator { return RandomMFI() },
+			MaxCount: maxMFICount, Prob: mfiProb, Cost: 1, ScoreScale: 10},
+		{Kind: obvKind, New: func() Indicator { return &OBV{} }, Random: func() Indicator { return RandomOBV() },
+			MaxCount: maxOBVCount, Prob: obvProb, Cost: 3, ScoreScale: 0.1},
+		{Kind: vwapKind, New: func() Indicator { return &VWAP{} }, Random: func() Indicator { return RandomVWAP() },
+			MaxCount: maxVWAPCount, Prob: vwapProb, Cost: 2, ScoreScale: 0.005},
+	} {
+		if err := RegisterIndicator(spec); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Indicators is encoded as a tagged union, kind selects the registered
+// type params are decoded into
+type Indicators []Indicator
+
+type taggedIndicator struct {
+	Kind   string          `json:"kind"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (inds Indicators) MarshalJSON() ([]byte, error) {
+	if inds == nil {
+		return []byte("null"), nil
+	}
+
+	tagged := make([]taggedIndicator, 0, len(inds))
+	for _, ind := range inds {
+		if ind == nil {
+			return nil, ErrIndicatorCantBeNilForMarshal
+		}
+		params, err := json.Marshal(ind)
+		if err != nil {
+			return nil, err
+		}
+		tagged = append(tagged, taggedIndicator{Kind: ind.Kind(), Params: params})
+	}
+	return json.Marshal(tagged)
+}
+
+func (inds *Indicators) UnmarshalJSON(pload []byte) error {
+	var tagged []taggedIndicator
+
+	if err := json.Unmarshal(pload, &tagged); err != nil {
+		return err
+	}
+	if tagged == nil {
+		*inds = nil
+		return nil
+	}
+
+	res := make(Indicators, 0, len(tagged))
+	for _, tg := range tagged {
+		spec, err := lookupSpec(tg.Kind)
+		if err != nil {
+			return err
+		}
+		ind := spec.New()
+		if err := json.Unmarshal(tg.Params, ind); err != nil {
+			return err
+		}
+		if err := checkTimeframe(ind); err != nil {
+			return err
+		}
+		res = append(res, ind)
+	}
+	*inds = res
+	return nil
+}
+
+func (inds Indicators) Clone() Indicators {
+	if inds == nil {
+		return nil
+	}
+
+	res := make(Indicators, len(inds))
+	for i, ind := range inds {
+		res[i] = ind.Clone()
+	}
+	return res
+}
+
+// sortIndicators orders by cost then base lookback, so OpenPos fails fast
+func sortIndicators(inds Indicators) {
+	sort.SliceStable(inds, func(i, j int) bool {
+		ci, cj := indicatorCost(inds[i]), indicatorCost(inds[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return baseLookback(inds[i]) < baseLookback(inds[j])
+	})
+}
+
+func windowActive(ind Indicator, klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	return newPairFrames(klns1, klns2).active(ind)
+}
+
+const (
+	maxPeriodShift = 20
+	maxStepShift   = 5
+)
+
+func mutatePeriod(period int) int {
+	p := period + rand.Intn(2*maxPeriodShift+1) - maxPeriodShift
+	if p < minPeriod {
+		return minPeriod
+	}
+	if p > maxPeriod-1 {
+		return maxPeriod - 1
+	}
+	return p
+}
+
+// mutateStepped moves val by up to maxStepShift steps inside [min, max]
+func mutateStepped(val float64, step float64, min float64, max float64) float64 {
+	shift := float64(rand.Intn(2*maxStepShift+1) - maxStepShift)
+	v := roundTo4d(val + shift*step)
+
+	return math.Max(min, math.Min(max, v))
+}
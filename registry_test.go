@@ -0,0 +1,181 @@
+package agent2
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// lastUp is a custom indicator, registered the way packages outside
+// of agent2 would plug in their own kinds
+type lastUp struct {
+	Window int `json:"window"`
+}
+
+func (lu *lastUp) Kind() string {
+	return "test_last_up"
+}
+
+func (lu *lastUp) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	return klns1[len(klns1)-1].Close > klns1[0].Close, nil
+}
+
+func (lu *lastUp) Lookback() int {
+	return lu.Window
+}
+
+func (lu *lastUp) Monitor() Monitor {
+	return Close1
+}
+
+func (lu *lastUp) Mutate() {
+	lu.Window = mutatePeriod(lu.Window)
+}
+
+func (lu *lastUp) Clone() Indicator {
+	c := *lu
+	return &c
+}
+
+func init() {
+	if err := RegisterIndicator(IndicatorSpec{
+		Kind:   "test_last_up",
+		New:    func() Indicator { return &lastUp{} },
+		Random: func() Indicator { return &lastUp{Window: randPeriod()} },
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterIndicator_Incomplete(t *testing.T) {
+	if err := RegisterIndicator(IndicatorSpec{Kind: "x"}); err != ErrIndicatorSpecIsNotComplete {
+		t.Errorf("expected %v to be raised, raised %v", ErrIndicatorSpecIsNotComplete, err)
+	}
+}
+
+func TestRegisterIndicator_Duplicate(t *testing.T) {
+	err := RegisterIndicator(IndicatorSpec{
+		Kind:   bbKind,
+		New:    func() Indicator { return &BB{} },
+		Random: func() Indicator { return RandomBB() },
+	})
+	if err != ErrIndicatorKindIsAlreadyRegistered {
+		t.Errorf("expected %v to be raised, raised %v", ErrIndicatorKindIsAlreadyRegistered, err)
+	}
+}
+
+func TestIndicators_CustomKindRoundTrip(t *testing.T) {
+	ag := RandomAgent()
+	ag.Indicators = Indicators{&lastUp{Window: 20}, RandomBB()}
+	ag.Rule = nil
+
+	pload, err := ag.Marshal()
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	ag2, err := UnmarshalAgent(pload)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	lu, ok := ag2.Indicators[0].(*lastUp)
+	if !ok || lu.Window != 20 {
+		t.Errorf("custom indicator is not decoded, received %v", ag2.Indicators[0])
+	}
+	if _, ok := ag2.Indicators[1].(*BB); !ok {
+		t.Errorf("bb is not decoded, received %v", ag2.Indicators[1])
+	}
+}
+
+func TestIndicators_CustomKindInOpenPos(t *testing.T) {
+	ag := RandomAgent()
+	ag.Indicators = Indicators{&lastUp{Window: 20}}
+	ag.Rule = nil
+
+	open, err := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if !open {
+		t.Errorf("expected openpos to return true, returned false")
+	}
+}
+
+func TestIndicators_UnregisteredKind(t *testing.T) {
+	var inds Indicators
+
+	err := json.Unmarshal([]byte(`[{"kind":"unknown","params":{}}]`), &inds)
+	if err == nil || err.Error() != "indicator kind unknown is not registered" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestIndicators_MarshalNil(t *testing.T) {
+	if _, err := json.Marshal(Indicators{nil}); err == nil {
+		t.Errorf("expected error nothing raised")
+	}
+}
+
+func TestWindowActive_LookbackAboveKlines(t *testing.T) {
+	lu := &lastUp{Window: 300}
+
+	if _, err := windowActive(lu, dummyKlines(250), dummyKlines(250)); err != ErrKlinesAreBelowIndicatorLookback {
+		t.Errorf("expected %v to be raised, raised %v", ErrKlinesAreBelowIndicatorLookback, err)
+	}
+}
+
+func TestMutatePeriod(t *testing.T) {
+	for i := 0; i < 10_000; i++ {
+		p := mutatePeriod(randPeriod())
+
+		if p < minPeriod || p > maxPeriod-1 {
+			t.Errorf("period %d is outside of allowed interval", p)
+		}
+	}
+}
+
+func TestMutateStepped(t *testing.T) {
+	for i := 0; i < 10_000; i++ {
+		m := mutateStepped(randMultiplier(), multiplierStep, minMultiplier, maxMultiplier)
+
+		if m < minMultiplier || m > maxMultiplier {
+			t.Errorf("multiplier %.4f is outside of allowed interval", m)
+		}
+	}
+}
+
+func TestIndicatorMutate_StaysValid(t *testing.T) {
+	for _, spec := range registeredSpecs() {
+		ind := spec.Random()
+
+		for i := 0; i < 1_000; i++ {
+			ind.Mutate()
+
+			lb := ind.Lookback()
+			if lb < minPeriod || lb > maxPeriod-1 {
+				t.Errorf("%s lookback %d is outside of allowed interval", ind.Kind(), lb)
+			}
+			if _, err := ind.Active(dummyKlines(lb), dummyKlines(lb)); err != nil {
+				t.Errorf("%s raised %v after mutation", ind.Kind(), err)
+			}
+		}
+	}
+}
+
+func TestIndicatorMutate_ChangesIndicator(t *testing.T) {
+	for _, spec := range registeredSpecs() {
+		ind := spec.Random()
+		before, _ := json.Marshal(ind)
+
+		changed := false
+		for i := 0; i < 100 && !changed; i++ {
+			ind.Mutate()
+			after, _ := json.Marshal(ind)
+			changed = string(before) != string(after)
+		}
+		if !changed {
+			t.Errorf("%s does not change with mutate", ind.Kind())
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Param is a numeric parameter of an agent, perturbed by multiples of
+// Step inside [Min, Max] in sensitivity analysis. Grid is the finest
+// change the param takes, local search moves by multiples of it. Set
+// writes a value back to the agent the param was taken from, params
+// without it are left out of Agent.Params
+type Param struct {
+	Name  string          `json:"name"`
+	Value float64         `json:"value"`
+	Step  float64         `json:"step"`
+	Grid  float64         `json:"grid"`
+	Min   float64         `json:"min"`
+	Max   float64         `json:"max"`
+	Set   func(v float64) `json:"-"`
+}
+
+// Parametric is implemented by indicators exposing their numeric params,
+// params are returned in a fixed order
+type Parametric interface {
+	Params() []Param
+}
+
+// sensitivity steps, coarser than mutation steps so neighbors differ
+const (
+	periodParamStep    = 5
+	targetValParamStep = float64(5)
+	obvSlopeParamStep  = 5 * obvSlopeStep
+	vwapDevParamStep   = 2 * vwapDevStep
+	tpslParamStep      = 2 * tpSLStep
+	backoffParamStep   = 6 * backoffStep
+	expiryParamStep    = 5 * expiryStep
+)
+
+func intParam(name string, val *int, step int, min int, max int) Param {
+	return Param{Name: name, Value: float64(*val), Step: float64(step), Grid: 1, Min: float64(min), Max: float64(max),
+		Set: func(v float64) { *val = int(math.Round(v)) }}
+}
+
+func floatParam(name string, val *float64, step float64, grid float64, min float64, max float64) Param {
+	return Param{Name: name, Value: *val, Step: step, Grid: grid, Min: min, Max: max,
+		Set: func(v float64) { *val = roundTo4d(v) }}
+}
+
+func millisParam(name string, val *int64, step int64, grid int64, min int64, max int64) Param {
+	return Param{Name: name, Value: float64(*val), Step: float64(step), Grid: float64(grid), Min: float64(min), Max: float64(max),
+		Set: func(v float64) { *val = int64(math.Round(v)) }}
+}
+
+func periodParam(period *int) Param {
+	return intParam("period", period, periodParamStep, minPeriod, maxPeriod-1)
+}
+
+// tpslParams keep take profit at or above stop loss as RandomTPSL does,
+// each is bounded by the current value of the other and clamped to the
+// value the other has when set
+func tpslParams(tpsl *TPSL) []Param {
+	tp := floatParam("tpsl.tp", &tpsl.TakeProfit, tpslParamStep, tpSLStep, math.Max(minTPSL, tpsl.StopLoss), maxTPSL)
+	tp.Set = func(v float64) { tpsl.TakeProfit = roundTo4d(math.Max(v, tpsl.StopLoss)) }
+
+	sl := floatParam("tpsl.sl", &tpsl.StopLoss, tpslParamStep, tpSLStep, minTPSL, math.Min(maxTPSL, tpsl.TakeProfit))
+	sl.Set = func(v float64) { tpsl.StopLoss = roundTo4d(math.Min(v, tpsl.TakeProfit)) }
+	return []Param{tp, sl}
+}
+
+// Params are the numeric params of the agent and of its parametric
+// indicators, indicator params are named by index and kind as 0.bb.period
+func (ag *Agent) Params() []Param {
+	res := make([]Param, 0)
+	if ag.Tpsl != nil {
+		res = append(res, tpslParams(ag.Tpsl)...)
+	}
+	if ag.Backoff != nil {
+		res = append(res, millisParam("backoff", &ag.Backoff.DurationMillis, backoffParamStep, backoffStep, minBackoffMillis, maxBackoffMillis))
+	}
+	res = append(res, millisParam("expiry", &ag.ExpiryMillis, expiryParamStep, expiryStep, minExpiryMillis, maxExpiryMillis))
+
+	for i, ind := range ag.Indicators {
+		pm, ok := ind.(Parametric)
+		if !ok {
+			continue
+		}
+		for _, p := range pm.Params() {
+			if p.Set == nil {
+				continue
+			}
+			p.Name = fmt.Sprintf("%d.%s.%s", i, ind.Kind(), p.Name)
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Sensitivity backtests neighbors of an agent, each moving a single
+// param by up to Steps (defaults to 2) steps to both sides
+type Sensitivity struct {
+	Steps int
+	// Fitness defaults to ProfitPerDayFitness
+	Fitness   Fitness
+	Evaluator *Evaluator
+}
+
+type SensitivityPoint struct {
+	Value   float64 `json:"value"`
+	Fitness float64 `json:"fitness"`
+}
+
+// ParamSensitivity has the fitness curve of a param in value order,
+// the agent itself included
+type ParamSensitivity struct {
+	Name       string             `json:"name"`
+	Value      float64            `json:"value"`
+	Curve      []SensitivityPoint `json:"curve"`
+	Robustness float64            `json:"robustness"`
+}
+
+// RobustnessReport scores neighbors by their fitness relative to the
+// agent fitness capped to [0, 1], pruned neighbors score 0. Robustness
+// is the mean neighbor score, 1 when no neighbor is worse than the
+// agent. Agents without a positive fitness have no robustness
+type RobustnessReport struct {
+	Fitness    float64             `json:"fitness"`
+	Robustness float64             `json:"robustness"`
+	Params     []*ParamSensitivity `json:"params"`
+}
+
+const (
+	defaultSensitivitySteps = 2
+)
+
+func (se *Sensitivity) Run(ctx context.Context, ag *Agent, pc *PairColumns) (*RobustnessReport, error) {
+	steps := se.Steps
+	if steps <= 0 {
+		steps = defaultSensitivitySteps
+	}
+	fitness := se.Fitness
+	if fitness == nil {
+		fitness = ProfitPerDayFitness
+	}
+	ev := se.Evaluator
+	if ev == nil {
+		ev = &Evaluator{}
+	}
+
+	// agents[0] is the agent, owners maps neighbors to their param
+	params := ag.Params()
+	agents, owners, values := []*Agent{ag}, []int{-1}, []float64{0}
+	for j, p := range params {
+		for k := -steps; k <= steps; k++ {
+			v := roundTo4d(p.Value + float64(k)*p.Step)
+			if k == 0 || v < p.Min || v > p.Max {
+				continue
+			}
+			c := ag.Clone()
+			c.Params()[j].Set(v)
+			c.sortIndicators()
+			agents, owners, values = append(agents, c), append(owners, j), append(values, v)
+		}
+	}
+
+	buckets, err := ev.Evaluate(ctx, agents, pc)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &RobustnessReport{Fitness: fitness(buckets[0]), Params: make([]*ParamSensitivity, len(params))}
+	for j, p := range params {
+		rep.Params[j] = &ParamSensitivity{
+			Name:       p.Name,
+			Value:      p.Value,
+			Curve:      []SensitivityPoint{{Value: p.Value, Fitness: rep.Fitness}},
+			Robustness: 1,
+		}
+	}
+
+	total, counts := 0.0, make([]int, len(params))
+	scores := make([]float64, len(params))
+	for i := 1; i < len(agents); i++ {
+		j := owners[i]
+		fit := fitness(buckets[i])
+		rep.Params[j].Curve = append(rep.Params[j].Curve, SensitivityPoint{Value: values[i], Fitness: fit})
+
+		score := 0.0
+		if rep.Fitness > 0 && !buckets[i].Pruned {
+			score = math.Max(0, math.Min(1, fit/rep.Fitness))
+		}
+		total, scores[j], counts[j] = total+score, scores[j]+score, counts[j]+1
+	}
+
+	for j, ps := range rep.Params {
+		sort.SliceStable(ps.Curve, func(a, b int) bool {
+			return ps.Curve[a].Value < ps.Curve[b].Value
+		})
+		if counts[j] > 0 {
+			ps.Robustness = roundTo4d(scores[j] / float64(counts[j]))
+		}
+	}
+	if len(agents) > 1 {
+		rep.Robustness = roundTo4d(total / float64(len(agents)-1))
+	}
+	return rep, nil
+}
@@ -0,0 +1,67 @@
+package agent2_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/varga-lp/agent2"
+	"github.com/varga-lp/data/klines"
+)
+
+// levelIndicator is an indicator of another package, its width param
+// has no setter
+type levelIndicator struct {
+	Level float64
+	Width float64
+}
+
+func (li *levelIndicator) Kind() string { return "test_ext_level" }
+
+func (li *levelIndicator) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	return klns1[len(klns1)-1].Close > li.Level, nil
+}
+
+func (li *levelIndicator) Lookback() int { return 1 }
+
+func (li *levelIndicator) Monitor() agent2.Monitor { return agent2.Close1 }
+
+func (li *levelIndicator) Mutate() {}
+
+func (li *levelIndicator) Clone() agent2.Indicator {
+	c := *li
+	return &c
+}
+
+func (li *levelIndicator) Params() []agent2.Param {
+	return []agent2.Param{
+		{Name: "level", Value: li.Level, Step: 1, Grid: 1, Min: 0, Max: 100, Set: func(v float64) { li.Level = v }},
+		{Name: "width", Value: li.Width, Step: 1, Grid: 1, Min: 0, Max: 10},
+	}
+}
+
+func TestParams_ExternalIndicator(t *testing.T) {
+	rand.Seed(167)
+	ag := agent2.RandomAgent()
+	ag.Indicators, ag.Rule = agent2.Indicators{&levelIndicator{Level: 50, Width: 5}}, nil
+
+	found := false
+	for _, p := range ag.Params() {
+		if strings.HasSuffix(p.Name, ".width") {
+			t.Errorf("expected params without setter left out, returned %s", p.Name)
+		}
+		found = found || p.Name == "0.test_ext_level.level"
+	}
+	if !found {
+		t.Fatalf("expected the level param of the indicator")
+	}
+
+	move, moved := agent2.StepMove(3), false
+	for i := 0; i < 200; i++ {
+		ag = move(ag)
+		moved = moved || ag.Indicators[0].(*levelIndicator).Level != 50
+	}
+	if !moved {
+		t.Errorf("expected the level moved by step moves")
+	}
+}
@@ -0,0 +1,126 @@
+package agent2
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func robustnessAgent() *Agent {
+	return &Agent{
+		Tpsl:         &TPSL{TakeProfit: 0.015, StopLoss: 0.01},
+		Backoff:      &Backoff{DurationMillis: 5 * 60_000},
+		ExpiryMillis: 60 * 60_000,
+		Indicators: Indicators{
+			&RSI{Mon: CloseR, ValuePos: Below, TargetVal: 45, Period: 60, Smoothing: Wilder, Length: 14},
+			&BB{Mon: CloseR, ValuePos: Below, Line: Middle, Period: 100, Multiplier: 1},
+		},
+	}
+}
+
+func TestAgent_Params(t *testing.T) {
+	ag := robustnessAgent()
+	params := ag.Params()
+
+	names := []string{"tpsl.tp", "tpsl.sl", "backoff", "expiry",
+		"0.rsi.period", "0.rsi.target_val", "1.bb.period", "1.bb.multiplier"}
+	if len(params) != len(names) {
+		t.Fatalf("expected %d params, returned %d", len(names), len(params))
+	}
+	for i, name := range names {
+		if params[i].Name != name {
+			t.Errorf("expected param %s at %d, returned %s", name, i, params[i].Name)
+		}
+	}
+
+	// rsi period can't drop below 3 smoothing lengths
+	if params[4].Min != 42 {
+		t.Errorf("expected rsi period min 42, returned %f", params[4].Min)
+	}
+
+	params[7].Set(1.3)
+	params[6].Set(105)
+	params[2].Set(600_000)
+	bb := ag.Indicators[1].(*BB)
+	if bb.Multiplier != 1.3 || bb.Period != 105 || ag.Backoff.DurationMillis != 600_000 {
+		t.Errorf("expected params set on the agent, returned %v and %d", bb, ag.Backoff.DurationMillis)
+	}
+}
+
+func TestAgent_ParamsKeepTakeProfitAboveStopLoss(t *testing.T) {
+	ag := robustnessAgent()
+	params := ag.Params()
+	if params[0].Min != 0.01 || params[1].Max != 0.015 {
+		t.Errorf("expected tp min 0.01 and sl max 0.015, returned %f %f", params[0].Min, params[1].Max)
+	}
+
+	params[0].Set(0.005)
+	if ag.Tpsl.TakeProfit != 0.01 {
+		t.Errorf("expected tp clamped to 0.01, returned %f", ag.Tpsl.TakeProfit)
+	}
+	params[1].Set(0.02)
+	if ag.Tpsl.StopLoss != 0.01 {
+		t.Errorf("expected sl clamped to 0.01, returned %f", ag.Tpsl.StopLoss)
+	}
+
+	rand.Seed(149)
+	move := StepMove(5)
+	for i := 0; i < 500; i++ {
+		ag = move(ag)
+		ag.mutateParam()
+		if ag.Tpsl.TakeProfit < ag.Tpsl.StopLoss {
+			t.Fatalf("expected tp at or above sl, returned %f < %f", ag.Tpsl.TakeProfit, ag.Tpsl.StopLoss)
+		}
+	}
+}
+
+func TestSensitivity_Run(t *testing.T) {
+	rand.Seed(83)
+	pc, _ := NewPairColumns(randomWalkKlines(3_000, 1), randomWalkKlines(3_000, 2))
+	ag := robustnessAgent()
+	orig, _ := ag.Marshal()
+
+	rep, err := (&Sensitivity{Steps: 1}).Run(context.Background(), ag, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if after, _ := ag.Marshal(); string(after) != string(orig) {
+		t.Errorf("expected agent untouched")
+	}
+
+	bu, _ := ag.Backtest(pc)
+	if rep.Fitness != ProfitPerDayFitness(bu) {
+		t.Errorf("expected fitness %f, returned %f", ProfitPerDayFitness(bu), rep.Fitness)
+	}
+	if rep.Robustness < 0 || rep.Robustness > 1 {
+		t.Errorf("expected robustness in [0, 1], returned %f", rep.Robustness)
+	}
+	if len(rep.Params) != 8 {
+		t.Fatalf("expected 8 params, returned %d", len(rep.Params))
+	}
+
+	for _, ps := range rep.Params {
+		for i := 1; i < len(ps.Curve); i++ {
+			if ps.Curve[i].Value <= ps.Curve[i-1].Value {
+				t.Errorf("%s curve is not in value order", ps.Name)
+			}
+		}
+	}
+
+	// bb multiplier 1.1 is a backtest of the perturbed agent
+	mult := rep.Params[7]
+	if len(mult.Curve) != 3 || mult.Curve[1].Value != 1 || mult.Curve[2].Value != 1.1 {
+		t.Fatalf("unexpected multiplier curve %v", mult.Curve)
+	}
+	c := ag.Clone()
+	c.Indicators[1].(*BB).Multiplier = 1.1
+	cbu, _ := c.Backtest(pc)
+	if mult.Curve[2].Fitness != ProfitPerDayFitness(cbu) {
+		t.Errorf("expected neighbor fitness %f, returned %f", ProfitPerDayFitness(cbu), mult.Curve[2].Fitness)
+	}
+
+	// sl 0.01 is at its min, only the upper neighbor exists
+	if sl := rep.Params[1]; len(sl.Curve) != 2 || sl.Curve[0].Value != 0.01 {
+		t.Errorf("unexpected stop loss curve %v", sl.Curve)
+	}
+}
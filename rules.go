@@ -0,0 +1,354 @@
+package agent2
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Rule is the entry rule of an agent as an expression tree over its
+// indicators, leaves (OpInd) point to Agent.Indicators by index.
+// Agents without a rule require every indicator to be active
+
+type RuleOp uint8
+
+const (
+	OpInd RuleOp = iota
+	OpAnd
+	OpOr
+	OpNot
+	OpKOfN
+)
+
+type Rule struct {
+	Op       RuleOp  `json:"op"`
+	Index    int     `json:"index,omitempty"`
+	K        int     `json:"k,omitempty"`
+	Children []*Rule `json:"children,omitempty"`
+}
+
+var (
+	ErrRuleIndexIsOutOfRange    = fmt.Errorf("rule index is out of indicator range")
+	ErrRuleNotNeedsSingleChild  = fmt.Errorf("not rule needs exactly one child")
+	ErrRuleKIsOutOfRange        = fmt.Errorf("kofn rule k is out of children range")
+	ErrRuleGroupNeedsChildren   = fmt.Errorf("and, or rules need at least one child")
+	ErrRuleLeafCantHaveChildren = fmt.Errorf("indicator rule can't have children")
+)
+
+func IndRule(index int) *Rule {
+	return &Rule{Op: OpInd, Index: index}
+}
+
+func AndRule(children ...*Rule) *Rule {
+	return &Rule{Op: OpAnd, Children: children}
+}
+
+func OrRule(children ...*Rule) *Rule {
+	return &Rule{Op: OpOr, Children: children}
+}
+
+func NotRule(child *Rule) *Rule {
+	return &Rule{Op: OpNot, Children: []*Rule{child}}
+}
+
+func KOfNRule(k int, children ...*Rule) *Rule {
+	return &Rule{Op: OpKOfN, K: k, Children: children}
+}
+
+// allRule is the explicit form of an agent without a rule
+func allRule(n int) *Rule {
+	children := make([]*Rule, n)
+	for i := 0; i < n; i++ {
+		children[i] = IndRule(i)
+	}
+	return AndRule(children...)
+}
+
+func (r *Rule) Validate(n int) error {
+	switch r.Op {
+	case OpInd:
+		if r.Index < 0 || r.Index >= n {
+			return ErrRuleIndexIsOutOfRange
+		}
+		if len(r.Children) > 0 {
+			return ErrRuleLeafCantHaveChildren
+		}
+		return nil
+	case OpAnd, OpOr:
+		if len(r.Children) == 0 {
+			return ErrRuleGroupNeedsChildren
+		}
+	case OpNot:
+		if len(r.Children) != 1 {
+			return ErrRuleNotNeedsSingleChild
+		}
+	case OpKOfN:
+		if r.K < 1 || r.K > len(r.Children) {
+			return ErrRuleKIsOutOfRange
+		}
+	default:
+		return fmt.Errorf("rule op %d is not defined", r.Op)
+	}
+
+	for _, ch := range r.Children {
+		if err := ch.Validate(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rule) Clone() *Rule {
+	if r == nil {
+		return nil
+	}
+
+	c := &Rule{Op: r.Op, Index: r.Index, K: r.K}
+	if r.Children != nil {
+		c.Children = make([]*Rule, len(r.Children))
+		for i, ch := range r.Children {
+			c.Children[i] = ch.Clone()
+		}
+	}
+	return c
+}
+
+// eval short circuits, children are evaluated in their stored order
+// which sortByCost keeps cheapest first
+func (r *Rule) eval(active func(i int) (bool, error)) (bool, error) {
+	switch r.Op {
+	case OpInd:
+		return active(r.Index)
+	case OpAnd:
+		for _, ch := range r.Children {
+			if act, err := ch.eval(active); err != nil || !act {
+				return false, err
+			}
+		}
+		return true, nil
+	case OpOr:
+		for _, ch := range r.Children {
+			if act, err := ch.eval(active); err != nil || act {
+				return act, err
+			}
+		}
+		return false, nil
+	case OpNot:
+		if len(r.Children) != 1 {
+			return false, ErrRuleNotNeedsSingleChild
+		}
+		act, err := r.Children[0].eval(active)
+		return !act && err == nil, err
+	case OpKOfN:
+		trues := 0
+		for i, ch := range r.Children {
+			act, err := ch.eval(active)
+			if err != nil {
+				return false, err
+			}
+			if act {
+				trues++
+			}
+			if trues >= r.K {
+				return true, nil
+			}
+			if trues+len(r.Children)-i-1 < r.K {
+				return false, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("rule op %d is not defined", r.Op)
+}
+
+// sortByCost orders children of every group by their evaluation cost
+// and returns the cost of the rule
+func (r *Rule) sortByCost(inds Indicators) int {
+	if r.Op == OpInd {
+		if r.Index < 0 || r.Index >= len(inds) {
+			return 0
+		}
+		return indicatorCost(inds[r.Index]) * baseLookback(inds[r.Index])
+	}
+
+	costs := make(map[*Rule]int, len(r.Children))
+	total := 0
+	for _, ch := range r.Children {
+		costs[ch] = ch.sortByCost(inds)
+		total += costs[ch]
+	}
+	sort.SliceStable(r.Children, func(i, j int) bool {
+		return costs[r.Children[i]] < costs[r.Children[j]]
+	})
+	return total
+}
+
+func (r *Rule) remap(perm []int) {
+	if r.Op == OpInd && r.Index >= 0 && r.Index < len(perm) {
+		r.Index = perm[r.Index]
+	}
+	for _, ch := range r.Children {
+		ch.remap(perm)
+	}
+}
+
+// without drops the leaves of indicator i and the groups left empty,
+// later indices shift down. KOfN groups keep K within their children,
+// nil is returned when nothing is left
+func (r *Rule) without(i int) *Rule {
+	if r.Op == OpInd {
+		if r.Index == i {
+			return nil
+		}
+		c := r.Clone()
+		if c.Index > i {
+			c.Index--
+		}
+		return c
+	}
+
+	c := &Rule{Op: r.Op, Index: r.Index, K: r.K}
+	for _, ch := range r.Children {
+		if nc := ch.without(i); nc != nil {
+			c.Children = append(c.Children, nc)
+		}
+	}
+	if len(c.Children) == 0 {
+		return nil
+	}
+	if c.Op == OpKOfN {
+		c.K = min(c.K, len(c.Children))
+	}
+	return c
+}
+
+func (r *Rule) nodes() []*Rule {
+	res := []*Rule{r}
+	for _, ch := range r.Children {
+		res = append(res, ch.nodes()...)
+	}
+	return res
+}
+
+const (
+	maxRuleGroupSize = 4
+	ruleNotProb      = 10
+)
+
+// randomRule groups the indicators into a random tree, using each
+// indicator once
+func randomRule(n int) *Rule {
+	leaves := make([]*Rule, n)
+	for i, idx := range rand.Perm(n) {
+		leaves[i] = IndRule(idx)
+	}
+	return randomGroup(leaves)
+}
+
+func randomGroupOp(size int) *Rule {
+	switch r := rand.Intn(4); {
+	case r < 2:
+		return AndRule()
+	case r < 3:
+		return OrRule()
+	}
+	return KOfNRule(rand.Intn(size) + 1)
+}
+
+func randomGroup(leaves []*Rule) *Rule {
+	if len(leaves) == 1 {
+		if rand.Intn(100) < ruleNotProb {
+			return NotRule(leaves[0])
+		}
+		return leaves[0]
+	}
+
+	groups := rand.Intn(min(maxRuleGroupSize, len(leaves))-1) + 2
+	// split leaves at groups-1 distinct cut points
+	cuts := rand.Perm(len(leaves) - 1)[:groups-1]
+	sort.Ints(cuts)
+
+	r := randomGroupOp(groups)
+	start := 0
+	for _, cut := range append(cuts, len(leaves)-1) {
+		r.Children = append(r.Children, randomGroup(leaves[start:cut+1]))
+		start = cut + 1
+	}
+	return r
+}
+
+// Mutate changes the structure of the tree in place at a random node,
+// n is the number of indicators leaves can point to
+func (r *Rule) Mutate(n int) {
+	nodes := r.nodes()
+	node := nodes[rand.Intn(len(nodes))]
+
+	switch rand.Intn(5) {
+	case 0:
+		// change group op, leaves point to another indicator
+		if node.Op == OpInd {
+			node.Index = rand.Intn(n)
+		} else if node.Op != OpNot {
+			*node = *randomGroupOp(len(node.Children)).withChildren(node.Children)
+		}
+	case 1:
+		// wrap into or unwrap from not
+		if node.Op == OpNot {
+			*node = *node.Children[0]
+		} else {
+			c := *node
+			*node = *NotRule(&c)
+		}
+	case 2:
+		if node.Op == OpKOfN {
+			node.K = rand.Intn(len(node.Children)) + 1
+		} else if node.Op != OpInd && node.Op != OpNot {
+			*node = *KOfNRule(rand.Intn(len(node.Children))+1, node.Children...)
+		}
+	case 3:
+		// group two children under a new node
+		if node.Op != OpInd && node.Op != OpNot && len(node.Children) > 2 {
+			i, j := randPair(len(node.Children))
+			grp := randomGroupOp(2).withChildren([]*Rule{node.Children[i], node.Children[j]})
+			node.Children = append(removeChildren(node.Children, i, j), grp)
+			node.fixK()
+		}
+	case 4:
+		// flatten a child group into its parent
+		if node.Op == OpAnd || node.Op == OpOr {
+			for i, ch := range node.Children {
+				if ch.Op == node.Op {
+					node.Children = append(removeChildren(node.Children, i, -1), ch.Children...)
+					break
+				}
+			}
+		}
+	}
+}
+
+func (r *Rule) withChildren(children []*Rule) *Rule {
+	r.Children = children
+	r.fixK()
+	return r
+}
+
+func (r *Rule) fixK() {
+	if r.Op == OpKOfN && (r.K < 1 || r.K > len(r.Children)) {
+		r.K = rand.Intn(len(r.Children)) + 1
+	}
+}
+
+func randPair(n int) (int, int) {
+	p := rand.Perm(n)
+	return p[0], p[1]
+}
+
+func removeChildren(children []*Rule, i int, j int) []*Rule {
+	res := make([]*Rule, 0, len(children))
+	for k, ch := range children {
+		if k != i && k != j {
+			res = append(res, ch)
+		}
+	}
+	return res
+}
@@ -0,0 +1,287 @@
+package agent2
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// constActive evaluates leaves from vals and counts evaluations
+func constActive(vals []bool, calls *int) func(i int) (bool, error) {
+	return func(i int) (bool, error) {
+		*calls++
+		return vals[i], nil
+	}
+}
+
+func TestRuleEval_And(t *testing.T) {
+	calls := 0
+	act, err := AndRule(IndRule(0), IndRule(1), IndRule(2)).eval(constActive([]bool{true, false, true}, &calls))
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if act {
+		t.Errorf("expected and to be false")
+	}
+	if calls != 2 {
+		t.Errorf("expected and to short circuit after 2 calls, called %d", calls)
+	}
+}
+
+func TestRuleEval_Or(t *testing.T) {
+	calls := 0
+	act, _ := OrRule(IndRule(0), IndRule(1), IndRule(2)).eval(constActive([]bool{false, true, false}, &calls))
+	if !act {
+		t.Errorf("expected or to be true")
+	}
+	if calls != 2 {
+		t.Errorf("expected or to short circuit after 2 calls, called %d", calls)
+	}
+}
+
+func TestRuleEval_Not(t *testing.T) {
+	calls := 0
+	act, _ := NotRule(IndRule(0)).eval(constActive([]bool{false}, &calls))
+	if !act {
+		t.Errorf("expected not to be true")
+	}
+}
+
+func TestRuleEval_KOfN(t *testing.T) {
+	vals := []bool{true, false, true, true}
+
+	for k, expected := range map[int]bool{1: true, 2: true, 3: true, 4: false} {
+		calls := 0
+		r := KOfNRule(k, IndRule(0), IndRule(1), IndRule(2), IndRule(3))
+
+		act, _ := r.eval(constActive(vals, &calls))
+		if act != expected {
+			t.Errorf("%d of 4 expected %v, returned %v", k, expected, act)
+		}
+	}
+}
+
+func TestRuleEval_KOfNShortCircuit(t *testing.T) {
+	calls := 0
+	r := KOfNRule(3, IndRule(0), IndRule(1), IndRule(2), IndRule(3))
+
+	act, _ := r.eval(constActive([]bool{false, false, true, true}, &calls))
+	if act {
+		t.Errorf("expected 3 of 4 to be false")
+	}
+	if calls != 2 {
+		t.Errorf("expected kofn to short circuit after 2 calls, called %d", calls)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	cases := map[error]*Rule{
+		ErrRuleIndexIsOutOfRange:    AndRule(IndRule(0), IndRule(3)),
+		ErrRuleNotNeedsSingleChild:  {Op: OpNot, Children: []*Rule{IndRule(0), IndRule(1)}},
+		ErrRuleKIsOutOfRange:        KOfNRule(3, IndRule(0), IndRule(1)),
+		ErrRuleGroupNeedsChildren:   OrRule(),
+		ErrRuleLeafCantHaveChildren: {Op: OpInd, Children: []*Rule{IndRule(0)}},
+	}
+	for expected, r := range cases {
+		if err := r.Validate(3); err != expected {
+			t.Errorf("expected %v to be raised, raised %v", expected, err)
+		}
+	}
+	if err := (&Rule{Op: RuleOp(9)}).Validate(3); err == nil {
+		t.Errorf("expected error for undefined op, nothing raised")
+	}
+}
+
+func leafIndices(r *Rule) []int {
+	res := make([]int, 0)
+	for _, node := range r.nodes() {
+		if node.Op == OpInd {
+			res = append(res, node.Index)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func TestRandomRule_UsesEachIndicatorOnce(t *testing.T) {
+	for i := 0; i < 1_000; i++ {
+		n := i%12 + 1
+		r := randomRule(n)
+
+		if err := r.Validate(n); err != nil {
+			t.Errorf("random rule is not valid, %v", err)
+		}
+		for j, idx := range leafIndices(r) {
+			if idx != j {
+				t.Errorf("random rule leaves %v do not cover %d indicators", leafIndices(r), n)
+				break
+			}
+		}
+	}
+}
+
+func TestRuleMutate_StaysValid(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		n := i%10 + 1
+		r := randomRule(n)
+
+		for j := 0; j < 50; j++ {
+			r.Mutate(n)
+			if err := r.Validate(n); err != nil {
+				t.Errorf("mutated rule is not valid, %v", err)
+			}
+		}
+	}
+}
+
+func TestRuleMutate_ChangesRule(t *testing.T) {
+	r := randomRule(8)
+	before, _ := json.Marshal(r)
+
+	changed := false
+	for i := 0; i < 100 && !changed; i++ {
+		r.Mutate(8)
+		after, _ := json.Marshal(r)
+		changed = string(before) != string(after)
+	}
+	if !changed {
+		t.Errorf("rule does not change with mutate")
+	}
+}
+
+func TestRuleSortByCost(t *testing.T) {
+	bb := &BB{Period: 200}
+	mfi := &MFI{Period: 10}
+	r := OrRule(IndRule(0), AndRule(IndRule(1), IndRule(1)))
+
+	cost := r.sortByCost(Indicators{bb, mfi})
+	if cost != 5*200+2*10 {
+		t.Errorf("unexpected cost %d", cost)
+	}
+	if r.Children[0].Op != OpAnd {
+		t.Errorf("cheaper and group should be evaluated first")
+	}
+}
+
+func TestRuleWithout(t *testing.T) {
+	r := KOfNRule(3, IndRule(0), NotRule(IndRule(1)), AndRule(IndRule(1), IndRule(2)))
+
+	w := r.without(1)
+	expected := KOfNRule(2, IndRule(0), AndRule(IndRule(1)))
+	if got, _ := json.Marshal(w); string(got) != string(mustMarshal(expected)) {
+		t.Errorf("expected %s, returned %s", mustMarshal(expected), got)
+	}
+	if r.Children[1].Children[0].Index != 1 {
+		t.Errorf("expected source rule untouched")
+	}
+	if w := NotRule(IndRule(0)).without(0); w != nil {
+		t.Errorf("expected nothing left, returned %v", w)
+	}
+}
+
+func mustMarshal(r *Rule) []byte {
+	pload, _ := json.Marshal(r)
+	return pload
+}
+
+func TestAgentRule_RoundTrip(t *testing.T) {
+	ag := RandomAgent()
+	ag.Rule = KOfNRule(1, IndRule(0), NotRule(IndRule(1)))
+
+	pload, _ := ag.Marshal()
+	ag2, err := UnmarshalAgent(pload)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	pload2, _ := ag2.Marshal()
+	if string(pload) != string(pload2) {
+		t.Errorf("expected payload %s, received %s", pload, pload2)
+	}
+}
+
+func TestAgentRule_UnmarshalInvalid(t *testing.T) {
+	ag := RandomAgent()
+	ag.Rule = AndRule(IndRule(len(ag.Indicators)))
+
+	pload, _ := ag.Marshal()
+	if _, err := UnmarshalAgent(pload); err != ErrRuleIndexIsOutOfRange {
+		t.Errorf("expected %v to be raised, raised %v", ErrRuleIndexIsOutOfRange, err)
+	}
+}
+
+func TestOpenPos_OrRule(t *testing.T) {
+	ag := RandomAgent()
+
+	active := &RSI{Mon: Close1, ValuePos: Above, TargetVal: 10, Period: 250}
+	inactive := &RSI{Mon: Close1, ValuePos: Below, TargetVal: 10, Period: 250}
+	ag.Indicators = Indicators{inactive, active}
+
+	ag.Rule = nil
+	if open, _ := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil); open {
+		t.Errorf("expected openpos without rule to return false, returned true")
+	}
+
+	ag.Rule = OrRule(IndRule(0), IndRule(1))
+	if open, _ := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil); !open {
+		t.Errorf("expected openpos with or rule to return true, returned false")
+	}
+
+	ag.Rule = NotRule(IndRule(1))
+	if open, _ := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil); open {
+		t.Errorf("expected openpos with not rule to return false, returned true")
+	}
+}
+
+func TestOpenPos_RuleIndexOutOfRange(t *testing.T) {
+	ag := RandomAgent()
+	ag.Rule = IndRule(len(ag.Indicators))
+
+	if _, err := ag.OpenPos(dummyKlines(250), dummyKlines(250), nil); err != ErrRuleIndexIsOutOfRange {
+		t.Errorf("expected %v to be raised, raised %v", ErrRuleIndexIsOutOfRange, err)
+	}
+}
+
+func TestAgentSortIndicators_KeepsRule(t *testing.T) {
+	ag := RandomAgent()
+	bb := &BB{Mon: Close1, Period: 20, Multiplier: 1}
+	mfi := &MFI{Leg: Leg1, Period: 20}
+	ag.Indicators = Indicators{bb, mfi}
+	ag.Rule = AndRule(IndRule(0), NotRule(IndRule(1)))
+
+	ag.sortIndicators()
+	if ag.Indicators[0] != mfi {
+		t.Errorf("indicators are not sorted by cost")
+	}
+	// mfi is now at 0, the not group still wraps it
+	for _, node := range ag.Rule.nodes() {
+		if node.Op == OpNot && node.Children[0].Index != 0 {
+			t.Errorf("rule is not remapped after sort")
+		}
+	}
+}
+
+func TestAgentMutateRule(t *testing.T) {
+	for i := 0; i < 1_000; i++ {
+		ag := RandomAgent()
+		ag.MutateRule()
+
+		if ag.Rule == nil {
+			t.Errorf("mutate rule should create the explicit rule")
+		} else if err := ag.Rule.Validate(len(ag.Indicators)); err != nil {
+			t.Errorf("mutated agent rule is not valid, %v", err)
+		}
+	}
+}
+
+func TestRandomAgent_AssignsRules(t *testing.T) {
+	withRule := 0
+	for i := 0; i < 1_000; i++ {
+		if RandomAgent().Rule != nil {
+			withRule++
+		}
+	}
+	if withRule == 0 || withRule == 1_000 {
+		t.Errorf("expected some random agents to have rules, %d have", withRule)
+	}
+}
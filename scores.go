@@ -0,0 +1,133 @@
+package agent2
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// Scorer is implemented by indicators that can tell how far past their
+// threshold the last value is, in their own unit (stddevs for bb, points
+// for rsi). Score is positive iff the indicator is active
+type Scorer interface {
+	Score(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error)
+}
+
+func (pf *pairFrames) score(ind Indicator) (float64, error) {
+	w1, w2, err := pf.window(ind)
+	if err != nil {
+		return 0, err
+	}
+
+	if sc, ok := ind.(Scorer); ok {
+		return sc.Score(w1, w2)
+	}
+	act, err := ind.Active(w1, w2)
+	if err != nil {
+		return 0, err
+	}
+	if act {
+		return indicatorScoreScale(ind), nil
+	}
+	return -indicatorScoreScale(ind), nil
+}
+
+// Scores returns the raw score of every indicator, in indicator order
+func (ag *Agent) Scores(klns1 []klines.Kline, klns2 []klines.Kline) ([]float64, error) {
+	if len(klns1) < minActivationKlineLength || len(klns2) < minActivationKlineLength {
+		return nil, ErrKlinesAreBelowMinActivationKlineLength
+	}
+
+	return ag.scores(newPairFrames(klns1, klns2))
+}
+
+func (ag *Agent) scores(frames pairView) ([]float64, error) {
+	res := make([]float64, len(ag.Indicators))
+	for i, ind := range ag.Indicators {
+		sc, err := frames.score(ind)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = sc
+	}
+	return res, nil
+}
+
+// normScore squashes a score to (-1, 1) with its kind's scale, a value
+// sitting exactly on its threshold is inactive so it maps below 0
+func normScore(score float64, scale float64) float64 {
+	if score == 0 {
+		return -epsilon
+	}
+	return math.Tanh(score / scale)
+}
+
+// Confidence aggregates normalized indicator scores through the entry
+// rule, and is min for and, max for or, negation for not and the k-th
+// largest for k of n. It is positive iff the entry rule holds, backoff
+// is not taken into account
+func (ag *Agent) Confidence(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	scores, err := ag.Scores(klns1, klns2)
+	if err != nil {
+		return 0, err
+	}
+
+	norm := make([]float64, len(scores))
+	for i, sc := range scores {
+		norm[i] = normScore(sc, indicatorScoreScale(ag.Indicators[i]))
+	}
+
+	rule := ag.Rule
+	if rule == nil {
+		rule = allRule(len(ag.Indicators))
+	}
+	return rule.confidence(norm)
+}
+
+func (r *Rule) confidence(norm []float64) (float64, error) {
+	if r.Op == OpInd {
+		if r.Index < 0 || r.Index >= len(norm) {
+			return 0, ErrRuleIndexIsOutOfRange
+		}
+		return norm[r.Index], nil
+	}
+
+	vals := make([]float64, len(r.Children))
+	for i, ch := range r.Children {
+		v, err := ch.confidence(norm)
+		if err != nil {
+			return 0, err
+		}
+		vals[i] = v
+	}
+
+	switch r.Op {
+	case OpAnd:
+		// empty and holds, like an agent without indicators
+		res := 1.0
+		for _, v := range vals {
+			res = math.Min(res, v)
+		}
+		return res, nil
+	case OpOr:
+		res := -1.0
+		for _, v := range vals {
+			res = math.Max(res, v)
+		}
+		return res, nil
+	case OpNot:
+		if len(vals) != 1 {
+			return 0, ErrRuleNotNeedsSingleChild
+		}
+		return -vals[0], nil
+	case OpKOfN:
+		if r.K < 1 || r.K > len(vals) {
+			return 0, ErrRuleKIsOutOfRange
+		}
+		sort.Sort(sort.Reverse(sort.Float64Slice(vals)))
+		return vals[r.K-1], nil
+	}
+	return 0, fmt.Errorf("rule op %d is not defined", r.Op)
+}
@@ -0,0 +1,196 @@
+package agent2
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// randomWalkKlines are 1m klines of a random walk, deterministic for a seed
+func randomWalkKlines(length int, seed int64) []klines.Kline {
+	rnd := rand.New(rand.NewSource(seed))
+	res := make([]klines.Kline, length)
+
+	price := 100.0
+	for i := 0; i < length; i++ {
+		open := price
+		price *= 1 + rnd.NormFloat64()*0.002
+		high := math.Max(open, price) * (1 + rnd.Float64()*0.001)
+		low := math.Min(open, price) * (1 - rnd.Float64()*0.001)
+		vol := 1000 + rnd.Float64()*1000
+
+		res[i] = klines.Kline{
+			OpenTime:       int64(i) * 60_000,
+			Open:           open,
+			High:           high,
+			Low:            low,
+			Close:          price,
+			Volume:         vol,
+			TakerBuyVolume: vol * rnd.Float64(),
+			NumberOfTrades: int64(100 + rnd.Intn(100)),
+			CloseTime:      int64(i+1)*60_000 - 1,
+			IsFinal:        true,
+		}
+	}
+	return res
+}
+
+func TestScore_SignMatchesActive(t *testing.T) {
+	klns1, klns2 := randomWalkKlines(250, 1), randomWalkKlines(250, 2)
+
+	for _, spec := range registeredSpecs() {
+		for i := 0; i < 500; i++ {
+			ind := spec.Random()
+			sc, ok := ind.(Scorer)
+			if !ok {
+				break
+			}
+
+			lb := ind.Lookback()
+			act, _ := ind.Active(klns1[250-lb:], klns2[250-lb:])
+			score, err := sc.Score(klns1[250-lb:], klns2[250-lb:])
+			if err != nil {
+				t.Errorf("expected no error but raised %v", err)
+			}
+			if act != (score > 0) {
+				t.Errorf("%s score %.4f does not match active %v", ind.Kind(), score, act)
+			}
+		}
+	}
+}
+
+func TestBB_Score_InSigmas(t *testing.T) {
+	bb := &BB{
+		Mon:        Close1,
+		ValuePos:   Above,
+		Line:       Middle,
+		Period:     6,
+		Multiplier: 2,
+	}
+
+	klns1, klns2 := dummyKlines(6), dummyKlines(6)
+	for i := 0; i < 6; i++ {
+		klns1[i].Close = float64(i)
+	}
+
+	// (5 - 2.5) / 1.7078
+	sc, err := bb.Score(klns1, klns2)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := 1.4639
+	if math.Round(sc*10_000.0)/10_000.0 != expected {
+		t.Errorf("expected %.4f as score, returned %.4f", expected, sc)
+	}
+}
+
+func TestRSI_Score_InPoints(t *testing.T) {
+	rsi := &RSI{
+		Mon:       Close1,
+		ValuePos:  Below,
+		TargetVal: 60,
+		Period:    5,
+	}
+
+	klns1, klns2 := dummyKlines(5), dummyKlines(5)
+	for i, c := range []float64{1, 2, 3, 4, 3} {
+		klns1[i].Close = c
+	}
+
+	sc, _ := rsi.Score(klns1, klns2)
+	expected := -15.0
+	if sc != expected {
+		t.Errorf("expected %.2f as score, returned %.2f", expected, sc)
+	}
+}
+
+func TestScore_UndefinedValuePos(t *testing.T) {
+	rsi := &RSI{Mon: Close1, ValuePos: ValuePos(9), Period: 5}
+
+	if _, err := rsi.Score(dummyKlines(5), dummyKlines(5)); err == nil {
+		t.Errorf("expected error nothing raised")
+	}
+}
+
+func TestAgentScores_Len(t *testing.T) {
+	ag := RandomAgent()
+
+	scores, err := ag.Scores(dummyKlines(250), dummyKlines(250))
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(scores) != len(ag.Indicators) {
+		t.Errorf("expected %d scores, returned %d", len(ag.Indicators), len(scores))
+	}
+}
+
+func TestAgentScores_LowNumberOfKlines(t *testing.T) {
+	if _, err := RandomAgent().Scores(dummyKlines(10), dummyKlines(10)); err != ErrKlinesAreBelowMinActivationKlineLength {
+		t.Errorf("expected %v error but raised %v", ErrKlinesAreBelowMinActivationKlineLength, err)
+	}
+}
+
+func TestAgentConfidence_SignMatchesOpenPos(t *testing.T) {
+	rand.Seed(3)
+	klns1, klns2 := randomWalkKlines(300, 3), randomWalkKlines(300, 4)
+
+	opens := 0
+	for i := 0; i < 2_000; i++ {
+		ag := RandomAgent()
+		ag.Indicators = ag.Indicators[:1+i%3]
+		ag.Rule = nil
+		if i%2 == 0 {
+			ag.Rule = randomRule(len(ag.Indicators))
+		}
+
+		open, _ := ag.OpenPos(klns1, klns2, nil)
+		conf, err := ag.Confidence(klns1, klns2)
+		if err != nil {
+			t.Errorf("expected no error but raised %v", err)
+		}
+		if open != (conf > 0) {
+			t.Errorf("confidence %.4f does not match openpos %v", conf, open)
+		}
+		if conf <= -1 || conf >= 1 {
+			t.Errorf("confidence %.4f is outside of (-1, 1)", conf)
+		}
+		if open {
+			opens++
+		}
+	}
+	if opens == 0 {
+		t.Errorf("expected some agents to open positions")
+	}
+}
+
+func TestRuleConfidence(t *testing.T) {
+	norm := []float64{0.5, -0.2, 0.9}
+
+	cases := map[*Rule]float64{
+		AndRule(IndRule(0), IndRule(2)):                 0.5,
+		OrRule(IndRule(0), IndRule(1)):                  0.5,
+		NotRule(IndRule(1)):                             0.2,
+		KOfNRule(2, IndRule(0), IndRule(1), IndRule(2)): 0.5,
+	}
+	for r, expected := range cases {
+		conf, err := r.confidence(norm)
+		if err != nil {
+			t.Errorf("expected no error but raised %v", err)
+		}
+		if conf != expected {
+			t.Errorf("expected %.2f confidence, returned %.2f", expected, conf)
+		}
+	}
+}
+
+func TestNormScore_OnThresholdIsInactive(t *testing.T) {
+	if normScore(0, 1) >= 0 {
+		t.Errorf("score on threshold should be inactive")
+	}
+	if -normScore(0, 1) <= 0 {
+		t.Errorf("negated score on threshold should be active")
+	}
+}
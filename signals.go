@@ -0,0 +1,460 @@
+package agent2
+
+import (
+	"errors"
+	"math"
+)
+
+// Signals computes entry signals over a whole history at once. Rolling
+// indicators compute every window from running sums in a single pass,
+// the sums are restarted every window length and carry a bound of
+// their rounding error. Bars whose value can't be told apart from the
+// threshold within that bound are evaluated exactly like OpenPos, as
+// are indicators without a rolling form (obv, smoothed rsi, spread,
+// higher timeframes and custom kinds)
+
+const (
+	sigUnknown int8 = iota
+	sigInactive
+	sigActive
+)
+
+const (
+	// machine epsilon of float64
+	float64Eps = 0x1p-52
+	// bb values are compared within a relative band, std is taken from
+	// a difference of sums so its band is wider
+	bbMeanTol = 1e-9
+	bbStdTol  = 1e-5
+)
+
+// rollingIndicator returns the state of the indicator for the window
+// ending at every bar, nil when its configuration has no rolling form
+type rollingIndicator interface {
+	rollingStates(pc *PairColumns) []int8
+}
+
+// Signals returns what OpenPos returns for the klines up to and
+// including every bar of pc, backoff is not applied. Bars before min
+// activation length, or where an indicator is still below its lookback,
+// are false
+func (ag *Agent) Signals(pc *PairColumns) ([]bool, error) {
+	sc := ag.newSignalScanner(pc)
+
+	res := make([]bool, pc.Len())
+	for i := range res {
+		sig, err := sc.at(i)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = sig
+	}
+	return res, nil
+}
+
+// signalScanner evaluates the entry signal at single bars, rolling
+// states are computed on first use as short circuiting skips most
+// indicators of most agents
+type signalScanner struct {
+	ag      *Agent
+	pc      *PairColumns
+	rule    *Rule
+	states  [][]int8
+	rolled  []bool
+	results []int8
+}
+
+func (ag *Agent) newSignalScanner(pc *PairColumns) *signalScanner {
+	rule := ag.Rule
+	if rule == nil {
+		rule = allRule(len(ag.Indicators))
+	}
+
+	return &signalScanner{
+		ag:      ag,
+		pc:      pc,
+		rule:    rule,
+		states:  make([][]int8, len(ag.Indicators)),
+		rolled:  make([]bool, len(ag.Indicators)),
+		results: make([]int8, len(ag.Indicators)),
+	}
+}
+
+func (sc *signalScanner) rollingState(k int, i int) int8 {
+	if !sc.rolled[k] {
+		sc.rolled[k] = true
+		ind := sc.ag.Indicators[k]
+		if ri, ok := ind.(rollingIndicator); ok && indicatorTimeframe(ind) == 1 {
+			sc.states[k] = ri.rollingStates(sc.pc)
+		}
+	}
+	if sc.states[k] == nil {
+		return sigUnknown
+	}
+	return sc.states[k][i]
+}
+
+func (sc *signalScanner) at(i int) (bool, error) {
+	if i+1 < minActivationKlineLength {
+		return false, nil
+	}
+
+	inds := sc.ag.Indicators
+	clear(sc.results)
+	frames := &colFrames{pc: sc.pc, end: i + 1}
+
+	act, err := sc.rule.eval(func(k int) (bool, error) {
+		if k < 0 || k >= len(inds) {
+			return false, ErrRuleIndexIsOutOfRange
+		}
+		if sc.results[k] == sigUnknown {
+			sc.results[k] = sc.rollingState(k, i)
+		}
+		if sc.results[k] == sigUnknown {
+			act, err := frames.active(inds[k])
+			if err != nil {
+				return false, err
+			}
+			sc.results[k] = sigInactive
+			if act {
+				sc.results[k] = sigActive
+			}
+		}
+		return sc.results[k] == sigActive, nil
+	})
+	if errors.Is(err, ErrKlinesAreBelowIndicatorLookback) {
+		return false, nil
+	}
+	return act, err
+}
+
+// rollingSums sums every w values ending at each index. errs bounds
+// the difference to a sum taken in one pass over the window
+func rollingSums(vals []float64, w int) ([]float64, []float64) {
+	n := len(vals)
+	sums, errs := make([]float64, n), make([]float64, n)
+
+	var s, abs float64
+	for end := w; end <= n; end++ {
+		from := end - w
+		if from%w == 0 {
+			s, abs = 0, 0
+			for _, v := range vals[from:end] {
+				s += v
+				abs += math.Abs(v)
+			}
+		} else {
+			s += vals[end-1] - vals[from-1]
+			abs += math.Abs(vals[end-1]) + math.Abs(vals[from-1])
+		}
+		sums[end-1] = s
+		errs[end-1] = float64(4*w+4) * float64Eps * abs
+	}
+	return sums, errs
+}
+
+// rollingNonZeros counts non zero values of every w values ending at
+// each index
+func rollingNonZeros(vals []float64, w int) []int {
+	res := make([]int, len(vals))
+
+	cnt := 0
+	for i, v := range vals {
+		if v != 0 {
+			cnt++
+		}
+		if i >= w && vals[i-w] != 0 {
+			cnt--
+		}
+		res[i] = cnt
+	}
+	return res
+}
+
+// boundsState compares a value known to be in [lo, hi] to the target
+func boundsState(valPos ValuePos, lo float64, hi float64, target float64) int8 {
+	slack := 1e-12 * (1 + math.Abs(lo) + math.Abs(hi))
+	lo, hi = lo-slack, hi+slack
+
+	switch valPos {
+	case Above:
+		if lo > target {
+			return sigActive
+		}
+		if hi <= target {
+			return sigInactive
+		}
+	case Below:
+		if hi < target {
+			return sigActive
+		}
+		if lo >= target {
+			return sigInactive
+		}
+	}
+	return sigUnknown
+}
+
+func validValuePos(valPos ValuePos) bool {
+	return valPos == Above || valPos == Below
+}
+
+func (bb *BB) lineCoef() (float64, bool) {
+	switch bb.Line {
+	case Lower:
+		return -bb.Multiplier, true
+	case Middle:
+		return 0, true
+	case Upper:
+		return bb.Multiplier, true
+	}
+	return 0, false
+}
+
+// rollingStates keeps sums of values and squares shifted by the first
+// value of the window they were restarted at, so squares stay small
+func (bb *BB) rollingStates(pc *PairColumns) []int8 {
+	coef, ok := bb.lineCoef()
+	if !ok || !validValuePos(bb.ValuePos) || bb.Mon == Spread || bb.Period < 1 {
+		return nil
+	}
+	col, err := pc.monColumn(bb.Mon)
+	if err != nil {
+		return nil
+	}
+
+	p, n := bb.Period, len(col)
+	fp := float64(p)
+	res := make([]int8, n)
+
+	var ref, s, s2 float64
+	for end := p; end <= n; end++ {
+		from := end - p
+		if from%p == 0 {
+			ref, s, s2 = col[from], 0, 0
+			for _, v := range col[from:end] {
+				s += v - ref
+				s2 += (v - ref) * (v - ref)
+			}
+		} else {
+			d0, d1 := col[from-1]-ref, col[end-1]-ref
+			s += d1 - d0
+			s2 += d1*d1 - d0*d0
+		}
+
+		ws, ws2, last := s, s2, col[end-1]
+		if bb.Mon.windowDependent() && from > 0 {
+			first := pc.windowFirst(bb.Mon, from)
+			d0, d1 := col[from]-ref, first-ref
+			ws += d1 - d0
+			ws2 += d1*d1 - d0*d0
+			if p == 1 {
+				last = first
+			}
+		}
+
+		mn := ref + ws/fp
+		rms := math.Sqrt(math.Max(0, ws2/fp))
+		std := math.Sqrt(math.Max(0, ws2/fp-(ws/fp)*(ws/fp)))
+		margin := last - (mn + coef*std)
+		band := bbMeanTol*(math.Abs(last)+math.Abs(mn)+rms) + math.Abs(coef)*bbStdTol*rms
+
+		res[end-1] = boundsState(bb.ValuePos, margin-band, margin+band, 0)
+	}
+	return res
+}
+
+// ratioIndexBounds bounds 100 - 100 / (1 + pos / neg), the form of both
+// rsi and mfi, from sums with rounding errors. Counts of non zero terms
+// tell the exact edge cases apart
+func ratioIndexBounds(pos float64, posErr float64, posCnt int,
+	neg float64, negErr float64, negCnt int) (float64, float64) {
+	if negCnt == 0 {
+		return 100, 100
+	}
+	if posCnt == 0 {
+		return 0, 0
+	}
+
+	index := func(r float64) float64 {
+		return 100 - (100 / (1 + r))
+	}
+	lo := index(math.Max(0, pos-posErr) / (neg + negErr))
+	if neg-negErr <= 0 {
+		return lo, 100
+	}
+	return lo, index((pos + posErr) / (neg - negErr))
+}
+
+// flowBounds bounds the ratio index over windows of w flows
+func flowBounds(posFlows []float64, negFlows []float64, w int) ([]float64, []float64) {
+	n := len(posFlows)
+	lo, hi := make([]float64, n), make([]float64, n)
+
+	ps, pe := rollingSums(posFlows, w)
+	ns, ne := rollingSums(negFlows, w)
+	pc, nc := rollingNonZeros(posFlows, w), rollingNonZeros(negFlows, w)
+	for i := w - 1; i < n; i++ {
+		lo[i], hi[i] = ratioIndexBounds(ps[i], pe[i], pc[i], ns[i], ne[i], nc[i])
+	}
+	return lo, hi
+}
+
+func (rsi *RSI) rollingStates(pc *PairColumns) []int8 {
+	if rsi.Smoothing != Simple || !validValuePos(rsi.ValuePos) || rsi.Mon == Spread || rsi.Period < 2 {
+		return nil
+	}
+	col, err := pc.monColumn(rsi.Mon)
+	if err != nil {
+		return nil
+	}
+
+	n := len(col)
+	gains, losses := make([]float64, n), make([]float64, n)
+	for i := 1; i < n; i++ {
+		if d := col[i] - col[i-1]; d > 0 {
+			gains[i] = d
+		} else {
+			losses[i] = -d
+		}
+	}
+
+	// a window of period values has period-1 diffs
+	p := rsi.Period
+	gs, ge := rollingSums(gains, p-1)
+	ls, le := rollingSums(losses, p-1)
+	gc, lc := rollingNonZeros(gains, p-1), rollingNonZeros(losses, p-1)
+
+	res := make([]int8, n)
+	for end := p; end <= n; end++ {
+		i, from := end-1, end-p
+		g, l, ng, nl := gs[i], ls[i], gc[i], lc[i]
+
+		if rsi.Mon.windowDependent() && from > 0 {
+			// the first diff of the window starts from its open
+			j := from + 1
+			g, l = g-gains[j], l-losses[j]
+			ng, nl = ng-nonZero(gains[j]), nl-nonZero(losses[j])
+
+			if d := col[j] - pc.windowFirst(rsi.Mon, from); d > 0 {
+				g, ng = g+d, ng+1
+			} else if d < 0 {
+				l, nl = l-d, nl+1
+			}
+		}
+
+		lo, hi := ratioIndexBounds(g, ge[i], ng, l, le[i], nl)
+		res[i] = boundsState(rsi.ValuePos, lo, hi, rsi.TargetVal)
+	}
+	return res
+}
+
+func nonZero(v float64) int {
+	if v != 0 {
+		return 1
+	}
+	return 0
+}
+
+// legBounds combines per leg bounds the way legValue based indicators
+// combine their leg values
+func legBounds(leg Leg, pc *PairColumns, calc func(lc *LegColumns) ([]float64, []float64)) ([]float64, []float64, bool) {
+	switch leg {
+	case Leg1:
+		lo, hi := calc(&pc.Leg1)
+		return lo, hi, true
+	case Leg2:
+		lo, hi := calc(&pc.Leg2)
+		return lo, hi, true
+	case LegR:
+		lo1, hi1 := calc(&pc.Leg1)
+		lo2, hi2 := calc(&pc.Leg2)
+		for i := range lo1 {
+			lo1[i], hi1[i] = lo1[i]-hi2[i], hi1[i]-lo2[i]
+		}
+		return lo1, hi1, true
+	}
+	return nil, nil, false
+}
+
+func (mfi *MFI) rollingStates(pc *PairColumns) []int8 {
+	if !validValuePos(mfi.ValuePos) || mfi.Period < 2 {
+		return nil
+	}
+
+	lo, hi, ok := legBounds(mfi.Leg, pc, func(lc *LegColumns) ([]float64, []float64) {
+		tps := quoteVolColumn(lc)
+		pos, neg := make([]float64, len(tps)), make([]float64, len(tps))
+		for i := 1; i < len(tps); i++ {
+			tp, prevTp := typicalPriceAt(lc, i), typicalPriceAt(lc, i-1)
+			if tp > prevTp {
+				pos[i] = tps[i]
+			} else if tp < prevTp {
+				neg[i] = tps[i]
+			}
+		}
+		return flowBounds(pos, neg, mfi.Period-1)
+	})
+	if !ok {
+		return nil
+	}
+
+	res := make([]int8, len(lo))
+	for i := mfi.Period - 1; i < len(lo); i++ {
+		l, h := lo[i], hi[i]
+		if mfi.Leg == LegR {
+			l, h = 50+l/2, 50+h/2
+		}
+		res[i] = boundsState(mfi.ValuePos, l, h, mfi.TargetVal)
+	}
+	return res
+}
+
+func typicalPriceAt(lc *LegColumns, i int) float64 {
+	return (lc.High[i] + lc.Low[i] + lc.Close[i]) / 3.0
+}
+
+// vwapDevBounds bounds (close - vwap) / vwap, it decreases in vwap
+// for positive prices
+func vwapDevBounds(lc *LegColumns, w int) ([]float64, []float64) {
+	n := len(lc.Close)
+	lo, hi := make([]float64, n), make([]float64, n)
+
+	pvs, pve := rollingSums(quoteVolColumn(lc), w)
+	vs, ve := rollingSums(lc.Volume, w)
+	vc := rollingNonZeros(lc.Volume, w)
+	for i := w - 1; i < n; i++ {
+		if vc[i] == 0 {
+			continue
+		}
+		c := lc.Close[i]
+		if pvs[i]-pve[i] < 0 || vs[i]-ve[i] <= 0 || c < 0 {
+			lo[i], hi[i] = math.Inf(-1), math.Inf(1)
+			continue
+		}
+		vwapLo := (pvs[i] - pve[i]) / (vs[i] + ve[i])
+		vwapHi := (pvs[i] + pve[i]) / (vs[i] - ve[i])
+		lo[i] = (c - vwapHi) / (vwapHi + epsilon)
+		hi[i] = (c - vwapLo) / (vwapLo + epsilon)
+	}
+	return lo, hi
+}
+
+func (vw *VWAP) rollingStates(pc *PairColumns) []int8 {
+	if !validValuePos(vw.ValuePos) || vw.Period < 1 {
+		return nil
+	}
+
+	lo, hi, ok := legBounds(vw.Leg, pc, func(lc *LegColumns) ([]float64, []float64) {
+		return vwapDevBounds(lc, vw.Period)
+	})
+	if !ok {
+		return nil
+	}
+
+	res := make([]int8, len(lo))
+	for i := vw.Period - 1; i < len(lo); i++ {
+		res[i] = boundsState(vw.ValuePos, lo[i], hi[i], vw.TargetDev)
+	}
+	return res
+}
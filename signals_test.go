@@ -0,0 +1,165 @@
+package agent2
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// expectSignals checks Signals against OpenPos at every bar
+func expectSignals(t *testing.T, ag *Agent, klns1 []klines.Kline, klns2 []klines.Kline) {
+	t.Helper()
+	pc, _ := NewPairColumns(klns1, klns2)
+
+	sigs, err := ag.Signals(pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for i := range sigs {
+		expected := false
+		if i+1 >= minActivationKlineLength {
+			expected, _ = ag.OpenPos(klns1[:i+1], klns2[:i+1], nil)
+		}
+		if sigs[i] != expected {
+			t.Fatalf("bar %d: expected %v, returned %v", i, expected, sigs[i])
+		}
+	}
+}
+
+func TestSignals_MatchOpenPos_RandomAgents(t *testing.T) {
+	rand.Seed(11)
+	klns1, klns2 := randomWalkKlines(700, 3), randomWalkKlines(700, 4)
+
+	for i := 0; i < 30; i++ {
+		expectSignals(t, RandomAgent(), klns1, klns2)
+	}
+}
+
+func TestSignals_MatchOpenPos_EachIndicator(t *testing.T) {
+	rand.Seed(12)
+	klns1, klns2 := randomWalkKlines(600, 5), randomWalkKlines(600, 6)
+	pc, _ := NewPairColumns(klns1, klns2)
+
+	for mon := Close1; mon <= QuoteVolR; mon++ {
+		for _, ind := range []Indicator{
+			&BB{Mon: mon, ValuePos: Above, Line: Upper, Period: 20, Multiplier: 1},
+			&BB{Mon: mon, ValuePos: Below, Line: Middle, Period: 35},
+			&RSI{Mon: mon, ValuePos: Above, TargetVal: 50, Period: 14},
+			&RSI{Mon: mon, ValuePos: Below, TargetVal: 40, Period: 30, Smoothing: Wilder, Length: 10},
+		} {
+			ag := RandomAgent()
+			ag.Rule = nil
+			ag.Indicators = Indicators{ind}
+			expectSignals(t, ag, klns1, klns2)
+		}
+	}
+
+	for _, leg := range []Leg{Leg1, Leg2, LegR} {
+		for _, ind := range []Indicator{
+			&MFI{Leg: leg, ValuePos: Above, TargetVal: 50, Period: 14},
+			&OBV{Leg: leg, ValuePos: Below, TargetSlope: 0, Period: 20},
+			&VWAP{Leg: leg, ValuePos: Above, TargetDev: 0, Period: 30},
+		} {
+			ag := RandomAgent()
+			ag.Rule = nil
+			ag.Indicators = Indicators{ind}
+			expectSignals(t, ag, klns1, klns2)
+
+			if ri, ok := ind.(rollingIndicator); ok && ri.rollingStates(pc) == nil {
+				t.Errorf("expected %s to have rolling states", ind.Kind())
+			}
+		}
+	}
+}
+
+func TestSignals_MatchOpenPos_FlatKlines(t *testing.T) {
+	klns := randomWalkKlines(400, 7)
+	for i := range klns {
+		klns[i].Open, klns[i].High, klns[i].Low, klns[i].Close = 100, 100, 100, 100
+		if i%3 == 0 {
+			klns[i].Volume = 0
+		}
+	}
+
+	for _, ind := range []Indicator{
+		&BB{Mon: Close1, ValuePos: Below, Line: Lower, Period: 20, Multiplier: 1},
+		&BB{Mon: CloseR, ValuePos: Above, Line: Middle, Period: 20},
+		&RSI{Mon: Close1, ValuePos: Above, TargetVal: 50, Period: 14},
+		&MFI{Leg: Leg1, ValuePos: Above, TargetVal: 50, Period: 14},
+		&VWAP{Leg: LegR, ValuePos: Below, TargetDev: 0.001, Period: 10},
+	} {
+		ag := RandomAgent()
+		ag.Rule = nil
+		ag.Indicators = Indicators{ind}
+		expectSignals(t, ag, klns, klns)
+	}
+}
+
+func TestSignals_MatchOpenPos_RuleAndTimeframe(t *testing.T) {
+	klns1, klns2 := randomWalkKlines(900, 8), randomWalkKlines(900, 9)
+
+	ag := RandomAgent()
+	ag.Indicators = Indicators{
+		&MFI{Leg: Leg1, ValuePos: Above, TargetVal: 45, Period: 14},
+		&BB{Mon: CloseR, ValuePos: Below, Line: Middle, Period: 100, Timeframe: 5},
+		&RSI{Mon: ReturnR, ValuePos: Below, TargetVal: 55, Period: 20},
+	}
+	ag.Rule = OrRule(IndRule(1), AndRule(IndRule(0), NotRule(IndRule(2))))
+	expectSignals(t, ag, klns1, klns2)
+}
+
+func TestSignals_ShortHistory(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(100, 1), randomWalkKlines(100, 2))
+
+	sigs, err := RandomAgent().Signals(pc)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	for i, s := range sigs {
+		if s {
+			t.Errorf("expected no signal before min activation length at %d", i)
+		}
+	}
+}
+
+func TestRollingSums(t *testing.T) {
+	vals := []float64{1, 2, 3, 4, 5, 6, 7}
+	sums, errs := rollingSums(vals, 3)
+
+	expected := []float64{0, 0, 6, 9, 12, 15, 18}
+	for i := range expected {
+		if sums[i] != expected[i] {
+			t.Errorf("expected %.2f at %d, returned %.2f", expected[i], i, sums[i])
+		}
+	}
+	if errs[6] <= 0 {
+		t.Errorf("expected positive error bound")
+	}
+}
+
+func TestRatioIndexBounds_EdgeCases(t *testing.T) {
+	if lo, hi := ratioIndexBounds(5, 0, 2, 0, 0, 0); lo != 100 || hi != 100 {
+		t.Errorf("expected 100 without negative terms, returned %f, %f", lo, hi)
+	}
+	if lo, hi := ratioIndexBounds(0, 0, 0, 5, 0, 2); lo != 0 || hi != 0 {
+		t.Errorf("expected 0 without positive terms, returned %f, %f", lo, hi)
+	}
+	if lo, hi := ratioIndexBounds(5, 0.1, 2, 5, 0.1, 2); !(lo < 50 && hi > 50) {
+		t.Errorf("expected bounds around 50, returned %f, %f", lo, hi)
+	}
+}
+
+func Benchmark_Signals(b *testing.B) {
+	rand.Seed(1)
+	pc, _ := NewPairColumns(randomWalkKlines(20_000, 1), randomWalkKlines(20_000, 2))
+	agents := make([]*Agent, 100)
+	for i := range agents {
+		agents[i] = RandomAgent()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		agents[i%len(agents)].Signals(pc)
+	}
+}
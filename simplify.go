@@ -0,0 +1,188 @@
+package agent2
+
+import (
+	"fmt"
+)
+
+// SimplifyReason tells why Simplify removed an indicator. Implied
+// indicators are active whenever another indicator of the agent is,
+// NeverBinding ones could be removed without changing any trade on
+// the dataset, such as indicators that are always active on it
+type SimplifyReason uint8
+
+const (
+	Implied SimplifyReason = iota
+	NeverBinding
+)
+
+func (sr SimplifyReason) String() string {
+	switch sr {
+	case Implied:
+		return "implied"
+	case NeverBinding:
+		return "neverBinding"
+	}
+	return ""
+}
+
+// RemovedIndicator names an indicator by its index and kind in the
+// source agent, as 2.bb
+type RemovedIndicator struct {
+	Name   string         `json:"name"`
+	Reason SimplifyReason `json:"reason"`
+}
+
+type Simplified struct {
+	Agent   *Agent             `json:"agent"`
+	Removed []RemovedIndicator `json:"removed"`
+}
+
+// Simplify returns a smaller agent with the same trades on pc. Implied
+// indicators are dropped first, they stay implied on any data. Then
+// the rest are removed one at a time, most expensive first, as long as
+// the backtest trades don't change
+func (ag *Agent) Simplify(pc *PairColumns) (*Simplified, error) {
+	base, err := ag.Backtest(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	// cur shares indicators with ag so they can be told apart by pointer
+	names := make(map[Indicator]string, len(ag.Indicators))
+	for i, ind := range ag.Indicators {
+		names[ind] = fmt.Sprintf("%d.%s", i, ind.Kind())
+	}
+	res := &Simplified{}
+	report := func(cur *Agent, reason SimplifyReason) {
+		kept := make(map[Indicator]bool, len(cur.Indicators))
+		for _, ind := range cur.Indicators {
+			kept[ind] = true
+		}
+		for _, ind := range ag.Indicators {
+			if name, ok := names[ind]; ok && !kept[ind] {
+				res.Removed = append(res.Removed, RemovedIndicator{Name: name, Reason: reason})
+				delete(names, ind)
+			}
+		}
+	}
+
+	cur := &Agent{
+		Tpsl:         ag.Tpsl,
+		Backoff:      ag.Backoff,
+		ExpiryMillis: ag.ExpiryMillis,
+		Indicators:   append(Indicators(nil), ag.Indicators...),
+		Rule:         ag.Rule.Clone(),
+	}
+	cur.dropImplied()
+	report(cur, Implied)
+
+	for i := len(ag.Indicators) - 1; i >= 0; i-- {
+		k := -1
+		for j, ind := range cur.Indicators {
+			if ind == ag.Indicators[i] {
+				k = j
+			}
+		}
+		if k < 0 {
+			continue
+		}
+
+		c := &Agent{Tpsl: cur.Tpsl, Backoff: cur.Backoff, ExpiryMillis: cur.ExpiryMillis,
+			Indicators: cur.Indicators, Rule: cur.Rule.Clone()}
+		c.removeIndicator(k)
+		bu, err := c.Backtest(pc)
+		if err != nil {
+			return nil, err
+		}
+		if tradesEqual(base.Trades, bu.Trades) {
+			cur = c
+			report(cur, NeverBinding)
+		}
+	}
+
+	res.Agent = cur.Clone()
+	return res, nil
+}
+
+// dropImplied removes indicators implied by another one in place. Under
+// a rule an implied indicator can still decide an or, not group, so
+// rule agents only merge equal indicators
+func (ag *Agent) dropImplied() {
+	n := len(ag.Indicators)
+	into := make([]int, n)
+	for j := range into {
+		into[j] = j
+	}
+
+	for j, b := range ag.Indicators {
+		for i, a := range ag.Indicators {
+			if i == j || into[i] != i {
+				continue
+			}
+			if implies(a, b) && (ag.Rule == nil || implies(b, a)) {
+				into[j] = i
+				break
+			}
+		}
+	}
+
+	if ag.Rule == nil {
+		inds := make(Indicators, 0, n)
+		for j, ind := range ag.Indicators {
+			if into[j] == j {
+				inds = append(inds, ind)
+			}
+		}
+		ag.Indicators = inds
+		return
+	}
+
+	// equal indicators can be merged in chains, point to the last kept
+	for j := range into {
+		for into[into[j]] != into[j] {
+			into[j] = into[into[j]]
+		}
+	}
+	ag.Rule.remap(into)
+	ag.dropUnreferenced()
+}
+
+// implies is true when b is active on any klines a is active on
+func implies(a Indicator, b Indicator) bool {
+	keyA, posA, thA, okA := thresholdOf(a)
+	keyB, posB, thB, okB := thresholdOf(b)
+	if !okA || !okB || keyA != keyB || posA != posB {
+		return false
+	}
+
+	switch posA {
+	case Above:
+		return thA >= thB
+	case Below:
+		return thA <= thB
+	}
+	return false
+}
+
+// thresholdOf splits an indicator into the value it computes, keyed by
+// the params it depends on, and the threshold it compares it to. Bbs
+// compare to mean + coef * std, a higher coef is a higher threshold
+func thresholdOf(ind Indicator) (string, ValuePos, float64, bool) {
+	tf := indicatorTimeframe(ind)
+
+	switch ind := ind.(type) {
+	case *BB:
+		coef, ok := ind.lineCoef()
+		return fmt.Sprintf("%s %d %d %d", bbKind, ind.Mon, ind.Period, tf), ind.ValuePos, coef, ok
+	case *RSI:
+		return fmt.Sprintf("%s %d %d %d %d %d", rsiKind, ind.Mon, ind.Period, ind.Smoothing, ind.Length, tf),
+			ind.ValuePos, ind.TargetVal, true
+	case *MFI:
+		return fmt.Sprintf("%s %d %d %d", mfiKind, ind.Leg, ind.Period, tf), ind.ValuePos, ind.TargetVal, true
+	case *OBV:
+		return fmt.Sprintf("%s %d %d %d", obvKind, ind.Leg, ind.Period, tf), ind.ValuePos, ind.TargetSlope, true
+	case *VWAP:
+		return fmt.Sprintf("%s %d %d %d", vwapKind, ind.Leg, ind.Period, tf), ind.ValuePos, ind.TargetDev, true
+	}
+	return "", Above, 0, false
+}
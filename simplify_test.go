@@ -0,0 +1,114 @@
+package agent2
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestImplies(t *testing.T) {
+	upper2 := &BB{Mon: CloseR, ValuePos: Above, Line: Upper, Period: 100, Multiplier: 2}
+	upper1 := &BB{Mon: CloseR, ValuePos: Above, Line: Upper, Period: 100, Multiplier: 1}
+	middle := &BB{Mon: CloseR, ValuePos: Above, Line: Middle, Period: 100, Multiplier: 3}
+	lower2 := &BB{Mon: CloseR, ValuePos: Below, Line: Lower, Period: 100, Multiplier: 2}
+	lower1 := &BB{Mon: CloseR, ValuePos: Below, Line: Lower, Period: 100, Multiplier: 1}
+	rsi70 := &RSI{Mon: CloseR, ValuePos: Above, TargetVal: 70, Period: 60}
+	rsi60 := &RSI{Mon: CloseR, ValuePos: Above, TargetVal: 60, Period: 60}
+
+	for _, tc := range []struct {
+		a, b     Indicator
+		expected bool
+	}{
+		{upper2, upper1, true},
+		{upper1, upper2, false},
+		{upper1, middle, true},
+		{lower2, lower1, true},
+		{lower1, lower2, false},
+		{upper2, lower1, false},
+		{upper2, &BB{Mon: CloseR, ValuePos: Above, Line: Upper, Period: 101, Multiplier: 1}, false},
+		{upper2, &BB{Mon: CloseR, ValuePos: Above, Line: Upper, Period: 100, Multiplier: 1, Timeframe: 5}, false},
+		{upper1, &BB{Mon: CloseR, ValuePos: Above, Line: Upper, Period: 100, Multiplier: 1, Timeframe: 1}, true},
+		{rsi70, rsi60, true},
+		{rsi60, rsi70, false},
+		{rsi70, &RSI{Mon: LogCloseR, ValuePos: Above, TargetVal: 60, Period: 60}, false},
+		{&VWAP{ValuePos: Below, TargetDev: -0.01, Period: 50}, &VWAP{ValuePos: Below, TargetDev: 0, Period: 50}, true},
+	} {
+		if got := implies(tc.a, tc.b); got != tc.expected {
+			t.Errorf("expected %v implies %v to be %v", tc.a, tc.b, tc.expected)
+		}
+	}
+}
+
+func TestAgent_DropImplied(t *testing.T) {
+	ag := robustnessAgent()
+	rsi, bb := ag.Indicators[0], ag.Indicators[1]
+	looser := &BB{Mon: CloseR, ValuePos: Below, Line: Upper, Period: 100, Multiplier: 1}
+	ag.Indicators = Indicators{rsi, looser, bb, bb.Clone()}
+
+	ag.dropImplied()
+	if len(ag.Indicators) != 2 || ag.Indicators[0] != rsi || ag.Indicators[1].(*BB).Line != Middle {
+		t.Errorf("expected rsi and a single middle bb, returned %v", ag.Indicators)
+	}
+
+	// under a rule only equal indicators merge
+	ag.Indicators = Indicators{rsi, looser, bb, bb.Clone()}
+	ag.Rule = OrRule(IndRule(1), NotRule(IndRule(3)), AndRule(IndRule(0), IndRule(2)))
+	ag.dropImplied()
+	if len(ag.Indicators) != 3 || ag.Rule.Validate(3) != nil {
+		t.Fatalf("expected 3 indicators under the rule, returned %v", ag.Indicators)
+	}
+	merged := 0
+	for _, n := range ag.Rule.nodes() {
+		if n.Op == OpInd && n.Index == 2 {
+			merged++
+		}
+	}
+	if merged != 2 || ag.Indicators[1] != looser {
+		t.Errorf("expected both bb leaves merged, returned %d", merged)
+	}
+}
+
+func TestAgent_Simplify(t *testing.T) {
+	rand.Seed(97)
+	pc, _ := NewPairColumns(randomWalkKlines(3_000, 1), randomWalkKlines(3_000, 2))
+	ag := robustnessAgent()
+	rsi, bb := ag.Indicators[0], ag.Indicators[1]
+	ag.Indicators = Indicators{
+		// rsi is never above 100
+		&RSI{Mon: CloseR, ValuePos: Below, TargetVal: 101, Period: 30},
+		rsi,
+		&BB{Mon: CloseR, ValuePos: Below, Line: Upper, Period: 100, Multiplier: 1},
+		bb,
+	}
+	sortIndicators(ag.Indicators)
+
+	res, err := ag.Simplify(pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Agent.Indicators) > 2 {
+		t.Errorf("expected at most rsi and bb left, returned %v", res.Agent.Indicators)
+	}
+
+	reasons := make(map[string]SimplifyReason)
+	for _, rm := range res.Removed {
+		reasons[rm.Name] = rm.Reason
+	}
+	if r, ok := reasons["0.rsi"]; !ok || r != NeverBinding {
+		t.Errorf("expected always active rsi removed as never binding, returned %v", res.Removed)
+	}
+	if r, ok := reasons["2.bb"]; !ok || r != Implied {
+		t.Errorf("expected upper bb removed as implied, returned %v", res.Removed)
+	}
+	if len(res.Removed)+len(res.Agent.Indicators) != 4 {
+		t.Errorf("expected every indicator kept or removed once, returned %v", res.Removed)
+	}
+
+	bu, _ := ag.Backtest(pc)
+	sbu, _ := res.Agent.Backtest(pc)
+	if len(bu.Trades) == 0 || !tradesEqual(bu.Trades, sbu.Trades) {
+		t.Errorf("expected the same %d trades, returned %d", len(bu.Trades), len(sbu.Trades))
+	}
+	if len(ag.Indicators) != 4 {
+		t.Errorf("expected source agent untouched")
+	}
+}
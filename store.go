@@ -0,0 +1,365 @@
+package agent2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// KlineStore is a columnar file of final klines for one symbol and
+// interval. Rows are kept in chunks of storeChunkRows, each chunk holds
+// its columns one after the other as little endian 8 byte values, so a
+// column of a chunk is contiguous and appends only touch the last chunk.
+// Open times are strictly increasing and act as the index
+//
+//	header | chunk 0: opentime[n] open[n] ... closetime[n] | chunk 1 ...
+
+type storeColumn int
+
+const (
+	colOpenTime storeColumn = iota
+	colOpen
+	colHigh
+	colLow
+	colClose
+	colVolume
+	colTakerBuyVolume
+	colNumberOfTrades
+	colCloseTime
+	storeColumns
+)
+
+const (
+	storeMagic      = "AG2KLNS\x00"
+	storeVersion    = 1
+	storeHeaderSize = 64
+	storeChunkRows  = 4096
+	storeChunkSize  = storeChunkRows * int64(storeColumns) * 8
+
+	// header offsets
+	storeVersionOff = 8
+	storeChunkOff   = 12
+	storeRowsOff    = 16
+)
+
+var (
+	ErrStoreFileIsNotValid        = fmt.Errorf("file is not a kline store")
+	ErrStoreVersionIsNotSupported = fmt.Errorf("kline store version is not supported")
+	ErrStoreKlineIsNotFinal       = fmt.Errorf("kline store only accepts final klines")
+	ErrStoreKlineIsNotAfterLast   = fmt.Errorf("kline open time should be after the last stored")
+	ErrStoreWindowIsOutOfRange    = fmt.Errorf("kline store has less klines than window")
+	ErrStoreIsClosed              = fmt.Errorf("kline store is closed")
+)
+
+type KlineStore struct {
+	mu   sync.RWMutex
+	f    *os.File
+	data []byte
+	rows int
+}
+
+// StorePath is the store file of a symbol and interval under dir
+func StorePath(dir string, symbol string, interval string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.klns", symbol, interval))
+}
+
+// OpenKlineStore opens the store at path, creating it when missing
+func OpenKlineStore(path string) (*KlineStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createKlineStore(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	st := &KlineStore{f: f}
+	if err := st.readHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := st.remap(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func createKlineStore(path string) (*KlineStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, storeHeaderSize)
+	copy(header, storeMagic)
+	binary.LittleEndian.PutUint32(header[storeVersionOff:], storeVersion)
+	binary.LittleEndian.PutUint32(header[storeChunkOff:], storeChunkRows)
+	if _, err := f.WriteAt(header, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	st := &KlineStore{f: f}
+	if err := st.remap(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *KlineStore) readHeader() error {
+	header := make([]byte, storeHeaderSize)
+	if _, err := st.f.ReadAt(header, 0); err != nil {
+		return ErrStoreFileIsNotValid
+	}
+	if string(header[:len(storeMagic)]) != storeMagic {
+		return ErrStoreFileIsNotValid
+	}
+	if binary.LittleEndian.Uint32(header[storeVersionOff:]) != storeVersion {
+		return ErrStoreVersionIsNotSupported
+	}
+	if binary.LittleEndian.Uint32(header[storeChunkOff:]) != storeChunkRows {
+		return ErrStoreFileIsNotValid
+	}
+	st.rows = int(binary.LittleEndian.Uint64(header[storeRowsOff:]))
+	return nil
+}
+
+func (st *KlineStore) remap() error {
+	info, err := st.f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.data != nil {
+		if err := unmapFile(st.data); err != nil {
+			return err
+		}
+		st.data = nil
+	}
+
+	data, err := mapFile(st.f, int(info.Size()))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < storeHeaderSize+chunksFor(st.rows)*storeChunkSize {
+		unmapFile(data)
+		return ErrStoreFileIsNotValid
+	}
+	st.data = data
+	return nil
+}
+
+func chunksFor(rows int) int64 {
+	return (int64(rows) + storeChunkRows - 1) / storeChunkRows
+}
+
+func cellOffset(row int, col storeColumn) int64 {
+	chunk, pos := int64(row)/storeChunkRows, int64(row)%storeChunkRows
+	return storeHeaderSize + chunk*storeChunkSize + (int64(col)*storeChunkRows+pos)*8
+}
+
+func (st *KlineStore) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.f == nil {
+		return nil
+	}
+	err := unmapFile(st.data)
+	if cerr := st.f.Close(); err == nil {
+		err = cerr
+	}
+	st.f, st.data, st.rows = nil, nil, 0
+	return err
+}
+
+func (st *KlineStore) Len() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.rows
+}
+
+// Append writes klines after the last stored one, the row count in the
+// header is updated last so a failed append leaves the store unchanged
+func (st *KlineStore) Append(klns ...klines.Kline) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.f == nil {
+		return ErrStoreIsClosed
+	}
+	if len(klns) == 0 {
+		return nil
+	}
+
+	last := int64(math.MinInt64)
+	if st.rows > 0 {
+		last = st.int64At(st.rows-1, colOpenTime)
+	}
+	for i := range klns {
+		if !klns[i].IsFinal {
+			return ErrStoreKlineIsNotFinal
+		}
+		if klns[i].OpenTime <= last {
+			return ErrStoreKlineIsNotAfterLast
+		}
+		last = klns[i].OpenTime
+	}
+
+	rows := st.rows + len(klns)
+	if size := storeHeaderSize + chunksFor(rows)*storeChunkSize; size > int64(len(st.data)) {
+		if err := st.f.Truncate(size); err != nil {
+			return err
+		}
+	}
+
+	// write column runs, one per column and touched chunk
+	buf := make([]byte, 0, storeChunkRows*8)
+	for start := 0; start < len(klns); {
+		row := st.rows + start
+		n := min(len(klns)-start, storeChunkRows-row%storeChunkRows)
+
+		for col := colOpenTime; col < storeColumns; col++ {
+			buf = buf[:0]
+			for _, kln := range klns[start : start+n] {
+				buf = binary.LittleEndian.AppendUint64(buf, columnBits(&kln, col))
+			}
+			if _, err := st.f.WriteAt(buf, cellOffset(row, col)); err != nil {
+				return err
+			}
+		}
+		start += n
+	}
+
+	rowsBuf := binary.LittleEndian.AppendUint64(nil, uint64(rows))
+	if _, err := st.f.WriteAt(rowsBuf, storeRowsOff); err != nil {
+		return err
+	}
+	st.rows = rows
+	return st.remap()
+}
+
+func columnBits(kln *klines.Kline, col storeColumn) uint64 {
+	switch col {
+	case colOpenTime:
+		return uint64(kln.OpenTime)
+	case colOpen:
+		return math.Float64bits(kln.Open)
+	case colHigh:
+		return math.Float64bits(kln.High)
+	case colLow:
+		return math.Float64bits(kln.Low)
+	case colClose:
+		return math.Float64bits(kln.Close)
+	case colVolume:
+		return math.Float64bits(kln.Volume)
+	case colTakerBuyVolume:
+		return math.Float64bits(kln.TakerBuyVolume)
+	case colNumberOfTrades:
+		return uint64(kln.NumberOfTrades)
+	}
+	return uint64(kln.CloseTime)
+}
+
+func (st *KlineStore) bitsAt(row int, col storeColumn) uint64 {
+	off := cellOffset(row, col)
+	return binary.LittleEndian.Uint64(st.data[off : off+8])
+}
+
+func (st *KlineStore) int64At(row int, col storeColumn) int64 {
+	return int64(st.bitsAt(row, col))
+}
+
+func (st *KlineStore) float64At(row int, col storeColumn) float64 {
+	return math.Float64frombits(st.bitsAt(row, col))
+}
+
+func (st *KlineStore) klineAt(row int) klines.Kline {
+	return klines.Kline{
+		OpenTime:       st.int64At(row, colOpenTime),
+		Open:           st.float64At(row, colOpen),
+		High:           st.float64At(row, colHigh),
+		Low:            st.float64At(row, colLow),
+		Close:          st.float64At(row, colClose),
+		Volume:         st.float64At(row, colVolume),
+		TakerBuyVolume: st.float64At(row, colTakerBuyVolume),
+		NumberOfTrades: st.int64At(row, colNumberOfTrades),
+		CloseTime:      st.int64At(row, colCloseTime),
+		IsFinal:        true,
+	}
+}
+
+func (st *KlineStore) klinesBetween(from int, to int) []klines.Kline {
+	res := make([]klines.Kline, to-from)
+	for i := range res {
+		res[i] = st.klineAt(from + i)
+	}
+	return res
+}
+
+// search returns the first row opened at or after openTime
+func (st *KlineStore) search(openTime int64) int {
+	return sort.Search(st.rows, func(i int) bool {
+		return st.int64At(i, colOpenTime) >= openTime
+	})
+}
+
+// searchAfter returns the first row opened after openTime
+func (st *KlineStore) searchAfter(openTime int64) int {
+	return sort.Search(st.rows, func(i int) bool {
+		return st.int64At(i, colOpenTime) > openTime
+	})
+}
+
+// Index returns the row of the kline opened at openTime, -1 if missing
+func (st *KlineStore) Index(openTime int64) int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	i := st.search(openTime)
+	if i < st.rows && st.int64At(i, colOpenTime) == openTime {
+		return i
+	}
+	return -1
+}
+
+// Window returns the last n klines opened at or before openTime, the
+// form OpenPos expects at that time
+func (st *KlineStore) Window(openTime int64, n int) ([]klines.Kline, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if st.f == nil {
+		return nil, ErrStoreIsClosed
+	}
+	end := st.searchAfter(openTime)
+	if n > end {
+		return nil, ErrStoreWindowIsOutOfRange
+	}
+	return st.klinesBetween(end-n, end), nil
+}
+
+// Range returns klines opened in [from, to)
+func (st *KlineStore) Range(from int64, to int64) ([]klines.Kline, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if st.f == nil {
+		return nil, ErrStoreIsClosed
+	}
+	start, end := st.search(from), st.search(to)
+	if end < start {
+		end = start
+	}
+	return st.klinesBetween(start, end), nil
+}
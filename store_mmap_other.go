@@ -0,0 +1,21 @@
+//go:build !unix
+
+package agent2
+
+import (
+	"io"
+	"os"
+)
+
+// platforms without mmap read the whole file
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
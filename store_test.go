@@ -0,0 +1,148 @@
+package agent2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/varga-lp/data/klines"
+)
+
+func storeKlines(from int, length int) []klines.Kline {
+	klns := dummyKlines(from + length)[from:]
+	for i := range klns {
+		klns[i].OpenTime = int64(from+i) * testInterval
+		klns[i].CloseTime = int64(from+i+1)*testInterval - 1
+		klns[i].IsFinal = true
+	}
+	return klns
+}
+
+func openTestStore(t *testing.T) (*KlineStore, string) {
+	path := StorePath(t.TempDir(), "BTCUSDT", "1m")
+	st, err := OpenKlineStore(path)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	return st, path
+}
+
+func TestKlineStore_AppendAcrossChunks(t *testing.T) {
+	st, path := openTestStore(t)
+
+	length := storeChunkRows + 100
+	if err := st.Append(storeKlines(0, 10)...); err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if err := st.Append(storeKlines(10, length-10)...); err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	st.Close()
+
+	st, err := OpenKlineStore(path)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	defer st.Close()
+
+	if st.Len() != length {
+		t.Errorf("expected %d klines, returned %d", length, st.Len())
+	}
+	expected := storeKlines(0, length)
+	for _, i := range []int{0, 9, 10, storeChunkRows - 1, storeChunkRows, length - 1} {
+		if kln := st.klineAt(i); kln != expected[i] {
+			t.Errorf("expected %+v at %d, returned %+v", expected[i], i, kln)
+		}
+	}
+}
+
+func TestKlineStore_Window(t *testing.T) {
+	st, _ := openTestStore(t)
+	defer st.Close()
+	st.Append(storeKlines(0, 300)...)
+
+	w, err := st.Window(299*testInterval, 250)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(w) != 250 || w[0].OpenTime != 50*testInterval || w[249].OpenTime != 299*testInterval {
+		t.Errorf("unexpected window bounds")
+	}
+
+	// time between two open times ends at the earlier
+	w, _ = st.Window(100*testInterval+1, 2)
+	if w[1].OpenTime != 100*testInterval {
+		t.Errorf("expected window to end at 100th kline, returned %d", w[1].OpenTime)
+	}
+
+	if _, err := st.Window(100*testInterval, 102); err != ErrStoreWindowIsOutOfRange {
+		t.Errorf("expected %v, returned %v", ErrStoreWindowIsOutOfRange, err)
+	}
+}
+
+func TestKlineStore_RangeAndIndex(t *testing.T) {
+	st, _ := openTestStore(t)
+	defer st.Close()
+	st.Append(storeKlines(0, 20)...)
+
+	r, _ := st.Range(5*testInterval, 8*testInterval)
+	if len(r) != 3 || r[0].OpenTime != 5*testInterval {
+		t.Errorf("unexpected range %v", r)
+	}
+	if r, _ := st.Range(8*testInterval, 5*testInterval); len(r) != 0 {
+		t.Errorf("expected empty range, returned %d", len(r))
+	}
+
+	if st.Index(7*testInterval) != 7 {
+		t.Errorf("expected index 7, returned %d", st.Index(7*testInterval))
+	}
+	if st.Index(7*testInterval+1) != -1 {
+		t.Errorf("expected missing open time not to be found")
+	}
+}
+
+func TestKlineStore_AppendErrors(t *testing.T) {
+	st, _ := openTestStore(t)
+	defer st.Close()
+	st.Append(storeKlines(0, 5)...)
+
+	if err := st.Append(storeKlines(4, 2)...); err != ErrStoreKlineIsNotAfterLast {
+		t.Errorf("expected %v, returned %v", ErrStoreKlineIsNotAfterLast, err)
+	}
+	klns := storeKlines(5, 1)
+	klns[0].IsFinal = false
+	if err := st.Append(klns...); err != ErrStoreKlineIsNotFinal {
+		t.Errorf("expected %v, returned %v", ErrStoreKlineIsNotFinal, err)
+	}
+	if st.Len() != 5 {
+		t.Errorf("expected failed appends to keep 5 klines, returned %d", st.Len())
+	}
+
+	st.Close()
+	if err := st.Append(storeKlines(5, 1)...); err != ErrStoreIsClosed {
+		t.Errorf("expected %v, returned %v", ErrStoreIsClosed, err)
+	}
+}
+
+func TestOpenKlineStore_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.klns")
+	os.WriteFile(path, []byte("not a kline store"), 0o644)
+
+	if _, err := OpenKlineStore(path); err != ErrStoreFileIsNotValid {
+		t.Errorf("expected %v, returned %v", ErrStoreFileIsNotValid, err)
+	}
+}
+
+func Benchmark_KlineStoreWindow(b *testing.B) {
+	st, err := OpenKlineStore(StorePath(b.TempDir(), "BTCUSDT", "1m"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer st.Close()
+	st.Append(storeKlines(0, 100_000)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		st.Window(int64(250+i%99_000)*testInterval, 250)
+	}
+}
@@ -0,0 +1,210 @@
+package agent2
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// Timeframed is implemented by indicators that can run on a higher
+// timeframe, TimeframeMult times the base kline interval. The agent
+// resamples the base klines into rolling bars ending at the last base
+// kline, so the most recent higher timeframe bar is always complete
+type Timeframed interface {
+	TimeframeMult() int
+}
+
+const (
+	maxTimeframeMult = 240
+)
+
+// RandomTimeframeProb is the percent of random indicators drawn on a
+// higher timeframe, set it before drawing. It defaults to 0, which keeps
+// random agents, their mutations and params on the base interval, so
+// they evaluate on minActivationKlineLength klines. A 240x indicator
+// reads up to maxPeriod * 240 base klines
+var RandomTimeframeProb = 0
+
+// timeframeMults are the multipliers drawn and stepped through by
+// random search and mutation, 1m, 5m, 15m, 1h and 4h on 1m klines
+var timeframeMults = []int{1, 5, 15, 60, 240}
+
+var (
+	ErrTimeframeIsOutOfRange = fmt.Errorf("timeframe multiplier should be in [1, 240]")
+)
+
+// randTimeframe is 0 for the base interval, so it is left out of json
+func randTimeframe() int {
+	if RandomTimeframeProb <= 0 || rand.Intn(100) >= RandomTimeframeProb {
+		return 0
+	}
+	return timeframeMults[1+rand.Intn(len(timeframeMults)-1)]
+}
+
+// timeframeIndex is the index of the closest multiplier to tf
+func timeframeIndex(tf int) int {
+	res := 0
+	for i, mult := range timeframeMults {
+		if math.Abs(float64(mult-tf)) < math.Abs(float64(timeframeMults[res]-tf)) {
+			res = i
+		}
+	}
+	return res
+}
+
+func timeframeAt(idx int) int {
+	if tf := timeframeMults[idx]; tf > 1 {
+		return tf
+	}
+	return 0
+}
+
+// mutatesTimeframe tells if a mutation of an indicator with n
+// mutable fields moves its timeframe, never when timeframes are off
+func mutatesTimeframe(n int) bool {
+	return RandomTimeframeProb > 0 && rand.Intn(n) == 0
+}
+
+// mutateTimeframe moves to a neighboring multiplier
+func mutateTimeframe(tf int) int {
+	idx := timeframeIndex(max(1, tf))
+	if idx == 0 || (idx < len(timeframeMults)-1 && rand.Intn(2) == 0) {
+		return timeframeAt(idx + 1)
+	}
+	return timeframeAt(idx - 1)
+}
+
+// timeframeParams is the timeframe param once timeframes are on
+func timeframeParams(tf *int) []Param {
+	if RandomTimeframeProb <= 0 {
+		return nil
+	}
+	return []Param{timeframeParam(tf)}
+}
+
+// timeframeParam is the index of the multiplier in timeframeMults, so
+// steps move between common timeframes
+func timeframeParam(tf *int) Param {
+	return Param{Name: "timeframe", Value: float64(timeframeIndex(max(1, *tf))), Step: 1, Grid: 1,
+		Min: 0, Max: float64(len(timeframeMults) - 1),
+		Set: func(v float64) { *tf = timeframeAt(int(math.Round(v))) }}
+}
+
+// checkTimeframe rejects multipliers evaluation would fail on, 0 is the
+// base interval
+func checkTimeframe(ind Indicator) error {
+	if tfd, ok := ind.(Timeframed); ok && (tfd.TimeframeMult() < 0 || tfd.TimeframeMult() > maxTimeframeMult) {
+		return ErrTimeframeIsOutOfRange
+	}
+	return nil
+}
+
+func indicatorTimeframe(ind Indicator) int {
+	if tfd, ok := ind.(Timeframed); ok && tfd.TimeframeMult() > 1 {
+		return tfd.TimeframeMult()
+	}
+	return 1
+}
+
+// baseLookback is the number of base klines an indicator reads
+func baseLookback(ind Indicator) int {
+	return ind.Lookback() * indicatorTimeframe(ind)
+}
+
+// aggregateKlines merges consecutive klines into a single bar
+func aggregateKlines(klns []klines.Kline) klines.Kline {
+	first, last := klns[0], klns[len(klns)-1]
+	res := klines.Kline{
+		OpenTime:  first.OpenTime,
+		Open:      first.Open,
+		High:      first.High,
+		Low:       first.Low,
+		Close:     last.Close,
+		CloseTime: last.CloseTime,
+		IsFinal:   last.IsFinal,
+	}
+	for _, kln := range klns {
+		if kln.High > res.High {
+			res.High = kln.High
+		}
+		if kln.Low < res.Low {
+			res.Low = kln.Low
+		}
+		res.Volume += kln.Volume
+		res.TakerBuyVolume += kln.TakerBuyVolume
+		res.NumberOfTrades += kln.NumberOfTrades
+	}
+	return res
+}
+
+// resampleTail returns the last count bars of tf base klines each
+func resampleTail(klns []klines.Kline, tf int, count int) ([]klines.Kline, error) {
+	if tf < 1 || tf > maxTimeframeMult {
+		return nil, ErrTimeframeIsOutOfRange
+	}
+	n := len(klns)
+	if count*tf > n {
+		return nil, ErrKlinesAreBelowIndicatorLookback
+	}
+
+	res := make([]klines.Kline, count)
+	for j := 0; j < count; j++ {
+		end := n - (count-j-1)*tf
+		res[j] = aggregateKlines(klns[end-tf : end])
+	}
+	return res, nil
+}
+
+// pairFrames hands out indicator windows for one evaluation, higher
+// timeframe bars are resampled once per timeframe
+type pairFrames struct {
+	klns1     []klines.Kline
+	klns2     []klines.Kline
+	resampled map[int][2][]klines.Kline
+}
+
+func newPairFrames(klns1 []klines.Kline, klns2 []klines.Kline) *pairFrames {
+	return &pairFrames{
+		klns1: klns1,
+		klns2: klns2,
+	}
+}
+
+func (pf *pairFrames) window(ind Indicator) ([]klines.Kline, []klines.Kline, error) {
+	lb, tf := ind.Lookback(), indicatorTimeframe(ind)
+
+	if tf == 1 {
+		if lb > len(pf.klns1) || lb > len(pf.klns2) {
+			return nil, nil, ErrKlinesAreBelowIndicatorLookback
+		}
+		return pf.klns1[len(pf.klns1)-lb:], pf.klns2[len(pf.klns2)-lb:], nil
+	}
+
+	frames, ok := pf.resampled[tf]
+	if !ok || len(frames[0]) < lb {
+		rs1, err := resampleTail(pf.klns1, tf, lb)
+		if err != nil {
+			return nil, nil, err
+		}
+		rs2, err := resampleTail(pf.klns2, tf, lb)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pf.resampled == nil {
+			pf.resampled = make(map[int][2][]klines.Kline)
+		}
+		frames = [2][]klines.Kline{rs1, rs2}
+		pf.resampled[tf] = frames
+	}
+	return frames[0][len(frames[0])-lb:], frames[1][len(frames[1])-lb:], nil
+}
+
+func (pf *pairFrames) active(ind Indicator) (bool, error) {
+	w1, w2, err := pf.window(ind)
+	if err != nil {
+		return false, err
+	}
+	return ind.Active(w1, w2)
+}
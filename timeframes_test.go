@@ -0,0 +1,198 @@
+package agent2
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestAggregateKlines(t *testing.T) {
+	klns := dummyKlines(4)
+	klns[1].High, klns[2].Low = 10, -1
+
+	r := aggregateKlines(klns[1:])
+	if r.OpenTime != klns[1].OpenTime || r.Open != 1 || r.Close != 4 {
+		t.Errorf("unexpected open close %+v", r)
+	}
+	if r.High != 10 || r.Low != -1 {
+		t.Errorf("expected high 10 low -1, returned %.2f %.2f", r.High, r.Low)
+	}
+	if r.Volume != 6000 || r.TakerBuyVolume != 3600 || r.NumberOfTrades != 60 {
+		t.Errorf("unexpected sums %.2f %.2f %d", r.Volume, r.TakerBuyVolume, r.NumberOfTrades)
+	}
+}
+
+func TestResampleTail_EndsAtLastKline(t *testing.T) {
+	klns := dummyKlines(11)
+
+	r, err := resampleTail(klns, 5, 2)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(r) != 2 {
+		t.Fatalf("expected 2 bars, returned %d", len(r))
+	}
+	if r[0].Open != 1 || r[0].Close != 6 || r[1].Open != 6 || r[1].Close != 11 {
+		t.Errorf("unexpected bars %+v", r)
+	}
+}
+
+func TestResampleTail_Errors(t *testing.T) {
+	if _, err := resampleTail(dummyKlines(9), 5, 2); err != ErrKlinesAreBelowIndicatorLookback {
+		t.Errorf("expected %v, returned %v", ErrKlinesAreBelowIndicatorLookback, err)
+	}
+	if _, err := resampleTail(dummyKlines(10), maxTimeframeMult+1, 1); err != ErrTimeframeIsOutOfRange {
+		t.Errorf("expected %v, returned %v", ErrTimeframeIsOutOfRange, err)
+	}
+}
+
+func TestPairFrames_Tf1IsPlainTail(t *testing.T) {
+	klns := dummyKlines(10)
+	bb := &BB{Mon: Close1, Period: 4, Multiplier: 1, Timeframe: 1}
+
+	w1, _, err := newPairFrames(klns, klns).window(bb)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if len(w1) != 4 || w1[0] != klns[6] {
+		t.Errorf("expected last 4 klines, returned %+v", w1)
+	}
+}
+
+func TestBaseLookback(t *testing.T) {
+	rsi := &RSI{Period: 20, Timeframe: 15}
+
+	if baseLookback(rsi) != 300 {
+		t.Errorf("expected 300, returned %d", baseLookback(rsi))
+	}
+}
+
+func TestOpenPos_HigherTimeframe(t *testing.T) {
+	ag := RandomAgent()
+	ag.Rule = nil
+	ag.Indicators = Indicators{&BB{Mon: Close1, ValuePos: Above, Line: Upper, Period: 100, Multiplier: 1, Timeframe: 5}}
+
+	klns := dummyKlines(499)
+	if _, err := ag.OpenPos(klns, klns, nil); err != ErrKlinesAreBelowIndicatorLookback {
+		t.Errorf("expected %v, returned %v", ErrKlinesAreBelowIndicatorLookback, err)
+	}
+
+	klns = dummyKlines(500)
+	act, err := ag.OpenPos(klns, klns, nil)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if !act {
+		t.Errorf("expected %v as active, returned %v", true, act)
+	}
+}
+
+func TestTimeframe_OmittedWhenZero(t *testing.T) {
+	pload, _ := json.Marshal(&BB{Period: 10})
+	if strings.Contains(string(pload), "tf") {
+		t.Errorf("expected tf to be omitted, received %s", pload)
+	}
+
+	pload, _ = json.Marshal(&VWAP{Period: 10, Timeframe: 60})
+	if !strings.Contains(string(pload), `"tf":60`) {
+		t.Errorf("expected tf in payload, received %s", pload)
+	}
+}
+
+func TestRandomIndicators_BaseTimeframeByDefault(t *testing.T) {
+	rand.Seed(137)
+	for i := 0; i < 1_000; i++ {
+		ag := RandomAgent()
+		ag.Mutate()
+		for _, ind := range ag.Indicators {
+			if tf := ind.(Timeframed).TimeframeMult(); tf != 0 {
+				t.Fatalf("expected the base interval, returned %d", tf)
+			}
+		}
+		for _, p := range ag.Params() {
+			if strings.HasSuffix(p.Name, ".timeframe") {
+				t.Fatalf("expected no timeframe param, returned %s", p.Name)
+			}
+		}
+		if _, err := ag.OpenPos(dummyKlines(minActivationKlineLength), dummyKlines(minActivationKlineLength), nil); err != nil {
+			t.Fatalf("expected no error but raised %v", err)
+		}
+	}
+}
+
+func TestRandomIndicators_DrawTimeframes(t *testing.T) {
+	RandomTimeframeProb = 30
+	defer func() { RandomTimeframeProb = 0 }()
+
+	rand.Seed(137)
+	drawn := make(map[int]int)
+	for i := 0; i < 2_000; i++ {
+		for _, ind := range []Indicator{RandomBB(), RandomRSI(), RandomMFI(), RandomOBV(), RandomVWAP()} {
+			drawn[ind.(Timeframed).TimeframeMult()]++
+		}
+	}
+	if drawn[0] < drawn[5] {
+		t.Errorf("expected the base interval drawn most, returned %v", drawn)
+	}
+	for _, tf := range timeframeMults[1:] {
+		if drawn[tf] == 0 {
+			t.Errorf("expected timeframe %d drawn", tf)
+		}
+	}
+	if len(drawn) != len(timeframeMults) {
+		t.Errorf("expected only listed timeframes drawn, returned %v", drawn)
+	}
+}
+
+func TestMutateTimeframe(t *testing.T) {
+	rand.Seed(139)
+	for i := 0; i < 100; i++ {
+		if tf := mutateTimeframe(0); tf != 5 {
+			t.Fatalf("expected 5 after the base interval, returned %d", tf)
+		}
+		if tf := mutateTimeframe(240); tf != 60 {
+			t.Fatalf("expected 60 before 240, returned %d", tf)
+		}
+		if tf := mutateTimeframe(15); tf != 5 && tf != 60 {
+			t.Fatalf("expected a neighbor of 15, returned %d", tf)
+		}
+	}
+
+	// mutation reaches higher timeframes once they are on
+	RandomTimeframeProb = 30
+	defer func() { RandomTimeframeProb = 0 }()
+	bb := &BB{Period: 20, Multiplier: 2}
+	for i := 0; i < 100 && bb.Timeframe == 0; i++ {
+		bb.Mutate()
+	}
+	if bb.Timeframe == 0 {
+		t.Errorf("expected a mutated timeframe")
+	}
+}
+
+func TestTimeframeParam(t *testing.T) {
+	tf := 60
+	p := timeframeParam(&tf)
+	if p.Value != 3 || p.Min != 0 || p.Max != 4 {
+		t.Errorf("expected index 3 in [0, 4], returned %f in [%f, %f]", p.Value, p.Min, p.Max)
+	}
+	p.Set(1)
+	if tf != 5 {
+		t.Errorf("expected 5, returned %d", tf)
+	}
+	p.Set(0)
+	if tf != 0 {
+		t.Errorf("expected the base interval as 0, returned %d", tf)
+	}
+}
+
+func TestUnmarshalAgent_TimeframeOutOfRange(t *testing.T) {
+	for _, tf := range []string{"241", "-1"} {
+		pload := `{"tpsl":{"tp":0.02,"sl":0.01},"backoff":{"mls":60000},"expiry_mls":600000,` +
+			`"indicators":[{"kind":"bb","params":{"mon":0,"val_pos":0,"line":0,"period":20,"multiplier":2,"tf":` + tf + `}}]}`
+		if _, err := UnmarshalAgent([]byte(pload)); err != ErrTimeframeIsOutOfRange {
+			t.Errorf("expected %v for tf %s, returned %v", ErrTimeframeIsOutOfRange, tf, err)
+		}
+	}
+}
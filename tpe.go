@@ -0,0 +1,288 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	defaultTPEStartup    = 10
+	defaultTPECandidates = 24
+	defaultTPEGamma      = 0.25
+)
+
+// TPE tunes the numeric params of an agent keeping its structure, by a
+// tree structured parzen estimator (Bergstra et al. 2011). After Startup
+// uniform trials, the best Gamma * sqrt(trials) observations are split
+// from the rest, each param gets a kernel density of both, and
+// Candidates drawn from the good density are scored by good over rest
+// density. Batch trials are evaluated at once, the agent itself is the
+// first trial
+type TPE struct {
+	Trials     int
+	Startup    int
+	Batch      int
+	Candidates int
+	Gamma      float64
+	// Fitness defaults to ProfitPerDayFitness
+	Fitness   Fitness
+	Evaluator *Evaluator
+}
+
+// TPETrial holds param values in Agent.Params order, pruned trials
+// rank below every other and have no fitness
+type TPETrial struct {
+	Values  []float64 `json:"values"`
+	Fitness float64   `json:"fitness"`
+	Pruned  bool      `json:"pruned,omitempty"`
+}
+
+// better tells if the trial ranks above o
+func (tr *TPETrial) better(o *TPETrial) bool {
+	if tr.Pruned != o.Pruned {
+		return o.Pruned
+	}
+	return tr.Fitness > o.Fitness
+}
+
+type TPEResult struct {
+	Agent   *Agent     `json:"agent"`
+	Bucket  *Bucket    `json:"bucket"`
+	Fitness float64    `json:"fitness"`
+	Params  []string   `json:"params"`
+	Trials  []TPETrial `json:"trials"`
+}
+
+var (
+	ErrTrialsAreBelowTwo = fmt.Errorf("tpe needs at least 2 trials")
+)
+
+func (tp *TPE) Run(ctx context.Context, ag *Agent, pc *PairColumns) (*TPEResult, error) {
+	if tp.Trials < 2 {
+		return nil, ErrTrialsAreBelowTwo
+	}
+	fitness := tp.Fitness
+	if fitness == nil {
+		fitness = ProfitPerDayFitness
+	}
+	ev := tp.Evaluator
+	if ev == nil {
+		ev = &Evaluator{}
+	}
+	ts := newTPESampler(ag.Params())
+	ts.gamma, ts.candidates = tp.Gamma, tp.Candidates
+	if ts.gamma <= 0 || ts.gamma >= 1 {
+		ts.gamma = defaultTPEGamma
+	}
+	if ts.candidates <= 0 {
+		ts.candidates = defaultTPECandidates
+	}
+	startup := tp.Startup
+	if startup <= 0 {
+		startup = defaultTPEStartup
+	}
+
+	res := &TPEResult{}
+	best := -1
+	for _, p := range ts.params {
+		res.Params = append(res.Params, p.Name)
+	}
+
+	for len(res.Trials) < tp.Trials {
+		batch := min(max(1, tp.Batch), tp.Trials-len(res.Trials))
+		xs := make([][]float64, batch)
+		agents := make([]*Agent, batch)
+		for i := range xs {
+			switch {
+			case len(res.Trials) == 0 && i == 0:
+				xs[i] = ts.values(ag)
+			case len(res.Trials) < startup:
+				xs[i] = ts.uniform()
+			default:
+				xs[i] = ts.suggest(res.Trials)
+			}
+			agents[i] = ts.agent(ag, xs[i])
+		}
+
+		buckets, err := ev.Evaluate(ctx, agents, pc)
+		if err != nil {
+			return nil, err
+		}
+		for i, bu := range buckets {
+			tr := TPETrial{Values: xs[i], Pruned: bu.Pruned}
+			if !bu.Pruned {
+				tr.Fitness = fitness(bu)
+			}
+			res.Trials = append(res.Trials, tr)
+			if best < 0 || tr.better(&res.Trials[best]) {
+				best = len(res.Trials) - 1
+				res.Agent, res.Bucket, res.Fitness = agents[i], bu, tr.Fitness
+			}
+		}
+	}
+	return res, nil
+}
+
+// tpeSampler draws param values, snapped to the grid of every param.
+// Take profit and stop loss are drawn as a pair over their whole range,
+// tp and sl are their indices or -1
+type tpeSampler struct {
+	params     []Param
+	gamma      float64
+	candidates int
+	tp         int
+	sl         int
+}
+
+// newTPESampler widens the take profit and stop loss params, agent
+// params bound each by the value the other has in the source agent
+func newTPESampler(params []Param) *tpeSampler {
+	ts := &tpeSampler{params: params, tp: -1, sl: -1}
+	for j, p := range params {
+		switch p.Name {
+		case "tpsl.tp":
+			ts.tp = j
+		case "tpsl.sl":
+			ts.sl = j
+		default:
+			continue
+		}
+		ts.params[j].Min, ts.params[j].Max = minTPSL, maxTPSL
+	}
+	return ts
+}
+
+func (ts *tpeSampler) values(ag *Agent) []float64 {
+	xs := make([]float64, len(ts.params))
+	for j, p := range ts.params {
+		xs[j] = p.Value
+	}
+	return xs
+}
+
+// agent is a clone of ag with its params set to xs. A take profit
+// drawn below the stop loss is swapped with it in xs, so trials hold
+// the values of their agent
+func (ts *tpeSampler) agent(ag *Agent, xs []float64) *Agent {
+	c := ag.Clone()
+	if ts.tp >= 0 && ts.sl >= 0 {
+		if xs[ts.tp] < xs[ts.sl] {
+			xs[ts.tp], xs[ts.sl] = xs[ts.sl], xs[ts.tp]
+		}
+		// tpsl params clamp each other, start from the widest pair
+		c.Tpsl.TakeProfit, c.Tpsl.StopLoss = maxTPSL, minTPSL
+	}
+	for j, p := range c.Params() {
+		p.Set(xs[j])
+	}
+	c.sortIndicators()
+	return c
+}
+
+func (ts *tpeSampler) snap(p Param, v float64) float64 {
+	if p.Grid > 0 {
+		v = p.Min + math.Round((v-p.Min)/p.Grid)*p.Grid
+	}
+	return roundTo4d(math.Max(p.Min, math.Min(p.Max, v)))
+}
+
+func (ts *tpeSampler) uniform() []float64 {
+	xs := make([]float64, len(ts.params))
+	for j, p := range ts.params {
+		xs[j] = ts.snap(p, p.Min+rand.Float64()*(p.Max-p.Min))
+	}
+	return xs
+}
+
+// suggest treats params independently, taking the candidate with the
+// highest good over rest density for each
+func (ts *tpeSampler) suggest(trials []TPETrial) []float64 {
+	order := make([]int, len(trials))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return trials[order[a]].better(&trials[order[b]])
+	})
+	// as in hyperopt the good set grows with the root of the trials
+	nGood := min(25, max(1, int(math.Ceil(ts.gamma*math.Sqrt(float64(len(trials)))))))
+
+	xs := make([]float64, len(ts.params))
+	for j, p := range ts.params {
+		good, rest := make([]float64, 0, nGood), make([]float64, 0, len(trials)-nGood)
+		for k, i := range order {
+			if k < nGood {
+				good = append(good, trials[i].Values[j])
+			} else {
+				rest = append(rest, trials[i].Values[j])
+			}
+		}
+		l, g := newParzen(p, good), newParzen(p, rest)
+
+		best, bestRatio := p.Value, math.Inf(-1)
+		for c := 0; c < ts.candidates; c++ {
+			v := ts.snap(p, l.sample())
+			if ratio := math.Log(l.density(v)) - math.Log(g.density(v)); ratio > bestRatio {
+				best, bestRatio = v, ratio
+			}
+		}
+		xs[j] = best
+	}
+	return xs
+}
+
+// parzen is the adaptive parzen estimator of hyperopt, a gaussian
+// kernel per observation mixed with a wide prior kernel centered on the
+// param range. Each kernel is as wide as the gap to its farthest sorted
+// neighbor, within width / (n+1) and the width of the range
+type parzen struct {
+	mus    []float64
+	sigmas []float64
+}
+
+func newParzen(p Param, obs []float64) *parzen {
+	width := p.Max - p.Min
+	if width <= 0 {
+		width = math.Max(p.Grid, 1)
+	}
+	prior := (p.Min + p.Max) / 2
+	pz := &parzen{mus: append([]float64{prior}, obs...)}
+
+	order := make([]int, len(pz.mus))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return pz.mus[order[a]] < pz.mus[order[b]] })
+
+	minSigma := math.Max(p.Grid, width/math.Min(100, float64(len(pz.mus))))
+	pz.sigmas = make([]float64, len(pz.mus))
+	for k, i := range order {
+		gap := 0.0
+		if k > 0 {
+			gap = pz.mus[i] - pz.mus[order[k-1]]
+		}
+		if k < len(order)-1 {
+			gap = math.Max(gap, pz.mus[order[k+1]]-pz.mus[i])
+		}
+		pz.sigmas[i] = math.Max(minSigma, math.Min(width, gap))
+	}
+	pz.sigmas[0] = width
+	return pz
+}
+
+func (pz *parzen) sample() float64 {
+	k := rand.Intn(len(pz.mus))
+	return pz.mus[k] + rand.NormFloat64()*pz.sigmas[k]
+}
+
+func (pz *parzen) density(v float64) float64 {
+	d := 0.0
+	for k, mu := range pz.mus {
+		z := (v - mu) / pz.sigmas[k]
+		d += math.Exp(-z*z/2) / (pz.sigmas[k] * math.Sqrt(2*math.Pi))
+	}
+	return d / float64(len(pz.mus))
+}
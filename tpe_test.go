@@ -0,0 +1,171 @@
+package agent2
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTPESampler_Suggest(t *testing.T) {
+	x, y := 0.0, 0
+	ts := newTPESampler([]Param{
+		floatParam("x", &x, 0.1, 0.1, -10, 10),
+		intParam("y", &y, 1, 0, 100),
+	})
+	ts.gamma, ts.candidates = defaultTPEGamma, defaultTPECandidates
+	objective := func(xs []float64) float64 {
+		return -math.Pow(xs[0]-3, 2) - math.Pow((xs[1]-70)/10, 2)
+	}
+
+	// over seeds, 60 trials find a better optimum than 60 uniform draws
+	tpeBest, uniformBest := 0.0, 0.0
+	for seed := int64(0); seed < 20; seed++ {
+		rand.Seed(seed)
+		trials := make([]TPETrial, 0)
+		bt, bu := math.Inf(-1), math.Inf(-1)
+		for i := 0; i < 60; i++ {
+			var xs []float64
+			if i < defaultTPEStartup {
+				xs = ts.uniform()
+			} else {
+				xs = ts.suggest(trials)
+			}
+			for j, p := range ts.params {
+				if xs[j] < p.Min || xs[j] > p.Max {
+					t.Fatalf("param %s %f is out of [%f, %f]", p.Name, xs[j], p.Min, p.Max)
+				}
+			}
+			if xs[1] != math.Round(xs[1]) {
+				t.Fatalf("expected an integer y, returned %f", xs[1])
+			}
+			trials = append(trials, TPETrial{Values: xs, Fitness: objective(xs)})
+			bt = math.Max(bt, objective(xs))
+			bu = math.Max(bu, objective(ts.uniform()))
+		}
+		tpeBest += bt / 20
+		uniformBest += bu / 20
+	}
+	if tpeBest <= uniformBest {
+		t.Errorf("expected tpe above uniform draws, returned %f <= %f", tpeBest, uniformBest)
+	}
+}
+
+func TestParzen_Density(t *testing.T) {
+	p := Param{Min: 0, Max: 10, Grid: 1}
+	pz := newParzen(p, []float64{2, 2.5, 3})
+	if pz.density(2.5) <= pz.density(8) {
+		t.Errorf("expected density to peak around the observations")
+	}
+
+	// the prior alone is a kernel as wide as the range
+	if pz := newParzen(p, nil); len(pz.mus) != 1 || pz.mus[0] != 5 || pz.sigmas[0] != 10 {
+		t.Errorf("expected a prior kernel at 5 of width 10, returned %v %v", pz.mus, pz.sigmas)
+	}
+}
+
+func TestTPE_Run(t *testing.T) {
+	rand.Seed(131)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	ag := robustnessAgent()
+	orig, _ := ag.Marshal()
+	bu, _ := ag.Backtest(pc)
+
+	res, err := (&TPE{Trials: 16, Startup: 6, Batch: 4}).Run(context.Background(), ag, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if after, _ := ag.Marshal(); string(after) != string(orig) {
+		t.Errorf("expected source agent untouched")
+	}
+	if len(res.Trials) != 16 || len(res.Params) != len(ag.Params()) {
+		t.Fatalf("expected 16 trials of %d params, returned %d of %d", len(ag.Params()), len(res.Trials), len(res.Params))
+	}
+	if res.Trials[0].Fitness != bu.ProfitPerDay() {
+		t.Errorf("expected the agent itself as the first trial")
+	}
+	if res.Fitness < bu.ProfitPerDay() {
+		t.Errorf("expected fitness not below %f, returned %f", bu.ProfitPerDay(), res.Fitness)
+	}
+
+	// structure is kept, only params move
+	if len(res.Agent.Indicators) != len(ag.Indicators) || res.Agent.Rule != nil {
+		t.Errorf("expected the agent structure kept")
+	}
+	rbu, _ := res.Agent.Backtest(pc)
+	if rbu.ProfitPerDay() != res.Fitness || !tradesEqual(rbu.Trades, res.Bucket.Trades) {
+		t.Errorf("expected the bucket of the returned agent")
+	}
+}
+
+func TestTPE_PrunedTrials(t *testing.T) {
+	rand.Seed(151)
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	ev := &Evaluator{Prune: &PruneRules{MinProfitPerDay: 1e9, MinProfitPerDayAfterMillis: 1}}
+
+	res, err := (&TPE{Trials: 12, Startup: 4, Evaluator: ev}).Run(context.Background(), robustnessAgent(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for _, tr := range res.Trials {
+		if !tr.Pruned || tr.Fitness != 0 {
+			t.Errorf("expected a pruned trial without fitness, returned %v", tr)
+		}
+	}
+	if !res.Bucket.Pruned || res.Fitness != 0 {
+		t.Errorf("expected a pruned best without fitness, returned %f", res.Fitness)
+	}
+	if _, err := json.Marshal(res); err != nil {
+		t.Errorf("expected a json result but raised %v", err)
+	}
+
+	// unpruned trials rank above pruned ones whatever their fitness
+	pruned, lost := &TPETrial{Pruned: true}, &TPETrial{Fitness: -1}
+	if !lost.better(pruned) || pruned.better(lost) {
+		t.Errorf("expected pruned trials ranked last")
+	}
+}
+
+func TestTPE_TakeProfitAndStopLossJointly(t *testing.T) {
+	rand.Seed(157)
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+	ag := robustnessAgent()
+
+	res, err := (&TPE{Trials: 40, Startup: 20, Batch: 4}).Run(context.Background(), ag, pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	// the source agent bounds its stop loss by its take profit of 0.015
+	wider := false
+	for _, tr := range res.Trials {
+		if tr.Values[0] < tr.Values[1] {
+			t.Errorf("expected tp at or above sl, returned %f < %f", tr.Values[0], tr.Values[1])
+		}
+		wider = wider || tr.Values[1] > ag.Tpsl.TakeProfit
+	}
+	if !wider {
+		t.Errorf("expected stop losses above the source take profit tried")
+	}
+}
+
+func TestTPESampler_AgentSetsTPSL(t *testing.T) {
+	ag := robustnessAgent()
+	ts := newTPESampler(ag.Params())
+	if ts.params[ts.sl].Max != maxTPSL || ts.params[ts.tp].Min != minTPSL {
+		t.Fatalf("expected tpsl params over their whole range")
+	}
+
+	xs := ts.values(ag)
+	xs[ts.tp], xs[ts.sl] = 0.012, 0.025
+	c := ts.agent(ag, xs)
+	if c.Tpsl.TakeProfit != 0.025 || c.Tpsl.StopLoss != 0.012 || xs[ts.tp] != 0.025 || xs[ts.sl] != 0.012 {
+		t.Errorf("expected the pair swapped on the agent and values, returned %v %v", c.Tpsl, xs)
+	}
+}
+
+func TestTPE_NoTrials(t *testing.T) {
+	if _, err := (&TPE{Trials: 1}).Run(context.Background(), robustnessAgent(), nil); err != ErrTrialsAreBelowTwo {
+		t.Errorf("expected %v, returned %v", ErrTrialsAreBelowTwo, err)
+	}
+}
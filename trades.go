@@ -47,6 +47,11 @@ type Bucket struct {
 	StartTime int64    `json:"start_time"`
 	EndTime   int64    `json:"end_time"`
 	Trades    []*Trade `json:"trades"`
+	// Pruned buckets were cut at EndTime by PruneRules
+	Pruned bool `json:"pruned,omitempty"`
+	// PurgedMillis of the span were left out by cross validation, they
+	// don't count as bucket days
+	PurgedMillis int64 `json:"purged_mls,omitempty"`
 }
 
 func NewBucket(startTime int64, endTime int64) (*Bucket, error) {
@@ -100,8 +105,13 @@ func (bu *Bucket) HitRatio() float64 {
 	return roundTo4d(hr)
 }
 
+// ProfitPerDay is 0 for a bucket without days, such as one purged
+// entirely
 func (bu *Bucket) ProfitPerDay() float64 {
-	days, totalProfit := float64(bu.EndTime-bu.StartTime)/dayLenMillis, 0.0
+	days, totalProfit := float64(bu.EndTime-bu.StartTime-bu.PurgedMillis)/dayLenMillis, 0.0
+	if days <= 0 {
+		return 0
+	}
 	for _, tr := range bu.Trades {
 		totalProfit += tr.NetProfit
 	}
@@ -110,6 +120,26 @@ func (bu *Bucket) ProfitPerDay() float64 {
 	return roundTo4d(ppd)
 }
 
+// MaxDrawdown is the largest drop of cumulative net profit from its
+// previous peak, over closed trades
+func (bu *Bucket) MaxDrawdown() float64 {
+	profits := make([]float64, len(bu.Trades))
+	for i, tr := range bu.Trades {
+		profits[i] = tr.NetProfit
+	}
+	return maxDrawdown(profits)
+}
+
+func maxDrawdown(profits []float64) float64 {
+	var cum, peak, mdd float64
+	for _, p := range profits {
+		cum += p
+		peak = math.Max(peak, cum)
+		mdd = math.Max(mdd, peak-cum)
+	}
+	return roundTo4d(mdd)
+}
+
 func roundTo4d(val float64) float64 {
 	return math.Round(val*10_000.0) / 10_000.0
 }
@@ -210,3 +210,46 @@ func TestBucket_ProfitPerDay_WithTrades(t *testing.T) {
 		t.Errorf("unexpected profit per day")
 	}
 }
+
+func TestBucket_ProfitPerDay_Purged(t *testing.T) {
+	bu, _ := NewBucket(0, int64(dayLenMillis)*3)
+	bu.PurgedMillis = int64(dayLenMillis)
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: 30.0})
+
+	expected := 15.0000
+	if bu.ProfitPerDay() != expected {
+		t.Errorf("expected %.4f but returned %.4f", expected, bu.ProfitPerDay())
+	}
+}
+
+func TestBucket_ProfitPerDay_FullyPurged(t *testing.T) {
+	bu, _ := NewBucket(0, int64(dayLenMillis))
+	bu.PurgedMillis = int64(dayLenMillis)
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: 30.0})
+
+	if bu.ProfitPerDay() != 0 {
+		t.Errorf("expected 0 but returned %.4f", bu.ProfitPerDay())
+	}
+}
+
+func TestBucket_MaxDrawdown(t *testing.T) {
+	bu, _ := NewBucket(1, 2)
+	for _, np := range []float64{5, -2, 3, -4, -3, 10, -1} {
+		bu.Trades = append(bu.Trades, &Trade{NetProfit: np})
+	}
+
+	// peak 6 after the third trade, down to -1
+	expected := 7.0
+	if bu.MaxDrawdown() != expected {
+		t.Errorf("expected %.4f but returned %.4f", expected, bu.MaxDrawdown())
+	}
+}
+
+func TestBucket_MaxDrawdown_LosingFromStart(t *testing.T) {
+	bu, _ := NewBucket(1, 2)
+	bu.Trades = append(bu.Trades, &Trade{NetProfit: -2}, &Trade{NetProfit: -1.5})
+
+	if bu.MaxDrawdown() != 3.5 {
+		t.Errorf("expected %.4f but returned %.4f", 3.5, bu.MaxDrawdown())
+	}
+}
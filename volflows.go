@@ -0,0 +1,459 @@
+package agent2
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/varga-lp/data/klines"
+)
+
+// volume flow indicators read the raw klines of a leg instead of a
+// monitor series, as they need price and volume of the same bar.
+// pair (LegR) values are the difference of the leg values, mfi is
+// rescaled to stay in [0, 100]
+
+type Leg uint8
+
+const (
+	Leg1 Leg = iota
+	Leg2
+	LegR
+)
+
+func randLeg() Leg {
+	return Leg(rand.Intn(3))
+}
+
+const (
+	mfiKind  = "mfi"
+	obvKind  = "obv"
+	vwapKind = "vwap"
+)
+
+const (
+	maxOBVSlope  = float64(0.50)
+	obvSlopeStep = float64(0.01)
+	maxVWAPDev   = float64(0.020)  // %2.0
+	vwapDevStep  = float64(0.0005) // %0.05
+)
+
+func randStepped(max float64, step float64) float64 {
+	r := rand.Float64()*2*max - max
+	m := (1.0 / step)
+
+	return math.Round(r*m) / m
+}
+
+func legValue(leg Leg, klns1 []klines.Kline, klns2 []klines.Kline,
+	calc func([]klines.Kline) (float64, error)) (float64, float64, error) {
+	switch leg {
+	case Leg1:
+		v, err := calc(klns1)
+		return v, 0, err
+	case Leg2:
+		v, err := calc(klns2)
+		return v, 0, err
+	case LegR:
+		v1, err := calc(klns1)
+		if err != nil {
+			return 0, 0, err
+		}
+		v2, err := calc(klns2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return v1, v2, nil
+	}
+	return 0, 0, fmt.Errorf("leg %d is not defined", leg)
+}
+
+func compareTarget(valPos ValuePos, val float64, target float64) (bool, error) {
+	switch valPos {
+	case Above:
+		return val > target, nil
+	case Below:
+		return val < target, nil
+	}
+	return false, fmt.Errorf("valuePos %v is not defined", valPos)
+}
+
+// flow indicators report the volume monitor of the leg they read
+func (l Leg) monitor() Monitor {
+	switch l {
+	case Leg1:
+		return Volume1
+	case Leg2:
+		return Volume2
+	}
+	return VolumeR
+}
+
+// signedDistance is positive when val is on the valPos side of target
+func signedDistance(valPos ValuePos, val float64, target float64) (float64, error) {
+	switch valPos {
+	case Above:
+		return val - target, nil
+	case Below:
+		return target - val, nil
+	}
+	return 0, fmt.Errorf("valuePos %v is not defined", valPos)
+}
+
+func typicalPrice(kln klines.Kline) float64 {
+	return (kln.High + kln.Low + kln.Close) / 3.0
+}
+
+type MFI struct {
+	Leg       Leg      `json:"leg"`
+	ValuePos  ValuePos `json:"val_pos"`
+	TargetVal float64  `json:"target_val"`
+	Period    int      `json:"period"`
+	Timeframe int      `json:"tf,omitempty"`
+}
+
+func RandomMFI() *MFI {
+	return &MFI{
+		Leg:       randLeg(),
+		ValuePos:  ValuePos(rand.Intn(2)),
+		TargetVal: randTargetVal(),
+		Period:    randPeriod(),
+		Timeframe: randTimeframe(),
+	}
+}
+
+func (mfi *MFI) Kind() string {
+	return mfiKind
+}
+
+func (mfi *MFI) Lookback() int {
+	return mfi.Period
+}
+
+func (mfi *MFI) Monitor() Monitor {
+	return mfi.Leg.monitor()
+}
+
+func (mfi *MFI) TimeframeMult() int {
+	return mfi.Timeframe
+}
+
+func (mfi *MFI) Params() []Param {
+	return append([]Param{
+		periodParam(&mfi.Period),
+		floatParam("target_val", &mfi.TargetVal, targetValParamStep, 1, minTVal, maxTVal),
+	}, timeframeParams(&mfi.Timeframe)...)
+}
+
+func (mfi *MFI) Clone() Indicator {
+	c := *mfi
+	return &c
+}
+
+func (mfi *MFI) Mutate() {
+	if mutatesTimeframe(5) {
+		mfi.Timeframe = mutateTimeframe(mfi.Timeframe)
+		return
+	}
+	switch rand.Intn(4) {
+	case 0:
+		mfi.Leg = randLeg()
+	case 1:
+		mfi.ValuePos = 1 - mfi.ValuePos
+	case 2:
+		mfi.TargetVal = mutateStepped(mfi.TargetVal, 1, minTVal, maxTVal)
+	case 3:
+		mfi.Period = mutatePeriod(mfi.Period)
+	}
+}
+
+func calcMfi(klns []klines.Kline) (float64, error) {
+	if len(klns) < 2 {
+		return 0, fmt.Errorf("needs min 2 klines to calculate mfi")
+	}
+
+	var posFlow, negFlow float64
+	prevTp := typicalPrice(klns[0])
+	for i := 1; i < len(klns); i++ {
+		tp := typicalPrice(klns[i])
+		if tp > prevTp {
+			posFlow += tp * klns[i].Volume
+		} else if tp < prevTp {
+			negFlow += tp * klns[i].Volume
+		}
+		prevTp = tp
+	}
+	if negFlow == 0 {
+		return 100, nil
+	}
+	return 100 - (100 / (1 + (posFlow / negFlow))), nil
+}
+
+func (mfi *MFI) value(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	if err := checkKlinesLen(mfi.Period, klns1, klns2); err != nil {
+		return 0, err
+	}
+
+	v1, v2, err := legValue(mfi.Leg, klns1, klns2, calcMfi)
+	if err != nil {
+		return 0, err
+	}
+	if mfi.Leg == LegR {
+		return 50 + (v1-v2)/2, nil
+	}
+	return v1, nil
+}
+
+func (mfi *MFI) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	v, err := mfi.value(klns1, klns2)
+	if err != nil {
+		return false, err
+	}
+	return compareTarget(mfi.ValuePos, v, mfi.TargetVal)
+}
+
+// Score is the distance of the value to the target in mfi points
+func (mfi *MFI) Score(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	v, err := mfi.value(klns1, klns2)
+	if err != nil {
+		return 0, err
+	}
+	return signedDistance(mfi.ValuePos, v, mfi.TargetVal)
+}
+
+// OBV tracks the least squares slope of on balance volume over the
+// window, normalized by the mean volume, 1.0 means every bar closed up
+type OBV struct {
+	Leg         Leg      `json:"leg"`
+	ValuePos    ValuePos `json:"val_pos"`
+	TargetSlope float64  `json:"target_slope"`
+	Period      int      `json:"period"`
+	Timeframe   int      `json:"tf,omitempty"`
+}
+
+func RandomOBV() *OBV {
+	return &OBV{
+		Leg:         randLeg(),
+		ValuePos:    ValuePos(rand.Intn(2)),
+		TargetSlope: randStepped(maxOBVSlope, obvSlopeStep),
+		Period:      randPeriod(),
+		Timeframe:   randTimeframe(),
+	}
+}
+
+func (obv *OBV) Kind() string {
+	return obvKind
+}
+
+func (obv *OBV) Lookback() int {
+	return obv.Period
+}
+
+func (obv *OBV) Monitor() Monitor {
+	return obv.Leg.monitor()
+}
+
+func (obv *OBV) TimeframeMult() int {
+	return obv.Timeframe
+}
+
+func (obv *OBV) Params() []Param {
+	return append([]Param{
+		periodParam(&obv.Period),
+		floatParam("target_slope", &obv.TargetSlope, obvSlopeParamStep, obvSlopeStep, -maxOBVSlope, maxOBVSlope),
+	}, timeframeParams(&obv.Timeframe)...)
+}
+
+func (obv *OBV) Clone() Indicator {
+	c := *obv
+	return &c
+}
+
+func (obv *OBV) Mutate() {
+	if mutatesTimeframe(5) {
+		obv.Timeframe = mutateTimeframe(obv.Timeframe)
+		return
+	}
+	switch rand.Intn(4) {
+	case 0:
+		obv.Leg = randLeg()
+	case 1:
+		obv.ValuePos = 1 - obv.ValuePos
+	case 2:
+		obv.TargetSlope = mutateStepped(obv.TargetSlope, obvSlopeStep, -maxOBVSlope, maxOBVSlope)
+	case 3:
+		obv.Period = mutatePeriod(obv.Period)
+	}
+}
+
+func calcObvSlope(klns []klines.Kline) (float64, error) {
+	n := len(klns)
+	if n < 2 {
+		return 0, fmt.Errorf("needs min 2 klines to calculate obv slope")
+	}
+
+	var obv, volSum, sumY, sumXY float64
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if klns[i].Close > klns[i-1].Close {
+				obv += klns[i].Volume
+			} else if klns[i].Close < klns[i-1].Close {
+				obv -= klns[i].Volume
+			}
+		}
+		volSum += klns[i].Volume
+		sumY += obv
+		sumXY += float64(i) * obv
+	}
+	if volSum == 0 {
+		return 0, nil
+	}
+
+	fn := float64(n)
+	meanX := (fn - 1) / 2
+	sxx := fn * (fn*fn - 1) / 12
+	slope := (sumXY - meanX*sumY) / sxx
+
+	return slope / (volSum / fn), nil
+}
+
+func (obv *OBV) value(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	if err := checkKlinesLen(obv.Period, klns1, klns2); err != nil {
+		return 0, err
+	}
+
+	v1, v2, err := legValue(obv.Leg, klns1, klns2, calcObvSlope)
+	if err != nil {
+		return 0, err
+	}
+	return v1 - v2, nil
+}
+
+func (obv *OBV) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	v, err := obv.value(klns1, klns2)
+	if err != nil {
+		return false, err
+	}
+	return compareTarget(obv.ValuePos, v, obv.TargetSlope)
+}
+
+// Score is the distance of the value to the target in normalized slope
+func (obv *OBV) Score(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	v, err := obv.value(klns1, klns2)
+	if err != nil {
+		return 0, err
+	}
+	return signedDistance(obv.ValuePos, v, obv.TargetSlope)
+}
+
+// VWAP tracks the relative distance of the last close to the rolling
+// volume weighted average typical price of the window
+type VWAP struct {
+	Leg       Leg      `json:"leg"`
+	ValuePos  ValuePos `json:"val_pos"`
+	TargetDev float64  `json:"target_dev"`
+	Period    int      `json:"period"`
+	Timeframe int      `json:"tf,omitempty"`
+}
+
+func RandomVWAP() *VWAP {
+	return &VWAP{
+		Leg:       randLeg(),
+		ValuePos:  ValuePos(rand.Intn(2)),
+		TargetDev: randStepped(maxVWAPDev, vwapDevStep),
+		Period:    randPeriod(),
+		Timeframe: randTimeframe(),
+	}
+}
+
+func (vw *VWAP) Kind() string {
+	return vwapKind
+}
+
+func (vw *VWAP) Lookback() int {
+	return vw.Period
+}
+
+func (vw *VWAP) Monitor() Monitor {
+	return vw.Leg.monitor()
+}
+
+func (vw *VWAP) TimeframeMult() int {
+	return vw.Timeframe
+}
+
+func (vw *VWAP) Params() []Param {
+	return append([]Param{
+		periodParam(&vw.Period),
+		floatParam("target_dev", &vw.TargetDev, vwapDevParamStep, vwapDevStep, -maxVWAPDev, maxVWAPDev),
+	}, timeframeParams(&vw.Timeframe)...)
+}
+
+func (vw *VWAP) Clone() Indicator {
+	c := *vw
+	return &c
+}
+
+func (vw *VWAP) Mutate() {
+	if mutatesTimeframe(5) {
+		vw.Timeframe = mutateTimeframe(vw.Timeframe)
+		return
+	}
+	switch rand.Intn(4) {
+	case 0:
+		vw.Leg = randLeg()
+	case 1:
+		vw.ValuePos = 1 - vw.ValuePos
+	case 2:
+		vw.TargetDev = mutateStepped(vw.TargetDev, vwapDevStep, -maxVWAPDev, maxVWAPDev)
+	case 3:
+		vw.Period = mutatePeriod(vw.Period)
+	}
+}
+
+func calcVwapDev(klns []klines.Kline) (float64, error) {
+	if len(klns) == 0 {
+		return 0, fmt.Errorf("needs min 1 kline to calculate vwap")
+	}
+
+	var pv, vol float64
+	for _, kln := range klns {
+		pv += typicalPrice(kln) * kln.Volume
+		vol += kln.Volume
+	}
+	if vol == 0 {
+		return 0, nil
+	}
+
+	vwap := pv / vol
+	return (klns[len(klns)-1].Close - vwap) / (vwap + epsilon), nil
+}
+
+func (vw *VWAP) value(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	if err := checkKlinesLen(vw.Period, klns1, klns2); err != nil {
+		return 0, err
+	}
+
+	v1, v2, err := legValue(vw.Leg, klns1, klns2, calcVwapDev)
+	if err != nil {
+		return 0, err
+	}
+	return v1 - v2, nil
+}
+
+func (vw *VWAP) Active(klns1 []klines.Kline, klns2 []klines.Kline) (bool, error) {
+	v, err := vw.value(klns1, klns2)
+	if err != nil {
+		return false, err
+	}
+	return compareTarget(vw.ValuePos, v, vw.TargetDev)
+}
+
+// Score is the distance of the value to the target as a fraction of vwap
+func (vw *VWAP) Score(klns1 []klines.Kline, klns2 []klines.Kline) (float64, error) {
+	v, err := vw.value(klns1, klns2)
+	if err != nil {
+		return 0, err
+	}
+	return signedDistance(vw.ValuePos, v, vw.TargetDev)
+}
@@ -0,0 +1,233 @@
+package agent2
+
+import (
+	"math"
+	"testing"
+
+	"github.com/varga-lp/data/klines"
+)
+
+func constVolKlines(length int) []klines.Kline {
+	klns := dummyKlines(length)
+	for i := range klns {
+		klns[i].Volume = 1000.0
+	}
+	return klns
+}
+
+func TestRandStepped(t *testing.T) {
+	for i := 0; i < 10_000; i++ {
+		r := randStepped(maxVWAPDev, vwapDevStep)
+
+		if r < -maxVWAPDev || r > maxVWAPDev {
+			t.Errorf("stepped %.4f is outside of allowed interval", r)
+		}
+	}
+}
+
+func TestRandMFI_AssignsAllLegs(t *testing.T) {
+	count := 0
+	for _, leg := range []Leg{Leg1, Leg2, LegR} {
+		for {
+			if RandomMFI().Leg == leg {
+				count++
+				break
+			}
+		}
+	}
+	if count != 3 {
+		t.Errorf("not all legs assinged randomly")
+	}
+}
+
+func TestCalcMfi_With1LenKlines(t *testing.T) {
+	if _, err := calcMfi(dummyKlines(1)); err == nil {
+		t.Errorf("expected error but nothing raised")
+	}
+}
+
+func TestCalcMfi_AllUp(t *testing.T) {
+	r, err := calcMfi(dummyKlines(5))
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := 100.0
+	if r != expected {
+		t.Errorf("expected %.2f but returned %.2f", expected, r)
+	}
+}
+
+func TestCalcMfi_OneDown(t *testing.T) {
+	klns := dummyKlines(4)
+	klns[3].High, klns[3].Low, klns[3].Close = 3, 1, 2
+
+	r, _ := calcMfi(klns)
+	expected := 57.14
+
+	if math.Round(r*100.0)/100.0 != expected {
+		t.Errorf("expected %.2f but returned %.2f", expected, r)
+	}
+}
+
+func TestMFI_Active_LegR(t *testing.T) {
+	mfi := &MFI{
+		Leg:       LegR,
+		ValuePos:  Above,
+		TargetVal: 60,
+		Period:    4,
+	}
+
+	klns1, klns2 := dummyKlines(4), dummyKlines(4)
+	klns2[3].High, klns2[3].Low, klns2[3].Close = 3, 1, 2
+
+	// 50 + (100 - 57.14) / 2
+	act, err := mfi.Active(klns1, klns2)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if !act {
+		t.Errorf("expected %v as active, returned %v", true, act)
+	}
+}
+
+func TestMFI_Active_InvalidLen(t *testing.T) {
+	mfi := RandomMFI()
+	mfi.Period = 5
+
+	if _, err := mfi.Active(dummyKlines(4), dummyKlines(5)); err == nil {
+		t.Errorf("expected error nothing raised")
+	}
+}
+
+func TestCalcObvSlope_AllUp(t *testing.T) {
+	s, err := calcObvSlope(constVolKlines(4))
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := 1.0
+	if math.Round(s*10_000.0)/10_000.0 != expected {
+		t.Errorf("expected %.4f but returned %.4f", expected, s)
+	}
+}
+
+func TestCalcObvSlope_ZeroVolume(t *testing.T) {
+	klns := dummyKlines(4)
+	for i := range klns {
+		klns[i].Volume = 0
+	}
+
+	s, _ := calcObvSlope(klns)
+	if s != 0 {
+		t.Errorf("expected 0 but returned %.4f", s)
+	}
+}
+
+func TestOBV_Active_Leg2(t *testing.T) {
+	obv := &OBV{
+		Leg:         Leg2,
+		ValuePos:    Below,
+		TargetSlope: 0,
+		Period:      4,
+	}
+
+	klns1, klns2 := constVolKlines(4), constVolKlines(4)
+	for i := range klns2 {
+		klns2[i].Close = float64(10 - i)
+	}
+
+	act, err := obv.Active(klns1, klns2)
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if !act {
+		t.Errorf("expected %v as active, returned %v", true, act)
+	}
+}
+
+func TestOBV_Active_LegR_Neutral(t *testing.T) {
+	obv := &OBV{
+		Leg:         LegR,
+		ValuePos:    Above,
+		TargetSlope: 0,
+		Period:      4,
+	}
+
+	act, _ := obv.Active(constVolKlines(4), constVolKlines(4))
+	if act {
+		t.Errorf("expected %v as active, returned %v", false, act)
+	}
+}
+
+func TestCalcVwapDev(t *testing.T) {
+	d, err := calcVwapDev(constVolKlines(4))
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+
+	expected := 0.6
+	if math.Round(d*10_000.0)/10_000.0 != expected {
+		t.Errorf("expected %.4f but returned %.4f", expected, d)
+	}
+}
+
+func TestCalcVwapDev_With0LenKlines(t *testing.T) {
+	if _, err := calcVwapDev(nil); err == nil {
+		t.Errorf("expected error but nothing raised")
+	}
+}
+
+func TestVWAP_Active_Leg1(t *testing.T) {
+	vw := &VWAP{
+		Leg:       Leg1,
+		ValuePos:  Above,
+		TargetDev: 0.5,
+		Period:    4,
+	}
+
+	act, err := vw.Active(constVolKlines(4), constVolKlines(4))
+	if err != nil {
+		t.Errorf("expected no error but raised %v", err)
+	}
+	if !act {
+		t.Errorf("expected %v as active, returned %v", true, act)
+	}
+}
+
+func TestVWAP_Active_UndefinedLeg(t *testing.T) {
+	vw := RandomVWAP()
+	vw.Leg = Leg(9)
+	vw.Period = 4
+
+	if _, err := vw.Active(constVolKlines(4), constVolKlines(4)); err == nil {
+		t.Errorf("expected error nothing raised")
+	}
+}
+
+func Benchmark_MFI(b *testing.B) {
+	mfi := RandomMFI()
+	mfi.Period = 250
+
+	for i := 0; i < b.N; i++ {
+		mfi.Active(benchmarkKlns, benchmarkKlns)
+	}
+}
+
+func Benchmark_OBV(b *testing.B) {
+	obv := RandomOBV()
+	obv.Period = 250
+
+	for i := 0; i < b.N; i++ {
+		obv.Active(benchmarkKlns, benchmarkKlns)
+	}
+}
+
+func Benchmark_VWAP(b *testing.B) {
+	vw := RandomVWAP()
+	vw.Period = 250
+
+	for i := 0; i < b.N; i++ {
+		vw.Active(benchmarkKlns, benchmarkKlns)
+	}
+}
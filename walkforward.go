@@ -0,0 +1,158 @@
+package agent2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Fitness scores a bucket, higher is better
+type Fitness func(bu *Bucket) float64
+
+func ProfitPerDayFitness(bu *Bucket) float64 {
+	return bu.ProfitPerDay()
+}
+
+// Search selects agents on a training window
+type Search func(ctx context.Context, train *PairColumns) ([]*Agent, error)
+
+// RandomSearch evaluates count random agents and selects the top ones
+// by fitness, pruned agents are never selected
+func RandomSearch(count int, top int, fitness Fitness, ev *Evaluator) Search {
+	return func(ctx context.Context, train *PairColumns) ([]*Agent, error) {
+		agents := make([]*Agent, count)
+		for i := range agents {
+			agents[i] = RandomAgent()
+		}
+
+		buckets, err := ev.Evaluate(ctx, agents, train)
+		if err != nil {
+			return nil, err
+		}
+		return selectTop(agents, buckets, top, fitness), nil
+	}
+}
+
+func selectTop(agents []*Agent, buckets []*Bucket, top int, fitness Fitness) []*Agent {
+	idx := make([]int, 0, len(agents))
+	scores := make([]float64, len(agents))
+	for i, bu := range buckets {
+		if !bu.Pruned {
+			idx = append(idx, i)
+			scores[i] = fitness(bu)
+		}
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return scores[idx[a]] > scores[idx[b]]
+	})
+
+	res := make([]*Agent, 0, top)
+	for _, i := range idx[:min(top, len(idx))] {
+		res = append(res, agents[i])
+	}
+	return res
+}
+
+// WalkForward searches agents on rolling train windows and backtests
+// them on the test window right after, windows are counted in bars.
+// Step defaults to TestBars so test windows tile the history. Anchored
+// walk forwards keep the training start at the first bar. Test windows
+// are backtested without the Evaluator prune rules, which are meant for
+// the search
+type WalkForward struct {
+	TrainBars int
+	TestBars  int
+	StepBars  int
+	Anchored  bool
+	Search    Search
+	Evaluator *Evaluator
+}
+
+// WalkForwardFold holds the agents selected on [TrainStart, TrainEnd)
+// and their buckets on [TestStart, TestEnd)
+type WalkForwardFold struct {
+	TrainStart int       `json:"train_start"`
+	TrainEnd   int       `json:"train_end"`
+	TestStart  int       `json:"test_start"`
+	TestEnd    int       `json:"test_end"`
+	Agents     []*Agent  `json:"agents"`
+	Buckets    []*Bucket `json:"buckets"`
+}
+
+// WalkForwardResult stitches out of sample trades of every fold into
+// Combined, as if the selected agents had been deployed fold by fold
+type WalkForwardResult struct {
+	Folds    []*WalkForwardFold `json:"folds"`
+	Combined *Bucket            `json:"combined"`
+}
+
+var (
+	ErrWalkForwardIsNotComplete  = fmt.Errorf("walk forward needs a search, train bars of min activation length and test bars")
+	ErrHistoryIsBelowWalkForward = fmt.Errorf("history is shorter than a walk forward train and test window")
+)
+
+func (wf *WalkForward) Run(ctx context.Context, pc *PairColumns) (*WalkForwardResult, error) {
+	if wf.Search == nil || wf.TrainBars < minActivationKlineLength || wf.TestBars < 1 {
+		return nil, ErrWalkForwardIsNotComplete
+	}
+	step := wf.StepBars
+	if step <= 0 {
+		step = wf.TestBars
+	}
+	ev := &Evaluator{}
+	if wf.Evaluator != nil {
+		ev = wf.Evaluator.unpruned()
+	}
+
+	res := &WalkForwardResult{}
+	for trainStart := 0; trainStart+wf.TrainBars+wf.TestBars <= pc.Len(); trainStart += step {
+		fold := &WalkForwardFold{TrainStart: trainStart, TrainEnd: trainStart + wf.TrainBars}
+		if wf.Anchored {
+			fold.TrainStart = 0
+		}
+		fold.TestStart, fold.TestEnd = fold.TrainEnd, fold.TrainEnd+wf.TestBars
+
+		agents, err := wf.Search(ctx, pc.Slice(fold.TrainStart, fold.TrainEnd))
+		if err != nil {
+			return nil, err
+		}
+		fold.Agents = agents
+
+		// indicators warm up on the end of the train window
+		from := max(0, fold.TestStart-warmupBars(agents))
+		fold.Buckets, err = ev.evaluate(ctx, agents, pc.Slice(from, fold.TestEnd), fold.TestStart-from)
+		if err != nil {
+			return nil, err
+		}
+		res.Folds = append(res.Folds, fold)
+	}
+	if len(res.Folds) == 0 {
+		return nil, ErrHistoryIsBelowWalkForward
+	}
+
+	klns1, _ := pc.Klines(0, pc.Len())
+	first, last := res.Folds[0], res.Folds[len(res.Folds)-1]
+	combined, err := NewBucket(klns1[first.TestStart].OpenTime, klns1[last.TestEnd-1].CloseTime)
+	if err != nil {
+		return nil, err
+	}
+	for _, fold := range res.Folds {
+		for _, bu := range fold.Buckets {
+			combined.Trades = append(combined.Trades, bu.Trades...)
+		}
+	}
+	sort.SliceStable(combined.Trades, func(i, j int) bool {
+		return combined.Trades[i].OpenTime < combined.Trades[j].OpenTime
+	})
+	res.Combined = combined
+	return res, nil
+}
+
+// warmupBars is the number of bars agents need before their first signal
+func warmupBars(agents []*Agent) int {
+	res := minActivationKlineLength
+	for _, ag := range agents {
+		res = max(res, ag.lookback())
+	}
+	return res - 1
+}
@@ -0,0 +1,170 @@
+package agent2
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+// fixedSearch returns the same agents on every train window
+func fixedSearch(agents []*Agent, trains *[][2]int64) Search {
+	return func(ctx context.Context, train *PairColumns) ([]*Agent, error) {
+		*trains = append(*trains, [2]int64{train.Leg1.OpenTime[0], int64(train.Len())})
+		return agents, nil
+	}
+}
+
+func TestWalkForward_Rolling(t *testing.T) {
+	rand.Seed(41)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	agents := evaluatorAgents(6)
+
+	var trains [][2]int64
+	wf := &WalkForward{TrainBars: 500, TestBars: 300, Search: fixedSearch(agents, &trains)}
+	res, err := wf.Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Folds) != 5 || len(trains) != 5 {
+		t.Fatalf("expected 5 folds, returned %d", len(res.Folds))
+	}
+
+	for i, fold := range res.Folds {
+		if fold.TrainStart != i*300 || fold.TestStart != fold.TrainStart+500 || fold.TestEnd != fold.TestStart+300 {
+			t.Errorf("unexpected fold %d windows %+v", i, fold)
+		}
+		if trains[i][0] != pc.Leg1.OpenTime[fold.TrainStart] || trains[i][1] != 500 {
+			t.Errorf("fold %d searched on %d bars from %d", i, trains[i][1], trains[i][0])
+		}
+		if len(fold.Buckets) != len(agents) {
+			t.Fatalf("expected %d buckets, returned %d", len(agents), len(fold.Buckets))
+		}
+		for _, bu := range fold.Buckets {
+			if bu.StartTime != pc.Leg1.OpenTime[fold.TestStart] {
+				t.Errorf("expected bucket start %d, returned %d", pc.Leg1.OpenTime[fold.TestStart], bu.StartTime)
+			}
+			for _, tr := range bu.Trades {
+				if tr.OpenTime < bu.StartTime || tr.CloseTime > bu.EndTime {
+					t.Errorf("trade %v is out of test window [%d, %d]", tr, bu.StartTime, bu.EndTime)
+				}
+			}
+		}
+	}
+
+	comb := res.Combined
+	if comb.StartTime != pc.Leg1.OpenTime[500] || comb.EndTime != pc.Leg1.CloseTime[1999] {
+		t.Errorf("unexpected combined window [%d, %d]", comb.StartTime, comb.EndTime)
+	}
+	if len(comb.Trades) == 0 {
+		t.Fatalf("expected out of sample trades")
+	}
+	for i := 1; i < len(comb.Trades); i++ {
+		if comb.Trades[i].OpenTime < comb.Trades[i-1].OpenTime {
+			t.Fatalf("combined trades are not sorted at %d", i)
+		}
+	}
+}
+
+func TestWalkForward_TestsAreNotPruned(t *testing.T) {
+	rand.Seed(41)
+	pc, _ := NewPairColumns(randomWalkKlines(2_000, 1), randomWalkKlines(2_000, 2))
+	agents := evaluatorAgents(6)
+
+	var trains [][2]int64
+	plain, err := (&WalkForward{TrainBars: 500, TestBars: 300, Search: fixedSearch(agents, &trains)}).Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+
+	// rules pruning every agent leave the test windows untouched
+	prune := &PruneRules{MinProfitPerDay: 1e9, MinProfitPerDayAfterMillis: 1}
+	wf := &WalkForward{TrainBars: 500, TestBars: 300, Search: fixedSearch(agents, &trains),
+		Evaluator: &Evaluator{Prune: prune}}
+	res, err := wf.Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	for _, fold := range res.Folds {
+		for _, bu := range fold.Buckets {
+			if bu.Pruned {
+				t.Fatalf("expected out of sample buckets not pruned")
+			}
+		}
+	}
+	if !tradesEqual(res.Combined.Trades, plain.Combined.Trades) {
+		t.Errorf("expected the combined trades of an unpruned run")
+	}
+}
+
+func TestWalkForward_Anchored(t *testing.T) {
+	rand.Seed(43)
+	pc, _ := NewPairColumns(randomWalkKlines(1_500, 1), randomWalkKlines(1_500, 2))
+
+	var trains [][2]int64
+	wf := &WalkForward{TrainBars: 500, TestBars: 400, StepBars: 200, Anchored: true,
+		Search: fixedSearch(evaluatorAgents(2), &trains)}
+	res, err := wf.Run(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(res.Folds) != 4 {
+		t.Fatalf("expected 4 folds, returned %d", len(res.Folds))
+	}
+	for i, fold := range res.Folds {
+		if fold.TrainStart != 0 || fold.TrainEnd != 500+i*200 || trains[i][1] != int64(fold.TrainEnd) {
+			t.Errorf("unexpected anchored fold %d windows %+v", i, fold)
+		}
+	}
+}
+
+func TestWalkForward_Errors(t *testing.T) {
+	pc, _ := NewPairColumns(randomWalkKlines(600, 1), randomWalkKlines(600, 2))
+	var trains [][2]int64
+	search := fixedSearch(nil, &trains)
+
+	for _, wf := range []*WalkForward{
+		{TrainBars: 500, TestBars: 50},
+		{TrainBars: 249, TestBars: 50, Search: search},
+		{TrainBars: 500, Search: search},
+	} {
+		if _, err := wf.Run(context.Background(), pc); err != ErrWalkForwardIsNotComplete {
+			t.Errorf("expected %v, returned %v", ErrWalkForwardIsNotComplete, err)
+		}
+	}
+
+	wf := &WalkForward{TrainBars: 500, TestBars: 101, Search: search}
+	if _, err := wf.Run(context.Background(), pc); err != ErrHistoryIsBelowWalkForward {
+		t.Errorf("expected %v, returned %v", ErrHistoryIsBelowWalkForward, err)
+	}
+}
+
+func TestRandomSearch_SelectsTop(t *testing.T) {
+	rand.Seed(47)
+	pc, _ := NewPairColumns(randomWalkKlines(1_000, 1), randomWalkKlines(1_000, 2))
+
+	agents, err := RandomSearch(20, 3, ProfitPerDayFitness, &Evaluator{})(context.Background(), pc)
+	if err != nil {
+		t.Fatalf("expected no error but raised %v", err)
+	}
+	if len(agents) != 3 {
+		t.Errorf("expected 3 agents, returned %d", len(agents))
+	}
+}
+
+func TestSelectTop(t *testing.T) {
+	agents := evaluatorAgents(4)
+	buckets := make([]*Bucket, 4)
+	for i, profit := range []float64{0.1, 0.4, 0.3, 0.2} {
+		buckets[i] = &Bucket{Trades: []*Trade{{NetProfit: profit}}}
+	}
+	buckets[1].Pruned = true
+	fitness := func(bu *Bucket) float64 { return bu.Trades[0].NetProfit }
+
+	res := selectTop(agents, buckets, 2, fitness)
+	if len(res) != 2 || res[0] != agents[2] || res[1] != agents[3] {
+		t.Errorf("expected agents 2, 3 selected")
+	}
+	if res := selectTop(agents, buckets, 10, fitness); len(res) != 3 {
+		t.Errorf("expected 3 unpruned agents, returned %d", len(res))
+	}
+}